@@ -2,6 +2,7 @@ package gosmee
 
 import (
 	"context"
+	"crypto/ed25519"
 	_ "embed"
 	"fmt"
 	"io"
@@ -27,18 +28,28 @@ var bashCompletion []byte
 const DefaultPublicHookURL = "https://hook.pipelinesascode.com/new"
 
 func getLogger(c *cli.Context) (*slog.Logger, bool, error) {
+	return getLoggerTo(c, os.Stdout, false)
+}
+
+// getLoggerTo returns the logger of the --output format writing to w. The
+// "table" and "yaml" formats are only accepted for listings, which log as
+// "pretty" and "json" with them.
+func getLoggerTo(c *cli.Context, w *os.File, listing bool) (*slog.Logger, bool, error) {
 	nocolor := c.Bool("nocolor")
-	w := os.Stdout
 	var level slog.Level
 	if err := level.UnmarshalText([]byte(c.String("log-level"))); err != nil {
 		return nil, false, fmt.Errorf("invalid log level %q: %w", c.String("log-level"), err)
 	}
+	output := c.String("output")
+	if !listing && (output == "yaml" || output == "table") {
+		return nil, false, fmt.Errorf("invalid output format %s, must be json or pretty, %s is only for listings", output, output)
+	}
 	var logger *slog.Logger
-	switch c.String("output") {
-	case "json":
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	switch output {
+	case "json", "yaml":
+		logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
 		nocolor = true
-	case "pretty":
+	case "pretty", "table":
 		logger = slog.New(tint.NewTextHandler(w, &tint.Options{
 			TimeFormat: time.RFC1123,
 			NoColor:    !isatty.IsTerminal(w.Fd()),
@@ -154,6 +165,64 @@ non-publicly accessible endpoint, forward those requests to your local service.`
 				},
 				Flags: mergeFlags(commonFlags, replayFlags...),
 			},
+			{
+				Name:  "events",
+				Usage: "Browse and replay payloads saved with --saveDir",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "List saved events",
+						Before: makeBeforeHook("events"),
+						Action: func(c *cli.Context) error {
+							e, err := newEventsOpts(c)
+							if err != nil {
+								return err
+							}
+							return e.list()
+						},
+						Flags: eventsFlags,
+					},
+					{
+						Name:      "show",
+						Usage:     "Show the headers and payload of a saved event",
+						Before:    makeBeforeHook("events"),
+						ArgsUsage: "NAME|DELIVERY_ID",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return fmt.Errorf("need a saved event name or delivery ID")
+							}
+							e, err := newEventsOpts(c)
+							if err != nil {
+								return err
+							}
+							return e.show(c.Args().First())
+						},
+						Flags: eventsFlags,
+					},
+					{
+						Name:      "replay",
+						Usage:     "Replay saved events to a target URL",
+						Before:    makeBeforeHook("events"),
+						ArgsUsage: "TARGET_URL [NAME|DELIVERY_ID...]",
+						Action:    eventsReplay,
+						Flags:     mergeFlags(eventsFlags, eventsReplayFlags...),
+					},
+					{
+						Name:      "export",
+						Usage:     "Convert saved events to HAR, Postman, .http, raw HTTP or replay scripts",
+						Before:    makeBeforeHook("events"),
+						ArgsUsage: "[NAME|DELIVERY_ID...]",
+						Action: func(c *cli.Context) error {
+							e, err := newEventsOpts(c)
+							if err != nil {
+								return err
+							}
+							return e.export(c.String("format"), c.String("out"), c.String("target-url"), c.Args().Slice())
+						},
+						Flags: mergeFlags(eventsFlags, eventsExportFlags...),
+					},
+				},
+			},
 			{
 				Name:   "server",
 				Usage:  "Make gosmee a relay server from your external webhook",
@@ -164,27 +233,34 @@ non-publicly accessible endpoint, forward those requests to your local service.`
 					}
 					return serve(c)
 				},
-				Flags: serverFlags,
+				Flags: mergeFlags(serverFlags, tracingFlags...),
 			},
 			{
 				Name:   "keygen",
 				Usage:  "Generate a client encryption keypair and print the public key",
 				Before: makeBeforeHook("keygen"),
-				Action: func(c *cli.Context) error {
-					if c.String("key-file") == "" {
-						return fmt.Errorf("required flag \"key-file\" not set")
-					}
-					publicKey, privateKey, err := GenerateKeyPair()
-					if err != nil {
-						return err
-					}
-					if err := SaveKeyPair(c.String("key-file"), publicKey, privateKey); err != nil {
-						return err
-					}
-					fmt.Fprintln(os.Stdout, EncodePublicKey(publicKey))
-					return nil
+				Action: keygen,
+				Flags:  keygenFlags,
+			},
+			{
+				Name:  "key",
+				Usage: "Manage client encryption key files",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "change-passphrase",
+						Usage:  "Encrypt a client key file with a new passphrase, plain key files get encrypted",
+						Before: makeBeforeHook("keygen"),
+						Action: func(c *cli.Context) error {
+							if c.String("key-file") == "" {
+								return fmt.Errorf("required flag \"key-file\" not set")
+							}
+							return ChangeKeyPassphrase(c.String("key-file"), NewPassphraseFunc(c.String("key-passphrase-file")), func() ([]byte, error) {
+								return NewKeyPassphrase(c.String("new-key-passphrase-file"), newKeyPassphraseEnv)
+							})
+						},
+						Flags: keyPassphraseFlags,
+					},
 				},
-				Flags: keygenFlags,
 			},
 			{
 				Name:      "client",
@@ -240,32 +316,78 @@ non-publicly accessible endpoint, forward those requests to your local service.`
 						serveHealthEndpoint(healthPort, logger, decorate)
 					}
 
+					transforms, err := newRequestTransformsFromFlags(c)
+					if err != nil {
+						return err
+					}
+					archive, err := newEventArchiveFromFlags(c)
+					if err != nil {
+						return err
+					}
+					sseEncodings, err := parseSSECompression(c.String("sse-compression"))
+					if err != nil {
+						return err
+					}
+					transport, err := parseTransport(c.String("transport"))
+					if err != nil {
+						return err
+					}
+					relayAuth, err := newRelayAuthFromFlags(c)
+					if err != nil {
+						return err
+					}
+					shutdownTracing, err := setupTracingFromFlags(c, "gosmee-client")
+					if err != nil {
+						return err
+					}
+					defer shutdownTracing()
+					var serverSigningKey ed25519.PublicKey
+					if encoded := c.String("server-signing-key"); encoded != "" {
+						if c.String("encryption-key-file") == "" {
+							return fmt.Errorf("--server-signing-key requires --encryption-key-file")
+						}
+						if serverSigningKey, err = ParseSigningPublicKey(encoded); err != nil {
+							return fmt.Errorf("invalid --server-signing-key: %w", err)
+						}
+					}
+
 					cfg := goSmee{
 						replayDataOpts: &replayDataOpts{
-							smeeURL:           smeeURL,
-							targetURL:         targetURL,
-							localDebugURL:     localDebugURL,
-							saveDir:           c.String("saveDir"),
-							noReplay:          noReplay,
-							decorate:          decorate,
-							ignoreEvents:      c.StringSlice("ignore-event"),
-							targetCnxTimeout:  c.Int("target-connection-timeout"),
-							targetRetries:     c.Int("target-retries"),
-							insecureTLSVerify: c.Bool("insecure-skip-tls-verify"),
-							useHttpie:         c.Bool("httpie"),
-							sseBufferSize:     c.Int("sse-buffer-size"),
-							execCommand:       c.String("exec"),
-							execOnEvents:      c.StringSlice("exec-on-events"),
-							execEnvVars:       c.StringSlice("exec-env-vars"),
-							encryptionKeyFile: c.String("encryption-key-file"),
-							resumeStateFile:   c.String("resume-state-file"),
+							smeeURL:               smeeURL,
+							targetURL:             targetURL,
+							localDebugURL:         localDebugURL,
+							saveDir:               c.String("saveDir"),
+							noReplay:              noReplay,
+							decorate:              decorate,
+							ignoreEvents:          c.StringSlice("ignore-event"),
+							targetCnxTimeout:      c.Int("target-connection-timeout"),
+							targetRetries:         c.Int("target-retries"),
+							insecureTLSVerify:     c.Bool("insecure-skip-tls-verify"),
+							useHttpie:             c.Bool("httpie"),
+							exportFormats:         c.StringSlice("export-format"),
+							sseBufferSize:         c.Int("sse-buffer-size"),
+							sseEncodings:          sseEncodings,
+							transport:             transport,
+							execCommand:           c.String("exec"),
+							execOnEvents:          c.StringSlice("exec-on-events"),
+							execEnvVars:           c.StringSlice("exec-env-vars"),
+							encryptionKeyFile:     c.String("encryption-key-file"),
+							nextEncryptionKeyFile: c.String("next-encryption-key-file"),
+							keyPassphraseFile:     c.String("key-passphrase-file"),
+							serverSigningKey:      serverSigningKey,
+							resumeStateFile:       c.String("resume-state-file"),
+							relayAuth:             relayAuth,
+							transforms:            transforms,
+							saveOriginal:          c.Bool("save-original"),
+							resignSecret:          c.String("resign-secret"),
+							archive:               archive,
 						},
 						logger:  logger,
 						channel: c.String("channel"),
 					}
 					return cfg.clientSetup()
 				},
-				Flags: mergeFlags(commonFlags, clientFlags...),
+				Flags: mergeFlags(mergeFlags(commonFlags, clientFlags...), tracingFlags...),
 			},
 			{
 				Name:  "completion",
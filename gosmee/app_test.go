@@ -1,10 +1,15 @@
 package gosmee
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+
+	"github.com/urfave/cli/v2"
+	"gotest.tools/v3/assert"
 )
 
 func TestGetNewHookURL_Success(t *testing.T) {
@@ -25,3 +30,25 @@ func TestGetNewHookURL_Success(t *testing.T) {
 		t.Errorf("getNewHookURL() output = %q, want %q", output, expectedURL)
 	}
 }
+
+func TestGetLoggerToOutputFormats(t *testing.T) {
+	newContext := func(output string) *cli.Context {
+		flagSet := flag.NewFlagSet("test", 0)
+		flagSet.String("output", output, "doc")
+		flagSet.String("log-level", "info", "doc")
+		flagSet.Bool("nocolor", false, "doc")
+		return cli.NewContext(cli.NewApp(), flagSet, nil)
+	}
+	for _, output := range []string{"json", "pretty"} {
+		_, _, err := getLoggerTo(newContext(output), os.Stderr, false)
+		assert.NilError(t, err)
+	}
+	for _, output := range []string{"yaml", "table"} {
+		_, _, err := getLoggerTo(newContext(output), os.Stderr, false)
+		assert.ErrorContains(t, err, "only for listings")
+		_, _, err = getLoggerTo(newContext(output), os.Stderr, true)
+		assert.NilError(t, err)
+	}
+	_, _, err := getLoggerTo(newContext("xml"), os.Stderr, true)
+	assert.ErrorContains(t, err, "invalid output format xml")
+}
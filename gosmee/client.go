@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -21,13 +22,16 @@ import (
 	"slices"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	_ "embed"
 
 	"github.com/mgutz/ansi"
 	"github.com/mitchellh/mapstructure"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -63,16 +67,21 @@ type payloadMsg struct {
 	headers     map[string]string
 	body        []byte
 	timestamp   string
+	receivedAt  time.Time // when the server received the event, zero when unknown
 	contentType string
 	eventType   string
 	eventID     string
 	streamID    string
+	targetURL   string // overrides replayDataOpts.targetURL when set by a transform
 }
 
 type clientSSEEvent struct {
 	ID    string
 	Event string
 	Data  []byte
+	// receivedAt is set on the first processing attempt, retries check the
+	// signed envelope against it
+	receivedAt time.Time
 }
 
 type clientProcessingError struct {
@@ -203,6 +212,7 @@ func (c goSmee) parse(now time.Time, data []byte) (payloadMsg, error) {
 	}
 
 	pm.timestamp = dt.Format(tsFormat)
+	pm.receivedAt = dt
 
 	// If there are no headers but we have content-type, ensure at least that header exists
 	if len(pm.headers) == 0 && pm.contentType != "" {
@@ -248,9 +258,16 @@ func buildCurlHeaders(headers map[string]string) string {
 }
 
 func saveData(rd *replayDataOpts, logger *slog.Logger, pm payloadMsg) error {
+	_, err := saveEvent(rd, logger, pm)
+	return err
+}
+
+// saveEvent saves pm in --saveDir, as files or as a record of the JSONL
+// archive, and returns the name it was saved under.
+func saveEvent(rd *replayDataOpts, logger *slog.Logger, pm payloadMsg) (string, error) {
 	if _, err := os.Stat(rd.saveDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(rd.saveDir, 0o755); err != nil {
-			return err
+			return "", err
 		}
 	}
 
@@ -258,56 +275,94 @@ func saveData(rd *replayDataOpts, logger *slog.Logger, pm payloadMsg) error {
 	if pm.eventType != "" {
 		fbasepath = fmt.Sprintf("%s-%s", pm.eventType, pm.timestamp)
 	}
+	meta := newSavedEventMeta(rd, pm)
+
+	if rd.archive != nil {
+		name, err := rd.archive.Save(fbasepath, meta, pm.body)
+		if err != nil {
+			return "", err
+		}
+		logger.InfoContext(context.Background(), fmt.Sprintf("%s%s has been saved to %s", emoji("⌁", "yellow+b", rd.decorate), name, rd.archive.path()))
+		return name, nil
+	}
 
+	fbasepath, err := savedEventBaseName(rd.saveDir, fbasepath, meta)
+	if err != nil {
+		return "", err
+	}
 	jsonfile := fmt.Sprintf("%s/%s.json", rd.saveDir, fbasepath)
-	f, err := os.Create(jsonfile)
+	if err := os.WriteFile(jsonfile, pm.body, 0o644); err != nil { //nolint:gosec // read by the generated replay script
+		return "", err
+	}
+	if err := writeSavedEventMeta(rd.saveDir, fbasepath, meta); err != nil {
+		return "", err
+	}
+
+	paths, err := writeExportFiles(rd, newExportRequest(fbasepath, rd, pm, nil))
 	if err != nil {
-		return err
+		return "", err
+	}
+	logger.InfoContext(context.Background(), fmt.Sprintf("%s%s has been saved", emoji("⌁", "yellow+b", rd.decorate), strings.Join(append(paths, jsonfile), " and ")))
+	return fbasepath, nil
+}
+
+// recordDeliveryResult stores the outcome of forwarding the event saved as
+// name, in its metadata file or as a new record of the archive.
+func recordDeliveryResult(rd *replayDataOpts, name string, pm payloadMsg, result *deliveryResult) error {
+	if rd.archive != nil {
+		meta := newSavedEventMeta(rd, pm)
+		meta.Result = result
+		return rd.archive.Update(name, meta)
 	}
-	defer f.Close()
-	if _, err = f.Write(pm.body); err != nil {
+	meta, err := readSavedEventMeta(rd.saveDir, name)
+	if err != nil {
 		return err
 	}
+	meta.Result = result
+	return writeSavedEventMeta(rd.saveDir, name, meta)
+}
 
-	shscript := fmt.Sprintf("%s/%s.sh", rd.saveDir, fbasepath)
-	logger.InfoContext(context.Background(), fmt.Sprintf("%s%s and %s has been saved", emoji("⌁", "yellow+b", rd.decorate), shscript, jsonfile))
-	s, err := os.Create(shscript)
+func newDeliveryResult(started time.Time, status int, err error) *deliveryResult {
+	result := &deliveryResult{
+		Status:     status,
+		DurationMS: time.Since(started).Milliseconds(),
+		At:         time.Now().UTC(),
+	}
 	if err != nil {
-		return err
+		result.Error = err.Error()
+		var deliveryErr *targetDeliveryError
+		if errors.As(err, &deliveryErr) {
+			result.ErrorKind = deliveryErr.kind
+			result.Status = deliveryErr.status
+		}
 	}
-	defer s.Close()
-
-	var tmpl *template.Template
-	var headers string
-	if rd.useHttpie {
-		tmpl = template.Must(template.New("shellScriptTmplHttpie").Parse(string(shellScriptHttpieTmpl)))
-		headers = buildHttpieHeaders(pm.headers)
-	} else {
-		tmpl = template.Must(template.New("shellScriptTmpl").Parse(string(shellScriptTmpl)))
-		headers = buildCurlHeaders(pm.headers)
-	}
-
-	if err := tmpl.Execute(s, struct {
-		Headers       string
-		TargetURL     string
-		ContentType   string
-		FileBase      string
-		LocalDebugURL string
-	}{
-		Headers: headers,
-		// ContentType comes from the (untrusted) webhook payload, so shell-quote
-		// it to prevent breaking out of the command and injecting shell code.
-		// Headers are quoted in build{Curl,Httpie}Headers above. TargetURL,
-		// LocalDebugURL and FileBase are operator-provided or regex-sanitized
-		// (see pmEventRe) and are quoted with literal "" in the templates.
-		TargetURL:     rd.targetURL,
-		LocalDebugURL: rd.localDebugURL,
-		ContentType:   shellQuote(pm.contentType),
-		FileBase:      fbasepath,
-	}); err != nil {
-		return err
+	return result
+}
+
+// saveAndDeliver saves the event in --saveDir when configured, forwards it
+// unless --noReplay is set and records the delivery result with the saved
+// event. Saving errors are returned wrapped, delivery errors as is.
+func saveAndDeliver(rd *replayDataOpts, logger *slog.Logger, saved, forwarded payloadMsg, failOnHTTPError bool) error {
+	name := ""
+	if rd.saveDir != "" {
+		var err error
+		if name, err = saveEvent(rd, logger, saved); err != nil {
+			return fmt.Errorf("saving message: %w", err)
+		}
+	}
+	if rd.noReplay {
+		return nil
+	}
+
+	started := time.Now()
+	status, err := deliverPayload(rd, logger, forwarded, failOnHTTPError)
+	if name != "" {
+		if rerr := recordDeliveryResult(rd, name, saved, newDeliveryResult(started, status, err)); rerr != nil {
+			logger.LogAttrs(context.Background(), slog.LevelWarn, "cannot record delivery result",
+				slog.String("name", name), slog.String("delivery_id", saved.eventID), slog.String("error", rerr.Error()))
+		}
 	}
-	return os.Chmod(shscript, 0o755)
+	return err
 }
 
 // buildHttpieHeaders builds httpie header arguments from a map.
@@ -326,17 +381,52 @@ type replayDataOpts struct {
 	targetCnxTimeout            int
 	targetRetries               int
 	sseBufferSize               int
+	sseEncodings                []string // payload compressions asked to the server, preferred first
+	transport                   string   // transportSSE, transportPoll or transportWebSocket, falling back to SSE
 	decorate, noReplay          bool
 	saveDir, smeeURL, targetURL string
 	localDebugURL               string
 	ignoreEvents                []string
-	useHttpie                   bool // Use httpie instead of curl
+	useHttpie                   bool     // Use httpie instead of curl
+	exportFormats               []string // formats saved next to each payload, see exportFormats
 	execCommand                 string
 	execOnEvents                []string
 	execEnvVars                 []string
 	encryptionKeyFile           string
+	nextEncryptionKeyFile       string
+	keyPassphraseFile           string
+	serverSigningKey            ed25519.PublicKey // pinned key protected channel events must be signed with
 	resumeStateFile             string
+	relayAuth                   *relayAuth // headers added to the requests to the gosmee server
 	targetHTTPClient            *http.Client
+	transforms                  *requestTransforms
+	saveOriginal                bool   // save the payload as received instead of the transformed one
+	resignSecret                string // re-sign forwarded payloads with this local secret
+	channel                     string // recorded with saved events
+	archive                     *eventArchive
+}
+
+// deliveryTargetURL returns the URL pm should be forwarded to.
+func deliveryTargetURL(ropts *replayDataOpts, pm payloadMsg) string {
+	if pm.targetURL != "" {
+		return pm.targetURL
+	}
+	return ropts.targetURL
+}
+
+// transformPayload applies the configured transforms, re-signs the result and
+// returns the payload to forward and the payload to save in --saveDir.
+func transformPayload(ropts *replayDataOpts, pm payloadMsg) (forwarded, saved payloadMsg, err error) {
+	forwarded, err = ropts.transforms.Apply(pm, ropts.targetURL)
+	if err != nil {
+		return pm, pm, err
+	}
+	// Signatures are computed last so they match the final body.
+	forwarded = resignPayload(ropts.resignSecret, forwarded)
+	if ropts.saveOriginal {
+		return forwarded, pm, nil
+	}
+	return forwarded, forwarded, nil
 }
 
 type targetDeliveryError struct {
@@ -468,23 +558,56 @@ func replayData(ropts *replayDataOpts, logger *slog.Logger, pm payloadMsg) error
 }
 
 func replayDataWithStatusPolicy(ropts *replayDataOpts, logger *slog.Logger, pm payloadMsg, failOnHTTPError bool) error {
+	_, err := deliverPayload(ropts, logger, pm, failOnHTTPError)
+	return err
+}
+
+// deliverPayload forwards pm to the target and returns the HTTP status it
+// answered with, or 0 when no response was received.
+func deliverPayload(ropts *replayDataOpts, logger *slog.Logger, pm payloadMsg, failOnHTTPError bool) (status int, err error) {
 	started := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ropts.targetCnxTimeout)*time.Second)
+	targetURL := deliveryTargetURL(ropts, pm)
+	ctx, span := tracer().Start(tracePropagator.Extract(context.Background(), headerMapCarrier(pm.headers)), "gosmee.client.deliver",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gosmee.delivery_id", pm.eventID),
+			attribute.String("gosmee.event_type", pm.eventType),
+			attribute.String("url.full", redactTargetURL(targetURL)),
+		))
+	defer func() {
+		if status != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+		}
+		if err == nil && status >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		endSpan(span, err)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ropts.targetCnxTimeout)*time.Second)
 	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ropts.targetURL, bytes.NewReader(pm.body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(pm.body))
 	if err != nil {
-		return &targetDeliveryError{err: err, kind: "request", duration: time.Since(started), deliveryID: pm.eventID, eventType: pm.eventType}
+		return 0, &targetDeliveryError{err: err, kind: "request", duration: time.Since(started), deliveryID: pm.eventID, eventType: pm.eventType}
 	}
 	for k, v := range pm.headers {
+		// net/http ignores a Host entry in req.Header, the request Host must be set instead.
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
 		req.Header.Add(k, v)
 	}
 	if _, ok := pm.headers["Content-Type"]; !ok {
 		req.Header.Add("Content-Type", pm.contentType)
 	}
+	if tracingEnabled.Load() {
+		// the target joins the trace as a child of this attempt
+		tracePropagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
 	resp, err := targetHTTPClient(ropts).Do(req) //nolint:gosec // user-configured URL
 	if err != nil {
 		kind, retryable := classifyTargetError(err)
-		return &targetDeliveryError{err: err, kind: kind, retryable: retryable, duration: time.Since(started), deliveryID: pm.eventID, eventType: pm.eventType}
+		return 0, &targetDeliveryError{err: err, kind: kind, retryable: retryable, duration: time.Since(started), deliveryID: pm.eventID, eventType: pm.eventType}
 	}
 	defer func() {
 		// Drain a bounded amount of the body so the shared transport can
@@ -500,7 +623,7 @@ func replayDataWithStatusPolicy(ropts *replayDataOpts, logger *slog.Logger, pm p
 	if pm.eventID != "" {
 		msg = fmt.Sprintf("%s %s", pm.eventID, msg)
 	}
-	msg = fmt.Sprintf("%s %s replayed to %s, status: %s", pm.timestamp, msg, ansi.Color(ropts.targetURL, "green+ub"), ansi.Color(fmt.Sprintf("%d", resp.StatusCode), "blue+b"))
+	msg = fmt.Sprintf("%s %s replayed to %s, status: %s", pm.timestamp, msg, ansi.Color(targetURL, "green+ub"), ansi.Color(fmt.Sprintf("%d", resp.StatusCode), "blue+b"))
 	if resp.StatusCode > 299 {
 		msg = fmt.Sprintf("%s, error: %s", msg, resp.Status)
 	}
@@ -513,7 +636,7 @@ func replayDataWithStatusPolicy(ropts *replayDataOpts, logger *slog.Logger, pm p
 		slog.String("delivery_id", pm.eventID),
 		slog.String("stream_id", pm.streamID),
 		slog.String("event_type", pm.eventType),
-		slog.String("target", redactTargetURL(ropts.targetURL)),
+		slog.String("target", redactTargetURL(targetURL)),
 		slog.Int("http_status", resp.StatusCode),
 		slog.Int("timeout_seconds", ropts.targetCnxTimeout),
 		slog.Int64("duration_ms", time.Since(started).Milliseconds()),
@@ -521,7 +644,7 @@ func replayDataWithStatusPolicy(ropts *replayDataOpts, logger *slog.Logger, pm p
 		slog.Bool("retryable", false),
 	)
 	if failOnHTTPError && resp.StatusCode > 299 {
-		return &targetDeliveryError{
+		return resp.StatusCode, &targetDeliveryError{
 			err:        fmt.Errorf("target returned %s", resp.Status),
 			kind:       "http_status",
 			retryable:  targetStatusRetryable(resp.StatusCode),
@@ -532,10 +655,10 @@ func replayDataWithStatusPolicy(ropts *replayDataOpts, logger *slog.Logger, pm p
 			retryAfter: parseRetryAfter(resp),
 		}
 	}
-	return nil
+	return resp.StatusCode, nil
 }
 
-func runExecCommand(ctx context.Context, rd *replayDataOpts, logger *slog.Logger, pm payloadMsg) error {
+func runExecCommand(ctx context.Context, rd *replayDataOpts, logger *slog.Logger, pm payloadMsg) (err error) {
 	if len(rd.execOnEvents) > 0 {
 		if pm.eventType == "" {
 			logger.DebugContext(ctx, "skipping exec: event has no type and exec-on-events filter is set")
@@ -548,6 +671,12 @@ func runExecCommand(ctx context.Context, rd *replayDataOpts, logger *slog.Logger
 		}
 	}
 
+	ctx, span := tracer().Start(ctx, "gosmee.client.exec", trace.WithAttributes(
+		attribute.String("gosmee.delivery_id", pm.eventID),
+		attribute.String("gosmee.event_type", pm.eventType),
+	))
+	defer func() { endSpan(span, err) }()
+
 	// Write payload body to a temporary file
 	payloadFile, err := os.CreateTemp("", "gosmee-payload-*.json")
 	if err != nil {
@@ -587,6 +716,14 @@ func runExecCommand(ctx context.Context, rd *replayDataOpts, logger *slog.Logger
 		"GOSMEE_PAYLOAD_FILE="+payloadFile.Name(),
 		"GOSMEE_HEADERS_FILE="+headersFile.Name(),
 	)
+	if tracingEnabled.Load() {
+		// commands join the trace with the TRACEPARENT environment variable
+		carrier := propagation.MapCarrier{}
+		tracePropagator.Inject(ctx, carrier)
+		if traceparent := carrier[traceparentHeader]; traceparent != "" {
+			cmd.Env = append(cmd.Env, "TRACEPARENT="+traceparent)
+		}
+	}
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -671,7 +808,7 @@ func buildExecEnv(extraVarNames []string) []string {
 }
 
 // checkServerVersion verifies that the client version is compatible with the server version.
-func checkServerVersion(serverURL, clientVersion string, logger *slog.Logger, decorate bool) error {
+func checkServerVersion(serverURL, clientVersion string, auth *relayAuth, logger *slog.Logger, decorate bool) error {
 	// Extract base URL from the smeeURL (removing the channel part)
 	baseURL := serverURL
 	if parts := strings.Split(serverURL, "/"); len(parts) > 3 {
@@ -690,6 +827,10 @@ func checkServerVersion(serverURL, clientVersion string, logger *slog.Logger, de
 		logger.WarnContext(context.Background(), fmt.Sprintf("%sCould not create version check request: %s", emoji("⚠", "yellow+b", decorate), err.Error()))
 		return nil
 	}
+	if err := auth.Apply(req.Header); err != nil {
+		logger.WarnContext(context.Background(), fmt.Sprintf("%sCould not check server version: %s", emoji("⚠", "yellow+b", decorate), err.Error()))
+		return nil
+	}
 
 	client := http.Client{Timeout: time.Duration(defaultTimeout) * time.Second}
 	resp, err := client.Do(req) //nolint:gosec // user-configured URL
@@ -816,7 +957,7 @@ func isOlderVersion(v1, v2 []int) bool {
 	return len(v1) < len(v2)
 }
 
-func prepareSubscription(smeeURL, encryptionKeyFile string) (channel, sseURL string, privateKey *[32]byte, err error) {
+func prepareSubscription(smeeURL, encryptionKeyFile string, passphrase PassphraseFunc) (channel, sseURL string, privateKey *[32]byte, err error) {
 	channel = filepath.Base(smeeURL)
 	baseURL := strings.TrimSuffix(smeeURL, "/"+channel)
 
@@ -832,7 +973,7 @@ func prepareSubscription(smeeURL, encryptionKeyFile string) (channel, sseURL str
 		return channel, sseURL, nil, nil
 	}
 
-	publicKey, loadedPrivateKey, err := LoadKeyPair(encryptionKeyFile)
+	publicKey, loadedPrivateKey, err := LoadKeyPairWithPassphrase(encryptionKeyFile, passphrase)
 	if err != nil {
 		return "", "", nil, fmt.Errorf("load encryption keys: %w", err)
 	}
@@ -900,14 +1041,21 @@ func (s *resumeState) Advance(id string) error {
 }
 
 func writeResumeStateFile(path, id string) error {
+	return writeStateFileAtomic(path, "resume state", []byte(id+"\n"))
+}
+
+// writeStateFileAtomic replaces the state file at path with data, through a
+// synced temporary file renamed over it, so a crash never leaves a partial
+// file. what names the file in errors.
+func writeStateFileAtomic(path, what string, data []byte) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return fmt.Errorf("create resume state directory: %w", err)
+		return fmt.Errorf("create %s directory: %w", what, err)
 	}
 
 	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("create temporary resume state file: %w", err)
+		return fmt.Errorf("create temporary %s file: %w", what, err)
 	}
 	tmpName := tmp.Name()
 	cleanup := true
@@ -917,19 +1065,19 @@ func writeResumeStateFile(path, id string) error {
 		}
 	}()
 
-	if _, err := tmp.WriteString(id + "\n"); err != nil {
+	if _, err := tmp.Write(data); err != nil {
 		_ = tmp.Close()
-		return fmt.Errorf("write temporary resume state file: %w", err)
+		return fmt.Errorf("write temporary %s file: %w", what, err)
 	}
 	if err := tmp.Sync(); err != nil {
 		_ = tmp.Close()
-		return fmt.Errorf("sync temporary resume state file: %w", err)
+		return fmt.Errorf("sync temporary %s file: %w", what, err)
 	}
 	if err := tmp.Close(); err != nil {
-		return fmt.Errorf("close temporary resume state file: %w", err)
+		return fmt.Errorf("close temporary %s file: %w", what, err)
 	}
 	if err := os.Rename(tmpName, path); err != nil {
-		return fmt.Errorf("replace resume state file: %w", err)
+		return fmt.Errorf("replace %s file: %w", what, err)
 	}
 	cleanup = false
 
@@ -1016,7 +1164,7 @@ func isClientControlEvent(event clientSSEEvent) bool {
 	return false
 }
 
-func (c goSmee) processClientEvent(now time.Time, event clientSSEEvent, privateKey *[32]byte) (bool, error) {
+func (c goSmee) processClientEvent(now time.Time, event clientSSEEvent, privateKey *[32]byte) (processed bool, err error) {
 	nowStr := now.Format(tsFormat)
 
 	if isClientControlEvent(event) {
@@ -1038,9 +1186,19 @@ func (c goSmee) processClientEvent(now time.Time, event clientSSEEvent, privateK
 		return false, nil
 	}
 
-	payload := event.Data
-	if privateKey != nil && IsEncrypted(event.Data) {
-		decryptedPayload, err := Decrypt(event.Data, privateKey)
+	started := time.Now()
+	payload, err := decodeSSEData(event.Data)
+	if err != nil {
+		return false, permanentClientProcessingError("decompressing message: %w", err)
+	}
+	if privateKey != nil && c.replayDataOpts.serverSigningKey != nil {
+		verifiedPayload, err := DecryptVerified(payload, privateKey, c.replayDataOpts.serverSigningKey, envelopeBinding{Channel: c.replayDataOpts.channel, StreamID: event.ID}, event.receivedAt)
+		if err != nil {
+			return false, permanentClientProcessingError("verifying message: %w", err)
+		}
+		payload = verifiedPayload
+	} else if privateKey != nil && IsEncrypted(payload) {
+		decryptedPayload, err := Decrypt(payload, privateKey)
 		if err != nil {
 			return false, permanentClientProcessingError("decrypting message: %w", err)
 		}
@@ -1051,6 +1209,9 @@ func (c goSmee) processClientEvent(now time.Time, event clientSSEEvent, privateK
 	if err != nil {
 		return false, permanentClientProcessingError("parsing message: %w", err)
 	}
+	pm.streamID = event.ID
+	ctx, span := startClientEventSpan(pm, started)
+	defer func() { endSpan(span, err) }()
 	if c.shouldIgnoreEvent(pm.eventType) {
 		c.logger.InfoContext(context.Background(), fmt.Sprintf("%sskipping event %s as requested", emoji("!", "blue+b", c.replayDataOpts.decorate), pm.eventType))
 		return true, nil
@@ -1059,22 +1220,22 @@ func (c goSmee) processClientEvent(now time.Time, event clientSSEEvent, privateK
 	if len(pm.headers) == 0 {
 		return false, permanentClientProcessingError("no headers found in message")
 	}
-	pm.streamID = event.ID
 
-	if c.replayDataOpts.saveDir != "" {
-		if err := saveData(c.replayDataOpts, c.logger, pm); err != nil {
-			return false, fmt.Errorf("saving message: %w", err)
-		}
+	forwarded, saved, err := transformPayload(c.replayDataOpts, pm)
+	if err != nil {
+		return false, permanentClientProcessingError("transforming message: %w", err)
 	}
 
-	if !c.replayDataOpts.noReplay {
-		if err := replayDataWithStatusPolicy(c.replayDataOpts, c.logger, pm, true); err != nil {
+	if err := saveAndDeliver(c.replayDataOpts, c.logger, saved, forwarded, true); err != nil {
+		var deliveryErr *targetDeliveryError
+		if errors.As(err, &deliveryErr) {
 			return false, fmt.Errorf("forwarding event %q: %w", pm.eventType, err)
 		}
+		return false, err
 	}
 
 	if c.replayDataOpts.execCommand != "" {
-		if err := runExecCommand(context.Background(), c.replayDataOpts, c.logger, pm); err != nil {
+		if err := runExecCommand(ctx, c.replayDataOpts, c.logger, pm); err != nil {
 			return false, fmt.Errorf("exec command failed for event %q: %w", pm.eventType, err)
 		}
 	}
@@ -1084,6 +1245,9 @@ func (c goSmee) processClientEvent(now time.Time, event clientSSEEvent, privateK
 
 func (c goSmee) processClientEventWithRetry(ctx context.Context, event clientSSEEvent, privateKey *[32]byte, state *resumeState) error {
 	durable := isValidRedisStreamID(event.ID)
+	if event.receivedAt.IsZero() {
+		event.receivedAt = time.Now()
+	}
 	processingBackoff := newRetryBackoff()
 	attempt := 1
 	maxAttempts := 1 + c.targetRetryLimit()
@@ -1162,7 +1326,19 @@ func (c goSmee) processClientEventWithRetry(ctx context.Context, event clientSSE
 	}
 }
 
-func (c goSmee) runSSEClient(ctx context.Context, sseURL, version string, privateKey *[32]byte) error {
+// sseSubscription is a way to subscribe to the channel, protected channels
+// have one for the current key and one for the next key during a rotation.
+type sseSubscription struct {
+	url        string
+	privateKey *[32]byte
+	keyFile    string
+}
+
+// errSubscriptionRejected is returned when the server refuses the key of a
+// protected channel subscription, it answers 404 as for unknown channels.
+var errSubscriptionRejected = errors.New("subscription rejected")
+
+func (c goSmee) runSSEClient(ctx context.Context, subscriptions []sseSubscription, version string) error {
 	state, err := newResumeState(c.replayDataOpts.resumeStateFile)
 	if err != nil {
 		return err
@@ -1173,14 +1349,42 @@ func (c goSmee) runSSEClient(ctx context.Context, sseURL, version string, privat
 
 	httpClient := &http.Client{}
 	reconnectBackoff := newRetryBackoff()
+	active, tried := 0, 0
+	warnedFallback := false
 	for {
-		err := c.consumeSSEStream(ctx, httpClient, sseURL, version, privateKey, state, reconnectBackoff)
+		sub := subscriptions[active]
+		var err error
+		switch c.replayDataOpts.transport {
+		case transportPoll:
+			err = c.consumePoll(ctx, httpClient, sub.url, version, sub.privateKey, state, reconnectBackoff)
+		case transportWebSocket:
+			err = c.consumeWebSocket(ctx, httpClient, sub.url, version, sub.privateKey, state, reconnectBackoff)
+			if errors.Is(err, errWebSocketUnavailable) {
+				level := slog.LevelDebug
+				if !warnedFallback {
+					level, warnedFallback = slog.LevelWarn, true
+				}
+				c.logger.Log(ctx, level, fmt.Sprintf("%sFalling back to SSE: %s", emoji("⚠", "yellow+b", c.replayDataOpts.decorate), err.Error()))
+				err = c.consumeSSEStream(ctx, httpClient, sub.url, version, sub.privateKey, state, reconnectBackoff)
+			}
+		default:
+			err = c.consumeSSEStream(ctx, httpClient, sub.url, version, sub.privateKey, state, reconnectBackoff)
+		}
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 		if isPermanentClientProcessingError(err) {
 			return err
 		}
+		if errors.Is(err, errSubscriptionRejected) && len(subscriptions) > 1 {
+			active = (active + 1) % len(subscriptions)
+			c.logger.WarnContext(ctx, fmt.Sprintf("%sServer refused the key from %s, switching to the key from %s", emoji("🔐", "yellow+b", c.replayDataOpts.decorate), sub.keyFile, subscriptions[active].keyFile))
+			// try every key right away before backing off
+			if tried++; tried < len(subscriptions) {
+				continue
+			}
+		}
+		tried = 0
 		delay := reconnectBackoff.Next()
 		c.logger.WarnContext(ctx, fmt.Sprintf("%sSSE connection ended: %s; reconnecting in %s", emoji("⚠", "yellow+b", c.replayDataOpts.decorate), err.Error(), delay))
 		if err := sleepWithContext(ctx, delay); err != nil {
@@ -1198,15 +1402,24 @@ func (c goSmee) consumeSSEStream(ctx context.Context, httpClient *http.Client, s
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("User-Agent", fmt.Sprintf("gosmee/%s", version))
 	req.Header.Set("X-Accel-Buffering", "no")
+	if len(c.replayDataOpts.sseEncodings) > 0 {
+		req.Header.Set(acceptEncodingHeader, strings.Join(c.replayDataOpts.sseEncodings, ", "))
+	}
 	if state.ID() != "" {
 		req.Header.Set("Last-Event-ID", state.ID())
 	}
+	if err := c.replayDataOpts.relayAuth.Apply(req.Header); err != nil {
+		return err
+	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("connect to SSE stream: %w", err)
 	}
 	defer resp.Body.Close()
+	if privateKey != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("%w: SSE endpoint returned %s", errSubscriptionRejected, resp.Status)
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("SSE endpoint returned %s", resp.Status)
 	}
@@ -1282,20 +1495,34 @@ func (c goSmee) clientSetup() error {
 	c.logger.InfoContext(context.Background(), s)
 
 	// Check server version compatibility
-	if err := checkServerVersion(c.replayDataOpts.smeeURL, version, c.logger, c.replayDataOpts.decorate); err != nil {
+	if err := checkServerVersion(c.replayDataOpts.smeeURL, version, c.replayDataOpts.relayAuth, c.logger, c.replayDataOpts.decorate); err != nil {
 		c.logger.WarnContext(context.Background(), fmt.Sprintf("%sCould not get server version: %s", emoji("⚠", "yellow+b", c.replayDataOpts.decorate), err.Error()))
 	}
 
-	_, sseURL, privateKey, err := prepareSubscription(c.replayDataOpts.smeeURL, c.replayDataOpts.encryptionKeyFile)
+	passphrase := NewPassphraseFunc(c.replayDataOpts.keyPassphraseFile)
+	channel, sseURL, privateKey, err := prepareSubscription(c.replayDataOpts.smeeURL, c.replayDataOpts.encryptionKeyFile, passphrase)
 	if err != nil {
 		return err
 	}
+	subscriptions := []sseSubscription{{url: sseURL, privateKey: privateKey, keyFile: c.replayDataOpts.encryptionKeyFile}}
+	if nextKeyFile := c.replayDataOpts.nextEncryptionKeyFile; nextKeyFile != "" {
+		if privateKey == nil {
+			return fmt.Errorf("--next-encryption-key-file requires --encryption-key-file")
+		}
+		_, nextURL, nextPrivateKey, err := prepareSubscription(c.replayDataOpts.smeeURL, nextKeyFile, passphrase)
+		if err != nil {
+			return err
+		}
+		subscriptions = append(subscriptions, sseSubscription{url: nextURL, privateKey: nextPrivateKey, keyFile: nextKeyFile})
+	}
+	c.replayDataOpts.channel = channel
+	defer c.replayDataOpts.archive.Close()
 	if privateKey != nil {
 		c.logger.InfoContext(context.Background(), fmt.Sprintf("%sProtected channel mode enabled for gosmee SSE transport", emoji("🔐", "green+b", c.replayDataOpts.decorate)))
 	}
 
 	c.logger.InfoContext(context.Background(), fmt.Sprintf("%sConfigured reconnection strategy to retry indefinitely", emoji("⇉", "blue+b", c.replayDataOpts.decorate)))
-	return c.runSSEClient(context.Background(), sseURL, version, privateKey)
+	return c.runSSEClient(context.Background(), subscriptions, version)
 }
 
 func serveHealthEndpoint(port int, logger *slog.Logger, decorate bool) {
@@ -931,7 +931,7 @@ func TestCheckServerVersion(t *testing.T) {
 		})
 		defer server.Close()
 
-		err := checkServerVersion(server.URL, defaultClientVersion, logger, decorate)
+		err := checkServerVersion(server.URL, defaultClientVersion, nil, logger, decorate)
 		assert.NilError(t, err, "Expected no error when versions match")
 	})
 
@@ -944,7 +944,7 @@ func TestCheckServerVersion(t *testing.T) {
 		})
 		defer server.Close()
 
-		err := checkServerVersion(server.URL, clientVersion, logger, decorate)
+		err := checkServerVersion(server.URL, clientVersion, nil, logger, decorate)
 		assert.Assert(t, err != nil, "Expected an error when client is older")
 		if err != nil {
 			assert.Assert(t, strings.Contains(err.Error(), "Please upgrade your gosmee client"), "Error message mismatch")
@@ -960,7 +960,7 @@ func TestCheckServerVersion(t *testing.T) {
 		})
 		defer server.Close()
 
-		err := checkServerVersion(server.URL, clientVersion, logger, decorate)
+		err := checkServerVersion(server.URL, clientVersion, nil, logger, decorate)
 		assert.NilError(t, err, "Expected no error when client is newer, only a warning log (not checked here)")
 	})
 
@@ -983,7 +983,7 @@ func TestCheckServerVersion(t *testing.T) {
 				})
 				defer server.Close()
 
-				err := checkServerVersion(server.URL, tc.clientVersion, logger, decorate)
+				err := checkServerVersion(server.URL, tc.clientVersion, nil, logger, decorate)
 				assert.NilError(t, err, "Expected no error for dev versions, only a warning/debug log")
 			})
 		}
@@ -996,7 +996,7 @@ func TestCheckServerVersion(t *testing.T) {
 		})
 		defer server.Close()
 
-		err := checkServerVersion(server.URL, defaultClientVersion, logger, decorate)
+		err := checkServerVersion(server.URL, defaultClientVersion, nil, logger, decorate)
 		assert.Assert(t, err != nil, "Expected an error when server returns 404")
 		if err != nil {
 			assert.Assert(t, strings.Contains(err.Error(), "server appears to be too old"), "Error message mismatch for 404")
@@ -1010,7 +1010,7 @@ func TestCheckServerVersion(t *testing.T) {
 			})
 			defer server.Close()
 
-			err := checkServerVersion(server.URL, defaultClientVersion, logger, decorate)
+			err := checkServerVersion(server.URL, defaultClientVersion, nil, logger, decorate)
 			assert.NilError(t, err, "Expected nil error for HTTP 500, only a warning log")
 		})
 
@@ -1022,7 +1022,7 @@ func TestCheckServerVersion(t *testing.T) {
 			})
 			defer server.Close()
 
-			err := checkServerVersion(server.URL, defaultClientVersion, logger, decorate)
+			err := checkServerVersion(server.URL, defaultClientVersion, nil, logger, decorate)
 			assert.NilError(t, err, "Expected nil error for invalid JSON, only a warning log")
 		})
 	})
@@ -1038,7 +1038,7 @@ func TestCheckServerVersion(t *testing.T) {
 				w.WriteHeader(http.StatusOK)
 			})
 			defer server.Close()
-			err := checkServerVersion(server.URL, clientVersion, logger, decorate)
+			err := checkServerVersion(server.URL, clientVersion, nil, logger, decorate)
 			assert.NilError(t, err)
 		})
 
@@ -1051,7 +1051,7 @@ func TestCheckServerVersion(t *testing.T) {
 				_, _ = fmt.Fprintf(w, `{"version": "%s"}`, serverVersion)
 			})
 			defer server.Close()
-			err := checkServerVersion(server.URL, clientVersion, logger, decorate)
+			err := checkServerVersion(server.URL, clientVersion, nil, logger, decorate)
 			assert.NilError(t, err)
 		})
 
@@ -1068,7 +1068,7 @@ func TestCheckServerVersion(t *testing.T) {
 			})
 			defer server.Close()
 
-			err := checkServerVersion(server.URL, currentClientVersion, logger, decorate)
+			err := checkServerVersion(server.URL, currentClientVersion, nil, logger, decorate)
 			assert.Assert(t, err != nil, "Expected error as client is older than header version")
 			if err != nil {
 				assert.Assert(t, strings.Contains(err.Error(), "Please upgrade your gosmee client"))
@@ -1085,7 +1085,7 @@ func TestCheckServerVersion(t *testing.T) {
 				_, _ = fmt.Fprintf(w, `{"version": "%s"}`, serverVersion)
 			})
 			defer server.Close()
-			err := checkServerVersion(server.URL, currentClientVersion, logger, decorate)
+			err := checkServerVersion(server.URL, currentClientVersion, nil, logger, decorate)
 			assert.Assert(t, err != nil, "Expected error as client is older than JSON version")
 			if err != nil {
 				assert.Assert(t, strings.Contains(err.Error(), "Please upgrade your gosmee client"))
@@ -1096,7 +1096,7 @@ func TestCheckServerVersion(t *testing.T) {
 	t.Run("Connection Error", func(t *testing.T) {
 		// Using a non-existent port to simulate connection error
 		nonExistentServerURL := "http://localhost:12345"
-		err := checkServerVersion(nonExistentServerURL, defaultClientVersion, logger, decorate)
+		err := checkServerVersion(nonExistentServerURL, defaultClientVersion, nil, logger, decorate)
 		assert.NilError(t, err, "Expected nil error for connection failure, only a warning log")
 	})
 
@@ -1112,7 +1112,7 @@ func TestCheckServerVersion(t *testing.T) {
 		// The behavior here depends on how parseVersion("totally-invalid-version") works.
 		// As per current parseVersion, "totally-invalid-version" becomes [0,0,0].
 		// So, client [0,0,0] vs server [1,0,0] means client is older.
-		err := checkServerVersion(server.URL, malformedClientVersion, logger, decorate)
+		err := checkServerVersion(server.URL, malformedClientVersion, nil, logger, decorate)
 		assert.Assert(t, err != nil, "Expected an error as malformed client version ([0,0,0]) is older than server")
 		if err != nil {
 			assert.Assert(t, strings.Contains(err.Error(), "Please upgrade your gosmee client"), "Error message mismatch")
@@ -1484,7 +1484,7 @@ func TestClientSetupKeyFileWithSmeeIOFails(t *testing.T) {
 
 func TestPrepareSubscription(t *testing.T) {
 	t.Run("plaintext gosmee server does not require a key file", func(t *testing.T) {
-		channel, sseURL, privateKey, err := prepareSubscription("https://example.com/plainchannel", "")
+		channel, sseURL, privateKey, err := prepareSubscription("https://example.com/plainchannel", "", nil)
 		assert.NilError(t, err)
 		assert.Equal(t, channel, "plainchannel")
 		assert.Equal(t, sseURL, "https://example.com/events/plainchannel")
@@ -1497,7 +1497,7 @@ func TestPrepareSubscription(t *testing.T) {
 		assert.NilError(t, err)
 		assert.NilError(t, SaveKeyPair(keyPath, publicKey, privateKey))
 
-		channel, sseURL, loadedPrivateKey, err := prepareSubscription("https://example.com/protectedchan", keyPath)
+		channel, sseURL, loadedPrivateKey, err := prepareSubscription("https://example.com/protectedchan", keyPath, nil)
 		assert.NilError(t, err)
 		assert.Equal(t, channel, "protectedchan")
 		assert.Assert(t, strings.HasPrefix(sseURL, "https://example.com/events/protectedchan?pubkey="))
@@ -1505,6 +1505,44 @@ func TestPrepareSubscription(t *testing.T) {
 	})
 }
 
+func TestRunSSEClientSwitchesToNextKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "client-key.json")
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveKeyPair(keyPath, publicKey, privateKey))
+	nextPath := keyPath + ".next"
+	_, nextPublicKey, err := RotateKeyPair(keyPath, nextPath, nil, SaveKeyPair)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var pubKeys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pubKeys = append(pubKeys, r.URL.Query().Get("pubkey"))
+		if r.URL.Query().Get("pubkey") != EncodePublicKey(nextPublicKey) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		cancel()
+	}))
+	defer server.Close()
+
+	var subscriptions []sseSubscription
+	for _, path := range []string{keyPath, nextPath} {
+		_, sseURL, key, err := prepareSubscription(server.URL+"/protectedchan", path, nil)
+		assert.NilError(t, err)
+		subscriptions = append(subscriptions, sseSubscription{url: sseURL, privateKey: key, keyFile: path})
+	}
+
+	c := goSmee{replayDataOpts: &replayDataOpts{}, logger: slog.New(slog.DiscardHandler)}
+	err = c.runSSEClient(ctx, subscriptions, "dev")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.DeepEqual(t, pubKeys, []string{EncodePublicKey(publicKey), EncodePublicKey(nextPublicKey)})
+}
+
 func TestIsOlderVersion(t *testing.T) {
 	tests := []struct {
 		name string
@@ -0,0 +1,183 @@
+package gosmee
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Payloads of at least compressMinSize bytes can be compressed on the SSE
+// wire, for clients asking for it, and in Redis streams.
+//
+// A client lists the encodings it supports, preferred first, in the
+// X-Gosmee-Accept-Encoding header or the encoding query parameter. The
+// server answers with the one it uses in X-Gosmee-Encoding, and sends its
+// large events as:
+//
+//	{"gosmee-encoding":"zstd","data":"<base64 of the compressed data>"}
+//
+// Clients that do not ask keep receiving the data as is.
+const (
+	acceptEncodingHeader   = "X-Gosmee-Accept-Encoding"
+	encodingHeader         = "X-Gosmee-Encoding"
+	encodingQueryParam     = "encoding"
+	encodingZstd           = "zstd"
+	encodingGzip           = "gzip"
+	defaultCompressMinSize = 4096
+	// maxDecompressedSize bounds what a compressed payload may expand to,
+	// the largest webhook the server accepts by default with room for the
+	// JSON around it.
+	maxDecompressedSize = 64 << 20
+)
+
+// supportedEncodings are the payload encodings, preferred first.
+var supportedEncodings = []string{encodingZstd, encodingGzip}
+
+var compressedEventPrefix = []byte(`{"gosmee-encoding":`)
+
+type compressedEvent struct {
+	Encoding string `json:"gosmee-encoding"`
+	Data     []byte `json:"data"`
+}
+
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+)
+
+func sharedZstdEncoder() *zstd.Encoder {
+	zstdEncoderOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+	return zstdEncoder
+}
+
+func sharedZstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdDecoder
+}
+
+func compressPayload(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case encodingZstd:
+		return sharedZstdEncoder().EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	case encodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported payload encoding %q", encoding)
+	}
+}
+
+func decompressPayload(encoding string, data []byte) ([]byte, error) {
+	var decompressed []byte
+	var err error
+	switch encoding {
+	case encodingZstd:
+		decompressed, err = sharedZstdDecoder().DecodeAll(data, nil)
+	case encodingGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		decompressed, err = io.ReadAll(io.LimitReader(zr, maxDecompressedSize+1))
+	default:
+		return nil, fmt.Errorf("unsupported payload encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s payload: %w", encoding, err)
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed %s payload is larger than %d bytes", encoding, maxDecompressedSize)
+	}
+	return decompressed, nil
+}
+
+// negotiateEncoding returns the first encoding the client asked for that is
+// supported, or "" when the data is sent as is.
+func negotiateEncoding(r *http.Request, compressMinSize int) string {
+	if compressMinSize <= 0 {
+		return ""
+	}
+	accepted := r.Header.Get(acceptEncodingHeader)
+	if accepted == "" {
+		accepted = r.URL.Query().Get(encodingQueryParam)
+	}
+	for _, encoding := range strings.Split(accepted, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if slices.Contains(supportedEncodings, encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// parseSSECompression reads the --sse-compression list.
+func parseSSECompression(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "none") {
+		return nil, nil
+	}
+	var encodings []string
+	for _, encoding := range strings.Split(value, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if !slices.Contains(supportedEncodings, encoding) {
+			return nil, fmt.Errorf("invalid --sse-compression %q, supported: %s or none", encoding, strings.Join(supportedEncodings, ", "))
+		}
+		encodings = append(encodings, encoding)
+	}
+	return encodings, nil
+}
+
+// compressSSEData returns data in the compressed event format when it is
+// large enough and compresses.
+func compressSSEData(encoding string, compressMinSize int, data []byte) []byte {
+	if encoding == "" || len(data) < compressMinSize {
+		return data
+	}
+	compressed, err := compressPayload(encoding, data)
+	if err != nil || base64.StdEncoding.EncodedLen(len(compressed)) >= len(data) {
+		return data
+	}
+	wrapped, err := json.Marshal(compressedEvent{Encoding: encoding, Data: compressed})
+	if err != nil {
+		return data
+	}
+	return wrapped
+}
+
+// decodeSSEData returns the data of a compressed event decompressed, and
+// other data as is. The decompressed size is bounded by maxDecompressedSize,
+// --sse-buffer-size does not need to account for it: it is only the initial
+// size of the line reader, which grows for longer lines, compressed or not.
+func decodeSSEData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressedEventPrefix) {
+		return data, nil
+	}
+	var event compressedEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("invalid compressed event: %w", err)
+	}
+	return decompressPayload(event.Encoding, event.Data)
+}
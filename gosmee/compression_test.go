@@ -0,0 +1,232 @@
+package gosmee
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"gotest.tools/v3/assert"
+)
+
+// largePayload is a webhook payload that compresses well, like the base64
+// bodies of big push events.
+func largePayload() []byte {
+	body := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(`{"commit":"0123456789abcdef"}`), 400))
+	return []byte(`{"x-github-event":"push","x-github-delivery":"abc","bodyB":"` + body + `"}`)
+}
+
+func TestCompressPayload(t *testing.T) {
+	data := largePayload()
+	for _, encoding := range supportedEncodings {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := compressPayload(encoding, data)
+			assert.NilError(t, err)
+			assert.Assert(t, len(compressed) < len(data))
+			decompressed, err := decompressPayload(encoding, compressed)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, decompressed, data)
+		})
+	}
+
+	_, err := compressPayload("brotli", data)
+	assert.ErrorContains(t, err, `unsupported payload encoding "brotli"`)
+	_, err = decompressPayload(encodingZstd, []byte("not zstd"))
+	assert.ErrorContains(t, err, "decompress zstd payload")
+}
+
+func TestDecompressPayloadLimit(t *testing.T) {
+	bomb, err := compressPayload(encodingGzip, make([]byte, maxDecompressedSize+1))
+	assert.NilError(t, err)
+	_, err = decompressPayload(encodingGzip, bomb)
+	assert.ErrorContains(t, err, "larger than")
+}
+
+func TestCompressSSEData(t *testing.T) {
+	data := largePayload()
+
+	t.Run("large data is compressed", func(t *testing.T) {
+		wrapped := compressSSEData(encodingZstd, 1024, data)
+		assert.Assert(t, bytes.HasPrefix(wrapped, compressedEventPrefix))
+		assert.Assert(t, len(wrapped) < len(data))
+		assert.Assert(t, !bytes.Contains(wrapped, []byte("\n")))
+		decoded, err := decodeSSEData(wrapped)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, decoded, data)
+	})
+
+	t.Run("small data and clients without compression get it as is", func(t *testing.T) {
+		assert.DeepEqual(t, compressSSEData(encodingZstd, len(data)+1, data), data)
+		assert.DeepEqual(t, compressSSEData("", 1024, data), data)
+	})
+
+	t.Run("data of older servers decodes as is", func(t *testing.T) {
+		decoded, err := decodeSSEData([]byte(`{"x-github-event":"push"}`))
+		assert.NilError(t, err)
+		assert.Equal(t, string(decoded), `{"x-github-event":"push"}`)
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		query   string
+		minSize int
+		want    string
+	}{
+		{name: "header preference", header: "gzip, zstd", minSize: 1, want: encodingGzip},
+		{name: "unknown encodings are skipped", header: "br, ZSTD", minSize: 1, want: encodingZstd},
+		{name: "query parameter", query: "gzip", minSize: 1, want: encodingGzip},
+		{name: "not asked", minSize: 1, want: ""},
+		{name: "disabled on the server", header: "zstd", minSize: 0, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/events/test-channel"
+			if tt.query != "" {
+				target += "?encoding=" + tt.query
+			}
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+			if tt.header != "" {
+				req.Header.Set(acceptEncodingHeader, tt.header)
+			}
+			assert.Equal(t, negotiateEncoding(req, tt.minSize), tt.want)
+		})
+	}
+}
+
+func TestParseSSECompression(t *testing.T) {
+	encodings, err := parseSSECompression("zstd, GZIP")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, encodings, []string{encodingZstd, encodingGzip})
+
+	encodings, err = parseSSECompression("none")
+	assert.NilError(t, err)
+	assert.Assert(t, encodings == nil)
+
+	_, err = parseSSECompression("zstd,lz4")
+	assert.ErrorContains(t, err, `invalid --sse-compression "lz4"`)
+}
+
+func TestRedisPayloadCompression(t *testing.T) {
+	data := largePayload()
+
+	t.Run("large entries are stored compressed", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.compressMinSize = 1024
+		relay.compressEntries = true
+		values, events, err := publishAndRead(t, relay, "test-channel", data)
+		assert.NilError(t, err)
+		assert.Equal(t, values[redisStreamCompressionField], encodingZstd)
+		assert.Assert(t, len(values[redisStreamPayloadField].(string)) < len(data))
+		assert.DeepEqual(t, events[0].Data, data)
+		assert.Equal(t, events[0].DeliveryID, "abc")
+	})
+
+	t.Run("compressed and encrypted at rest", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.compressMinSize = 1024
+		relay.compressEntries = true
+		relay.atRest = newRedisAtRest(testRedisDataKey(t), false, nil, func(string) bool { return true })
+		values, events, err := publishAndRead(t, relay, "test-channel", data)
+		assert.NilError(t, err)
+		assert.Equal(t, values[redisStreamCompressionField], encodingZstd)
+		assert.Equal(t, values[redisStreamEncodingField], redisAtRestVersion)
+		assert.DeepEqual(t, events[0].Data, data)
+	})
+
+	t.Run("small entries and disabled compression are stored as is", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.compressMinSize = len(data) + 1
+		relay.compressEntries = true
+		values, events, err := publishAndRead(t, relay, "test-channel", data)
+		assert.NilError(t, err)
+		_, compressed := values[redisStreamCompressionField]
+		assert.Assert(t, !compressed)
+		assert.DeepEqual(t, events[0].Data, data)
+
+		relay.compressMinSize = 0
+		values, _, err = publishAndRead(t, relay, "test-channel", data)
+		assert.NilError(t, err)
+		_, compressed = values[redisStreamCompressionField]
+		assert.Assert(t, !compressed)
+	})
+
+	t.Run("entries are stored as is by default", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.compressMinSize = 1024
+		values, events, err := publishAndRead(t, relay, "test-channel", data)
+		assert.NilError(t, err)
+		_, compressed := values[redisStreamCompressionField]
+		assert.Assert(t, !compressed)
+		assert.DeepEqual(t, events[0].Data, data)
+	})
+}
+
+func TestHandleEventsGetCompression(t *testing.T) {
+	eventBroker := NewEventBroker()
+	eventBroker.compressMinSize = 1024
+	router := chi.NewRouter()
+	router.Get("/events/{channel:[a-zA-Z0-9_-]{12,64}}", handleEventsGet(eventBroker, nil, "*"))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	data := largePayload()
+
+	// subscribe publishes data once subscribed and returns the response
+	// headers and the data line of the event.
+	subscribe := func(t *testing.T, accept string) (http.Header, string) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events/plain-channel", nil)
+		assert.NilError(t, err)
+		if accept != "" {
+			req.Header.Set(acceptEncodingHeader, accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+
+		assert.Assert(t, eventually(t, func() bool {
+			eventBroker.RLock()
+			defer eventBroker.RUnlock()
+			return len(eventBroker.subscribers["plain-channel"]) == 1
+		}))
+		eventBroker.Publish("plain-channel", data)
+
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			assert.NilError(t, err)
+			if strings.HasPrefix(line, "data: ") && !strings.Contains(line, `"message"`) {
+				return resp.Header, strings.TrimSuffix(strings.TrimPrefix(line, "data: "), "\n")
+			}
+		}
+	}
+
+	t.Run("client asking for compression", func(t *testing.T) {
+		header, line := subscribe(t, "zstd")
+		assert.Equal(t, header.Get(encodingHeader), encodingZstd)
+		assert.Assert(t, strings.HasPrefix(line, `{"gosmee-encoding":"zstd"`))
+		decoded, err := decodeSSEData([]byte(line))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, decoded, data)
+	})
+
+	t.Run("client not asking", func(t *testing.T) {
+		assert.Assert(t, eventually(t, func() bool {
+			eventBroker.RLock()
+			defer eventBroker.RUnlock()
+			return len(eventBroker.subscribers["plain-channel"]) == 0
+		}))
+		header, line := subscribe(t, "")
+		assert.Equal(t, header.Get(encodingHeader), "")
+		assert.Equal(t, line, string(data))
+	})
+}
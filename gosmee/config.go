@@ -19,6 +19,9 @@ var commandFlags = map[string]map[string]bool{
 		"log-level":                 true,
 		"ignore-event":              true,
 		"saveDir":                   true,
+		"save-format":               true,
+		"export-format":             true,
+		"save-archive-max-size":     true,
 		"target-connection-timeout": true,
 		"target-retries":            true,
 		"noReplay":                  true,
@@ -27,14 +30,32 @@ var commandFlags = map[string]map[string]bool{
 		"exec":                      true,
 		"exec-on-events":            true,
 		"exec-env-vars":             true,
+		"set-header":                true,
+		"remove-header":             true,
+		"rename-header":             true,
+		"body-template":             true,
+		"target-url-template":       true,
+		"save-original":             true,
+		"resign-secret":             true,
 		"new-url":                   true,
 		"httpie":                    true,
 		"channel":                   true,
 		"local-debug-url":           true,
 		"health-port":               true,
 		"sse-buffer-size":           true,
+		"sse-compression":           true,
+		"transport":                 true,
 		"encryption-key-file":       true,
+		"next-encryption-key-file":  true,
+		"server-signing-key":        true,
+		"key-passphrase-file":       true,
 		"resume-state-file":         true,
+		"server-header":             true,
+		"server-token":              true,
+		"server-token-file":         true,
+		"otlp-endpoint":             true,
+		"otlp-traces-endpoint":      true,
+		"trace-sample-ratio":        true,
 	},
 	"replay": {
 		"org-repo":                  true,
@@ -44,6 +65,9 @@ var commandFlags = map[string]map[string]bool{
 		"log-level":                 true,
 		"ignore-event":              true,
 		"saveDir":                   true,
+		"save-format":               true,
+		"export-format":             true,
+		"save-archive-max-size":     true,
 		"target-connection-timeout": true,
 		"target-retries":            true,
 		"noReplay":                  true,
@@ -52,10 +76,36 @@ var commandFlags = map[string]map[string]bool{
 		"exec":                      true,
 		"exec-on-events":            true,
 		"exec-env-vars":             true,
+		"set-header":                true,
+		"remove-header":             true,
+		"rename-header":             true,
+		"body-template":             true,
+		"target-url-template":       true,
+		"save-original":             true,
+		"resign-secret":             true,
 		"github-token":              true,
+		"github-app-id":             true,
+		"github-app-private-key":    true,
+		"github-installation-id":    true,
+		"github-app-hook":           true,
+		"github-base-url":           true,
+		"provider":                  true,
+		"provider-url":              true,
+		"provider-token":            true,
 		"list-hooks":                true,
 		"list-deliveries":           true,
+		"show":                      true,
 		"time-since":                true,
+		"until":                     true,
+		"once":                      true,
+		"status":                    true,
+		"event-type":                true,
+		"action":                    true,
+		"delivery-id":               true,
+		"state-file":                true,
+		"redeliver":                 true,
+		"dry-run":                   true,
+		"redeliver-concurrency":     true,
 	},
 	"server": {
 		"public-url":              true,
@@ -72,12 +122,41 @@ var commandFlags = map[string]map[string]bool{
 		"replay-token":            true,
 		"max-body-size":           true,
 		"encrypted-channels-file": true,
+		"signing-key-file":        true,
 		"cors-origin":             true,
+		"history-size":            true,
+		"history-max-memory":      true,
 		"redis-url":               true,
 		"redis-stream-maxlen":     true,
+		"compress-min-size":       true,
+		"redis-compress":          true,
+		"redis-data-key-file":     true,
+		"redis-channel-data-keys": true,
+		"otlp-endpoint":           true,
+		"otlp-traces-endpoint":    true,
+		"trace-sample-ratio":      true,
 	},
 	"keygen": {
-		"key-file": true,
+		"key-file":                true,
+		"rotate":                  true,
+		"next-key-file":           true,
+		"signing":                 true,
+		"encrypt":                 true,
+		"keyring":                 true,
+		"key-passphrase-file":     true,
+		"new-key-passphrase-file": true,
+	},
+	"events": {
+		"output":                    true,
+		"log-level":                 true,
+		"nocolor":                   true,
+		"saveDir":                   true,
+		"target-connection-timeout": true,
+		"target-retries":            true,
+		"insecure-skip-tls-verify":  true,
+		"resign-secret":             true,
+		"format":                    true,
+		"target-url":                true,
 	},
 }
 
@@ -86,6 +165,9 @@ var globalValidKeys = map[string]bool{
 	"log-level":                 true,
 	"ignore-event":              true,
 	"saveDir":                   true,
+	"save-format":               true,
+	"export-format":             true,
+	"save-archive-max-size":     true,
 	"target-connection-timeout": true,
 	"target-retries":            true,
 	"noReplay":                  true,
@@ -94,11 +176,19 @@ var globalValidKeys = map[string]bool{
 	"exec":                      true,
 	"exec-on-events":            true,
 	"exec-env-vars":             true,
+	"set-header":                true,
+	"remove-header":             true,
+	"rename-header":             true,
+	"body-template":             true,
+	"target-url-template":       true,
+	"save-original":             true,
+	"resign-secret":             true,
 	"config":                    true,
 	"client":                    true,
 	"server":                    true,
 	"replay":                    true,
 	"keygen":                    true,
+	"events":                    true,
 }
 
 func defaultConfigFile() string {
@@ -162,7 +252,7 @@ func validateConfig(cfg map[string]any) error {
 				}
 			}
 		} else if v != nil {
-			if k == "client" || k == "server" || k == "replay" || k == "keygen" {
+			if k == "client" || k == "server" || k == "replay" || k == "keygen" || k == "events" {
 				return fmt.Errorf("section %q must be a map/dictionary", k)
 			}
 		}
@@ -215,7 +305,7 @@ func ApplyConfigToContext(cCtx *cli.Context, section string) error {
 
 	merged := make(map[string]any)
 	for k, v := range loadedConfig {
-		if k != "client" && k != "server" && k != "replay" && k != "keygen" {
+		if k != "client" && k != "server" && k != "replay" && k != "keygen" && k != "events" {
 			merged[k] = v
 		}
 	}
@@ -6,12 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"golang.org/x/crypto/nacl/box"
 )
 
-const encryptedEnvelopeVersion = 1
+const (
+	encryptedEnvelopeVersion = 1
+	// signedEnvelopeVersion envelopes are signed by the server, see
+	// EncryptSigned.
+	signedEnvelopeVersion = 2
+)
 
 type encryptedEnvelope struct {
 	Encrypted  bool   `json:"encrypted"`
@@ -19,6 +23,10 @@ type encryptedEnvelope struct {
 	Ephemeral  string `json:"epk"`
 	Nonce      string `json:"nonce"`
 	Ciphertext string `json:"ciphertext"`
+	Channel    string `json:"channel,omitempty"`
+	StreamID   string `json:"stream_id,omitempty"`
+	Timestamp  int64  `json:"ts,omitempty"`
+	Signature  string `json:"sig,omitempty"`
 }
 
 type storedKeyPair struct {
@@ -36,40 +44,48 @@ func GenerateKeyPair() (*[32]byte, *[32]byte, error) {
 }
 
 func SaveKeyPair(path string, publicKey, privateKey *[32]byte) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
-		return err
-	}
-
-	stored := storedKeyPair{
+	return writeKeyFile(path, storedKeyPair{
 		PublicKey:  base64.StdEncoding.EncodeToString(publicKey[:]),
 		PrivateKey: base64.StdEncoding.EncodeToString(privateKey[:]),
-	}
+	})
+}
+
+// writeKeyFile writes a key file only the user can read. The file is
+// replaced atomically, so that an interrupted passphrase change cannot lose
+// the only copy of the private key.
+func writeKeyFile(path string, stored any) error {
 	encoded, err := json.Marshal(stored) //nolint:gosec // intentionally marshaling key pair for storage
 	if err != nil {
 		return err
 	}
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if _, err := f.Write(encoded); err != nil {
-		return err
-	}
-	return nil
+	return writeStateFileAtomic(path, "key", encoded)
 }
 
+// LoadKeyPair reads a key file in any format, the passphrase of an encrypted
+// one comes from $GOSMEE_KEY_PASSPHRASE or a prompt.
 func LoadKeyPair(path string) (*[32]byte, *[32]byte, error) {
-	if path == "" {
-		return nil, nil, fmt.Errorf("key file path is required")
-	}
+	return LoadKeyPairWithPassphrase(path, nil)
+}
 
-	data, err := os.ReadFile(path)
+// RotateKeyPair writes a new keypair to nextPath with save, next to the
+// current one of keyPath, which keeps working until the server drops it. It
+// returns the fingerprint of the current public key and the new public key.
+func RotateKeyPair(keyPath, nextPath string, passphrase PassphraseFunc, save KeyFileWriter) (string, *[32]byte, error) {
+	currentPublicKey, _, err := LoadKeyPairWithPassphrase(keyPath, passphrase)
 	if err != nil {
-		return nil, nil, err
+		return "", nil, fmt.Errorf("load current keypair: %w", err)
 	}
-
-	return loadKeyPair(data)
+	if _, err := os.Stat(nextPath); err == nil {
+		return "", nil, fmt.Errorf("next keypair %s already exists, remove it to rotate again", nextPath)
+	}
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := save(nextPath, publicKey, privateKey); err != nil {
+		return "", nil, err
+	}
+	return PublicKeyFingerprint(currentPublicKey), publicKey, nil
 }
 
 func EncodePublicKey(key *[32]byte) string {
@@ -86,26 +102,33 @@ func ParsePublicKey(encoded string) (*[32]byte, error) {
 }
 
 func Encrypt(plaintext []byte, recipientPubKey *[32]byte) ([]byte, error) {
-	ephemeralPubKey, ephemeralPrivKey, err := GenerateKeyPair()
+	envelope, err := sealEnvelope(plaintext, recipientPubKey)
 	if err != nil {
 		return nil, err
 	}
 
+	return json.Marshal(envelope)
+}
+
+func sealEnvelope(plaintext []byte, recipientPubKey *[32]byte) (encryptedEnvelope, error) {
+	ephemeralPubKey, ephemeralPrivKey, err := GenerateKeyPair()
+	if err != nil {
+		return encryptedEnvelope{}, err
+	}
+
 	var nonce [24]byte
 	if _, err := rand.Read(nonce[:]); err != nil {
-		return nil, err
+		return encryptedEnvelope{}, err
 	}
 
 	ciphertext := box.Seal(nil, plaintext, &nonce, recipientPubKey, ephemeralPrivKey)
-	envelope := encryptedEnvelope{
+	return encryptedEnvelope{
 		Encrypted:  true,
 		Version:    encryptedEnvelopeVersion,
 		Ephemeral:  base64.StdEncoding.EncodeToString(ephemeralPubKey[:]),
 		Nonce:      base64.StdEncoding.EncodeToString(nonce[:]),
 		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
-	}
-
-	return json.Marshal(envelope)
+	}, nil
 }
 
 func Decrypt(envelope []byte, privateKey *[32]byte) ([]byte, error) {
@@ -180,12 +203,15 @@ func parseEncryptedEnvelope(data []byte) (encryptedEnvelope, *[32]byte, *[24]byt
 	if !envelope.Encrypted {
 		return encryptedEnvelope{}, nil, nil, nil, fmt.Errorf("missing encrypted marker")
 	}
-	if envelope.Version != encryptedEnvelopeVersion {
+	if envelope.Version != encryptedEnvelopeVersion && envelope.Version != signedEnvelopeVersion {
 		return encryptedEnvelope{}, nil, nil, nil, fmt.Errorf("unsupported envelope version %d", envelope.Version)
 	}
 	if envelope.Ephemeral == "" || envelope.Nonce == "" || envelope.Ciphertext == "" {
 		return encryptedEnvelope{}, nil, nil, nil, fmt.Errorf("incomplete encrypted envelope")
 	}
+	if envelope.Version == signedEnvelopeVersion && (envelope.Channel == "" || envelope.Timestamp == 0 || envelope.Signature == "") {
+		return encryptedEnvelope{}, nil, nil, nil, fmt.Errorf("incomplete signed envelope")
+	}
 
 	ephemeralBytes, err := base64.StdEncoding.DecodeString(envelope.Ephemeral)
 	if err != nil {
@@ -57,3 +57,31 @@ func TestSaveAndLoadKeyPair(t *testing.T) {
 	assert.DeepEqual(t, loadedPublicKey, publicKey)
 	assert.DeepEqual(t, loadedPrivateKey, privateKey)
 }
+
+func TestRotateKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "client-key.json")
+	nextPath := filepath.Join(dir, "client-key.json.next")
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveKeyPair(keyPath, publicKey, privateKey))
+
+	fingerprint, nextPublicKey, err := RotateKeyPair(keyPath, nextPath, nil, SaveKeyPair)
+	assert.NilError(t, err)
+	assert.Equal(t, fingerprint, PublicKeyFingerprint(publicKey))
+
+	loadedPublicKey, _, err := LoadKeyPair(nextPath)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loadedPublicKey, nextPublicKey)
+	assert.Assert(t, *nextPublicKey != *publicKey)
+	// the current keypair is left alone
+	currentPublicKey, _, err := LoadKeyPair(keyPath)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, currentPublicKey, publicKey)
+
+	_, _, err = RotateKeyPair(keyPath, nextPath, nil, SaveKeyPair)
+	assert.ErrorContains(t, err, "already exists")
+
+	_, _, err = RotateKeyPair(filepath.Join(dir, "missing.json"), filepath.Join(dir, "other.next"), nil, SaveKeyPair)
+	assert.ErrorContains(t, err, "load current keypair")
+}
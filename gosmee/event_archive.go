@@ -0,0 +1,378 @@
+package gosmee
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	saveFormatFiles    = "files"
+	saveFormatJSONL    = "jsonl"
+	saveFormatJSONLGz  = "jsonl.gz"
+	eventArchivePrefix = "events"
+)
+
+// savedEventRecord is one line of a JSONL archive. The body is kept inline,
+// as raw JSON when the payload is valid JSON and base64 encoded otherwise.
+type savedEventRecord struct {
+	Name string `json:"name"`
+	savedEventMeta
+	Body       json.RawMessage `json:"body,omitempty"`
+	BodyBase64 string          `json:"body_base64,omitempty"`
+	// Update records only carry new metadata, such as a delivery result, for
+	// the event recorded earlier under the same name and keep its body.
+	Update bool `json:"update,omitempty"`
+}
+
+// eventArchive appends saved events to an append-only JSONL file, optionally
+// gzip compressed, and rotates it once it grows over maxSize bytes.
+//
+// A gzip member is only complete once closed, so a compressed archive is
+// written to a new file on every run: a process killed before closing it
+// leaves a truncated file that can still be read up to its last event,
+// instead of corrupting the members appended after it.
+type eventArchive struct {
+	mu       sync.Mutex
+	dir      string
+	compress bool
+	maxSize  int64
+	now      func() time.Time
+
+	f       *os.File
+	gz      *gzip.Writer
+	current string
+	size    int64
+	names   map[string]savedEventMeta
+}
+
+func newEventArchive(dir, format string, maxSize int64) (*eventArchive, error) {
+	switch format {
+	case "", saveFormatFiles:
+		return nil, nil
+	case saveFormatJSONL, saveFormatJSONLGz:
+	default:
+		return nil, fmt.Errorf("invalid save format %q, must be one of %s, %s or %s", format, saveFormatFiles, saveFormatJSONL, saveFormatJSONLGz)
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("--save-format %s requires --saveDir", format)
+	}
+	return &eventArchive{
+		dir:      dir,
+		compress: format == saveFormatJSONLGz,
+		maxSize:  maxSize,
+		now:      time.Now,
+	}, nil
+}
+
+// newEventArchiveFromFlags returns the archive of --save-format, nil for
+// files, and checks --export-format, which only applies to files.
+func newEventArchiveFromFlags(c *cli.Context) (*eventArchive, error) {
+	if err := validateExportFormats(c.StringSlice("export-format")); err != nil {
+		return nil, err
+	}
+	archive, err := newEventArchive(c.String("saveDir"), c.String("save-format"), c.Int64("save-archive-max-size")*1024*1024)
+	if err != nil {
+		return nil, err
+	}
+	if archive != nil && c.IsSet("export-format") {
+		return nil, fmt.Errorf("--export-format only applies to --save-format %s, use \"gosmee events export\" to export archived events", saveFormatFiles)
+	}
+	return archive, nil
+}
+
+func (a *eventArchive) extension() string {
+	if a.compress {
+		return "." + saveFormatJSONLGz
+	}
+	return "." + saveFormatJSONL
+}
+
+// path returns the file events are appended to, or were last appended to
+// for a compressed archive between two runs or rotations.
+func (a *eventArchive) path() string {
+	if a.current != "" {
+		return a.current
+	}
+	return filepath.Join(a.dir, eventArchivePrefix+a.extension())
+}
+
+// datedPath returns a not yet existing archive file name for the current
+// time, used by rotations and by the runs of a compressed archive.
+func (a *eventArchive) datedPath() string {
+	base := fmt.Sprintf("%s-%s", eventArchivePrefix, a.now().UTC().Format("20060102T150405.000"))
+	path := filepath.Join(a.dir, base+a.extension())
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(a.dir, fmt.Sprintf("%s-%d%s", base, n, a.extension()))
+	}
+}
+
+func (a *eventArchive) open() error {
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return err
+	}
+	if a.compress {
+		a.current = a.datedPath()
+	}
+	f, err := os.OpenFile(a.path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f = f
+	a.size = info.Size()
+	if a.compress {
+		a.gz = gzip.NewWriter(f)
+	}
+	return nil
+}
+
+// loadNames returns the names of the events recorded in every archive of
+// the directory, rotated ones and the ones of earlier runs included.
+func (a *eventArchive) loadNames() (map[string]savedEventMeta, error) {
+	names := map[string]savedEventMeta{}
+	entries, err := os.ReadDir(a.dir)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isEventArchiveFile(entry.Name()) {
+			continue
+		}
+		events, err := readEventArchive(filepath.Join(a.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			names[ev.Name] = ev.Meta
+		}
+	}
+	return names, nil
+}
+
+// Save appends the event to the archive under a name derived from base and
+// returns that name. Like in files mode, an event saved again reuses the name
+// of its earlier record and other events get a numbered suffix.
+func (a *eventArchive) Save(base string, meta savedEventMeta, body []byte) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.names == nil {
+		names, err := a.loadNames()
+		if err != nil {
+			return "", err
+		}
+		a.names = names
+	}
+
+	name := base
+	for n := 2; ; n++ {
+		existing, ok := a.names[name]
+		if !ok || existing.sameEvent(meta) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", base, n)
+	}
+	a.names[name] = meta
+	if err := a.appendLocked(newSavedEventRecord(name, meta, body)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Append writes record as one JSON line and flushes it to disk.
+func (a *eventArchive) Append(record savedEventRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.appendLocked(record)
+}
+
+// Update records new metadata for the event saved as name, without writing
+// its body again.
+func (a *eventArchive) Update(name string, meta savedEventMeta) error {
+	return a.Append(savedEventRecord{Name: name, savedEventMeta: meta, Update: true})
+}
+
+func (a *eventArchive) appendLocked(record savedEventRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if a.f == nil {
+		if err := a.open(); err != nil {
+			return fmt.Errorf("open event archive: %w", err)
+		}
+	}
+
+	var w io.Writer = a.f
+	if a.gz != nil {
+		w = a.gz
+	}
+	if _, err := w.Write(line); err != nil {
+		return fmt.Errorf("write event archive: %w", err)
+	}
+	if a.gz != nil {
+		if err := a.gz.Flush(); err != nil {
+			return fmt.Errorf("flush event archive: %w", err)
+		}
+	}
+	if err := a.f.Sync(); err != nil {
+		return fmt.Errorf("sync event archive: %w", err)
+	}
+	if info, err := a.f.Stat(); err == nil {
+		a.size = info.Size()
+	}
+
+	if a.maxSize > 0 && a.size >= a.maxSize {
+		return a.rotate()
+	}
+	return nil
+}
+
+func (a *eventArchive) rotate() error {
+	if err := a.closeLocked(); err != nil {
+		return err
+	}
+	if a.compress {
+		// already dated, the next event opens a new file
+		return nil
+	}
+	if err := os.Rename(a.path(), a.datedPath()); err != nil {
+		return fmt.Errorf("rotate event archive: %w", err)
+	}
+	return nil
+}
+
+func (a *eventArchive) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeLocked()
+}
+
+func (a *eventArchive) closeLocked() error {
+	if a.f == nil {
+		return nil
+	}
+	var err error
+	if a.gz != nil {
+		err = a.gz.Close()
+		a.gz = nil
+	}
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	a.f = nil
+	a.size = 0
+	return err
+}
+
+func newSavedEventRecord(name string, meta savedEventMeta, body []byte) savedEventRecord {
+	record := savedEventRecord{Name: name, savedEventMeta: meta}
+	if len(body) > 0 && json.Valid(body) {
+		record.Body = json.RawMessage(body)
+	} else if len(body) > 0 {
+		record.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	return record
+}
+
+func isEventArchiveFile(name string) bool {
+	return strings.HasPrefix(name, eventArchivePrefix) &&
+		(strings.HasSuffix(name, "."+saveFormatJSONL) || strings.HasSuffix(name, "."+saveFormatJSONLGz))
+}
+
+// readEventArchive returns the events stored in a JSONL archive. When an
+// event was recorded several times, for example once per delivery attempt,
+// only its last record is kept, with the body of its last full record.
+func readEventArchive(path string) ([]savedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if errors.Is(err, io.EOF) {
+			// killed before the first event was written
+			return []savedEvent{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	events := []savedEvent{}
+	index := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var record savedEventRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		body := []byte(record.Body)
+		if record.BodyBase64 != "" {
+			if body, err = base64.StdEncoding.DecodeString(record.BodyBase64); err != nil {
+				return nil, fmt.Errorf("decode body of %s in %s: %w", record.Name, path, err)
+			}
+		}
+		ev := savedEvent{Name: record.Name, Meta: record.savedEventMeta, body: body, update: record.Update}
+		events = mergeArchivedEvent(events, index, ev)
+	}
+	// A process killed while writing leaves a truncated gzip member, keep
+	// what could be read.
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return events, nil
+}
+
+// mergeArchivedEvent adds ev to events, replacing the earlier record of the
+// same event found through index. An update record keeps the body of the
+// record it replaces.
+func mergeArchivedEvent(events []savedEvent, index map[string]int, ev savedEvent) []savedEvent {
+	i, ok := index[ev.Name]
+	if !ok {
+		index[ev.Name] = len(events)
+		return append(events, ev)
+	}
+	if ev.update {
+		ev.body, ev.BodyFile = events[i].body, events[i].BodyFile
+		ev.update = events[i].update
+	}
+	events[i] = ev
+	return events
+}
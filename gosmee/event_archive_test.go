@@ -0,0 +1,230 @@
+package gosmee
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gotest.tools/v3/assert"
+)
+
+func archiveTestPayload(deliveryID, body string) payloadMsg {
+	return payloadMsg{
+		headers:     map[string]string{"X-Github-Event": "push", "X-Github-Delivery": deliveryID},
+		body:        []byte(body),
+		timestamp:   "2024-01-01T10.00.01.000",
+		contentType: "application/json",
+		eventType:   "push",
+		eventID:     deliveryID,
+	}
+}
+
+func TestSaveEventCollisionFreeNames(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+	ropts := &replayDataOpts{saveDir: dir, targetURL: "http://localhost:8080", channel: "mychannel"}
+
+	first, err := saveEvent(ropts, logger, archiveTestPayload("delivery-1", `{"n":1}`))
+	assert.NilError(t, err)
+	assert.Equal(t, first, "push-2024-01-01T10.00.01.000")
+
+	// same timestamp, another event
+	second, err := saveEvent(ropts, logger, archiveTestPayload("delivery-2", `{"n":2}`))
+	assert.NilError(t, err)
+	assert.Equal(t, second, "push-2024-01-01T10.00.01.000-2")
+
+	// the first event saved again while being retried keeps its name
+	again, err := saveEvent(ropts, logger, archiveTestPayload("delivery-1", `{"n":1}`))
+	assert.NilError(t, err)
+	assert.Equal(t, again, first)
+
+	meta, err := readSavedEventMeta(dir, second)
+	assert.NilError(t, err)
+	assert.Equal(t, meta.DeliveryID, "delivery-2")
+	assert.Equal(t, meta.Channel, "mychannel")
+	assert.Equal(t, meta.ContentType, "application/json")
+	assert.Assert(t, meta.BodySHA256 != "")
+
+	events, err := loadSavedEvents(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 2)
+}
+
+func TestSaveAndDeliverRecordsResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+	ropts := &replayDataOpts{saveDir: dir, targetURL: server.URL, targetCnxTimeout: 1}
+	pm := archiveTestPayload("delivery-1", `{}`)
+
+	err := saveAndDeliver(ropts, logger, pm, pm, true)
+	assert.ErrorContains(t, err, "502")
+
+	meta, err := readSavedEventMeta(dir, "push-2024-01-01T10.00.01.000")
+	assert.NilError(t, err)
+	assert.Assert(t, meta.Result != nil)
+	assert.Equal(t, meta.Result.Status, http.StatusBadGateway)
+	assert.Equal(t, meta.Result.ErrorKind, "http_status")
+
+	ropts.saveDir = filepath.Join(dir, "file")
+	assert.NilError(t, os.WriteFile(ropts.saveDir, nil, 0o600))
+	assert.ErrorContains(t, saveAndDeliver(ropts, logger, pm, pm, true), "saving message")
+}
+
+func TestEventArchive(t *testing.T) {
+	for _, format := range []string{saveFormatJSONL, saveFormatJSONLGz} {
+		t.Run(format, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+			archive, err := newEventArchive(dir, format, 0)
+			assert.NilError(t, err)
+			logger := slog.New(slog.DiscardHandler)
+			ropts := &replayDataOpts{saveDir: dir, targetURL: server.URL, targetCnxTimeout: 1, archive: archive}
+
+			pm := archiveTestPayload("delivery-1", `{"n":1}`)
+			assert.NilError(t, saveAndDeliver(ropts, logger, pm, pm, true))
+			binary := archiveTestPayload("delivery-2", "not json")
+			binary.contentType = "text/plain"
+			assert.NilError(t, saveAndDeliver(ropts, logger, binary, binary, true))
+			assert.NilError(t, archive.Close())
+
+			_, err = os.Stat(filepath.Join(dir, "push-2024-01-01T10.00.01.000.json"))
+			assert.Assert(t, os.IsNotExist(err))
+
+			events, err := loadSavedEvents(dir)
+			assert.NilError(t, err)
+			assert.Equal(t, len(events), 2)
+			assert.Equal(t, events[0].Name, "push-2024-01-01T10.00.01.000")
+			assert.Assert(t, events[0].Meta.Result != nil)
+			assert.Equal(t, events[0].Meta.Result.Status, http.StatusOK)
+			assert.Equal(t, events[1].Name, "push-2024-01-01T10.00.01.000-2")
+
+			got, err := events[1].payload()
+			assert.NilError(t, err)
+			assert.Equal(t, string(got.body), "not json")
+			assert.Equal(t, got.contentType, "text/plain")
+		})
+	}
+}
+
+func TestEventArchiveRotation(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := newEventArchive(dir, saveFormatJSONL, 1)
+	assert.NilError(t, err)
+	archive.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	meta := savedEventMeta{Version: savedEventMetaVersion, EventType: "push", SavedAt: time.Now().UTC()}
+	_, err = archive.Save("push-1", meta, []byte(`{}`))
+	assert.NilError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "events-20240101T000000.000.jsonl"))
+	assert.NilError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "events.jsonl"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	events, err := loadSavedEvents(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+}
+
+func TestNewEventArchiveInvalid(t *testing.T) {
+	_, err := newEventArchive("", saveFormatJSONL, 0)
+	assert.ErrorContains(t, err, "requires --saveDir")
+	_, err = newEventArchive(t.TempDir(), "xml", 0)
+	assert.ErrorContains(t, err, "invalid save format")
+	archive, err := newEventArchive(t.TempDir(), saveFormatFiles, 0)
+	assert.NilError(t, err)
+	assert.Assert(t, archive == nil)
+}
+
+func TestNewEventArchiveFromFlags(t *testing.T) {
+	newContext := func(args ...string) *cli.Context {
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range selectFlags(commonFlags, "saveDir", "export-format", "save-format", "save-archive-max-size") {
+			assert.NilError(t, f.Apply(flagSet))
+		}
+		assert.NilError(t, flagSet.Parse(args))
+		return cli.NewContext(cli.NewApp(), flagSet, nil)
+	}
+	dir := t.TempDir()
+
+	archive, err := newEventArchiveFromFlags(newContext("--saveDir", dir, "--export-format", "har"))
+	assert.NilError(t, err)
+	assert.Assert(t, archive == nil)
+	_, err = newEventArchiveFromFlags(newContext("--saveDir", dir, "--export-format", "xml"))
+	assert.ErrorContains(t, err, "unknown export format")
+	_, err = newEventArchiveFromFlags(newContext("--saveDir", dir, "--save-format", saveFormatJSONL, "--export-format", "har"))
+	assert.ErrorContains(t, err, "--export-format only applies to --save-format files")
+	archive, err = newEventArchiveFromFlags(newContext("--saveDir", dir, "--save-format", saveFormatJSONL))
+	assert.NilError(t, err)
+	assert.Assert(t, archive != nil)
+}
+
+func TestEventArchiveInterruptedRun(t *testing.T) {
+	dir := t.TempDir()
+	meta := savedEventMeta{Version: savedEventMetaVersion, EventType: "push", SavedAt: time.Now().UTC()}
+
+	killed, err := newEventArchive(dir, saveFormatJSONLGz, 0)
+	assert.NilError(t, err)
+	_, err = killed.Save("push-1", meta, []byte(`{"n":1}`))
+	assert.NilError(t, err)
+	meta.Result = &deliveryResult{Status: http.StatusOK}
+	assert.NilError(t, killed.Update("push-1", meta))
+	// killed without closing its gzip member
+	assert.NilError(t, killed.f.Close())
+
+	next, err := newEventArchive(dir, saveFormatJSONLGz, 0)
+	assert.NilError(t, err)
+	next.now = func() time.Time { return time.Now().Add(time.Second) }
+	name, err := next.Save("push-1", savedEventMeta{Version: savedEventMetaVersion, EventType: "push", DeliveryID: "other", SavedAt: meta.SavedAt.Add(time.Second)}, []byte(`{"n":2}`))
+	assert.NilError(t, err)
+	assert.Equal(t, name, "push-1-2")
+	assert.NilError(t, next.Close())
+	assert.Assert(t, killed.path() != next.path())
+
+	events, err := loadSavedEvents(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Name, "push-1")
+	assert.Assert(t, events[0].Meta.Result != nil)
+	got, err := events[0].payload()
+	assert.NilError(t, err)
+	assert.Equal(t, string(got.body), `{"n":1}`)
+}
+
+func TestEventArchiveUpdateKeepsBody(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := newEventArchive(dir, saveFormatJSONL, 0)
+	assert.NilError(t, err)
+	meta := savedEventMeta{Version: savedEventMetaVersion, EventType: "push"}
+	_, err = archive.Save("push-1", meta, []byte(`{"large":"body"}`))
+	assert.NilError(t, err)
+	meta.Result = &deliveryResult{Status: http.StatusOK}
+	assert.NilError(t, archive.Update("push-1", meta))
+	assert.NilError(t, archive.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, "events.jsonl"))
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Count(string(data), `"large"`), 1)
+
+	events, err := readEventArchive(filepath.Join(dir, "events.jsonl"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Meta.Result.Status, http.StatusOK)
+	assert.Equal(t, string(events[0].body), `{"large":"body"}`)
+}
@@ -0,0 +1,308 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/mgutz/ansi"
+	"github.com/urfave/cli/v2"
+)
+
+type eventsOpts struct {
+	dir    string
+	filter savedEventFilter
+	output string
+	out    io.Writer
+	logger *slog.Logger
+	sleep  func(context.Context, time.Duration) error
+}
+
+func newEventsOpts(c *cli.Context) (*eventsOpts, error) {
+	dir := c.String("saveDir")
+	if dir == "" {
+		return nil, fmt.Errorf("required flag \"saveDir\" not set")
+	}
+	logger, nocolor, err := getLogger(c)
+	if err != nil {
+		return nil, err
+	}
+	if nocolor || !isatty.IsTerminal(os.Stdout.Fd()) {
+		ansi.DisableColors(true)
+	}
+
+	filter := savedEventFilter{
+		eventTypes:  c.StringSlice("event-type"),
+		deliveryIDs: c.StringSlice("delivery-id"),
+	}
+	if since := c.String("since"); since != "" {
+		if filter.since, err = time.Parse(userTSFormat, since); err != nil {
+			return nil, fmt.Errorf("cannot parse since: %w", err)
+		}
+	}
+	if until := c.String("until"); until != "" {
+		if filter.until, err = time.Parse(userTSFormat, until); err != nil {
+			return nil, fmt.Errorf("cannot parse until: %w", err)
+		}
+	}
+
+	return &eventsOpts{
+		dir:    dir,
+		filter: filter,
+		output: c.String("output"),
+		out:    os.Stdout,
+		logger: logger,
+		sleep:  sleepWithContext,
+	}, nil
+}
+
+func (e *eventsOpts) load() ([]savedEvent, error) {
+	events, err := loadSavedEvents(e.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read saved events: %w", err)
+	}
+	return filterSavedEvents(events, e.filter), nil
+}
+
+func (e *eventsOpts) list() error {
+	events, err := e.load()
+	if err != nil {
+		return err
+	}
+	if e.output == "json" {
+		enc := json.NewEncoder(e.out)
+		for _, ev := range events {
+			if err := enc.Encode(savedEventJSON(ev)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fmt.Fprint(e.out, ansi.Color(fmt.Sprintf("%-45s %-20s %-38s %-20s %s\n", "Name", "Event", "Delivery ID", "Saved At", "Result"), "cyan+b")) // nolint:staticcheck
+	for _, ev := range events {
+		fmt.Fprintf(e.out, "%-45s %-20s %-38s %-20s %s\n", ev.Name, ev.Meta.EventType, ev.Meta.DeliveryID, ev.Meta.SavedAt.Local().Format(userTSFormat), savedEventResult(ev))
+	}
+	return nil
+}
+
+func (e *eventsOpts) show(id string) error {
+	events, err := loadSavedEvents(e.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read saved events: %w", err)
+	}
+	ev, ok := findSavedEvent(events, id)
+	if !ok {
+		return fmt.Errorf("no saved event %q in %s", id, e.dir)
+	}
+	pm, err := ev.payload()
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		ret := savedEventJSON(ev)
+		ret["body"] = json.RawMessage(pm.body)
+		if !json.Valid(pm.body) {
+			ret["body"] = string(pm.body)
+		}
+		return json.NewEncoder(e.out).Encode(ret)
+	}
+
+	fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Name:", "cyan+b"), ev.Name)
+	fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Event:", "cyan+b"), ev.Meta.EventType)
+	fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Delivery ID:", "cyan+b"), ev.Meta.DeliveryID)
+	fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Saved At:", "cyan+b"), ev.Meta.SavedAt.Local().Format(userTSFormat))
+	if ev.Meta.Channel != "" {
+		fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Channel:", "cyan+b"), ev.Meta.Channel)
+	}
+	if ev.Meta.StreamID != "" {
+		fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Stream ID:", "cyan+b"), ev.Meta.StreamID)
+	}
+	fmt.Fprintf(e.out, "%s %s\n", ansi.Color("Result:", "cyan+b"), savedEventResult(ev))
+	fmt.Fprintln(e.out, ansi.Color("Headers:", "cyan+b"))
+	keys := make([]string, 0, len(pm.headers))
+	for k := range pm.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(e.out, "  %s: %s\n", k, pm.headers[k])
+	}
+	fmt.Fprintln(e.out, ansi.Color("Body:", "cyan+b"))
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, pm.body, "", "  "); err == nil {
+		fmt.Fprintln(e.out, pretty.String())
+	} else {
+		fmt.Fprintln(e.out, string(pm.body))
+	}
+	return nil
+}
+
+func (e *eventsOpts) selectByName(events []savedEvent, names []string) ([]savedEvent, error) {
+	selected := make([]savedEvent, 0, len(names))
+	for _, name := range names {
+		ev, ok := findSavedEvent(events, name)
+		if !ok {
+			return nil, fmt.Errorf("no saved event %q in %s matching the filters", name, e.dir)
+		}
+		selected = append(selected, ev)
+	}
+	return selected, nil
+}
+
+// replay forwards the selected saved events to ropts.targetURL, oldest
+// first, and returns an error when at least one delivery failed.
+func (e *eventsOpts) replay(ctx context.Context, ropts *replayDataOpts, names []string, all bool) error {
+	events, err := e.load()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		if events, err = e.selectByName(events, names); err != nil {
+			return err
+		}
+	} else if !all && len(e.filter.eventTypes) == 0 && len(e.filter.deliveryIDs) == 0 && e.filter.since.IsZero() && e.filter.until.IsZero() {
+		return fmt.Errorf("select events to replay by name, with filters or with --all")
+	}
+
+	failed := 0
+	for _, ev := range events {
+		pm, err := ev.payload()
+		if err != nil {
+			failed++
+			e.logger.LogAttrs(ctx, slog.LevelError, "cannot read saved event", slog.String("name", ev.Name), slog.String("error", err.Error()))
+			continue
+		}
+		forwarded, _, err := transformPayload(ropts, pm)
+		if err != nil {
+			failed++
+			e.logger.LogAttrs(ctx, slog.LevelError, "transforming saved event failed", slog.String("name", ev.Name), slog.String("error", err.Error()))
+			continue
+		}
+		if err := deliverWithRetries(ctx, ropts, e.logger, forwarded, e.sleep); err != nil {
+			failed++
+			e.logger.LogAttrs(ctx, slog.LevelError, "replaying saved event failed", slog.String("name", ev.Name),
+				slog.String("delivery_id", pm.eventID), slog.String("error", err.Error()))
+		}
+	}
+
+	e.logger.InfoContext(ctx, fmt.Sprintf("%d saved events replayed, %d failed", len(events)-failed, failed))
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d saved events failed to replay", failed), 1)
+	}
+	return nil
+}
+
+// export converts the selected saved events, all the ones matching the
+// filters when no name is given. targetURL is used for the events saved
+// without one.
+func (e *eventsOpts) export(format, out, targetURL string, names []string) error {
+	events, err := e.load()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		if events, err = e.selectByName(events, names); err != nil {
+			return err
+		}
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("no saved events to export in %s", e.dir)
+	}
+	return exportSavedEvents(format, out, targetURL, e.out, events)
+}
+
+// deliverWithRetries forwards pm and retries transient target failures up to
+// ropts.targetRetries times with exponential backoff.
+func deliverWithRetries(ctx context.Context, ropts *replayDataOpts, logger *slog.Logger, pm payloadMsg, sleep func(context.Context, time.Duration) error) error {
+	backoff := newRetryBackoff()
+	maxAttempts := 1 + max(ropts.targetRetries, 0)
+	for attempt := 1; ; attempt++ {
+		err := replayDataWithStatusPolicy(ropts, logger, pm, true)
+		if err == nil {
+			return nil
+		}
+		var deliveryErr *targetDeliveryError
+		if !errors.As(err, &deliveryErr) || !deliveryErr.retryable || attempt >= maxAttempts {
+			return err
+		}
+		delay := backoff.Next()
+		if deliveryErr.retryAfter > delay {
+			delay = min(deliveryErr.retryAfter, maxRetryDelay)
+		}
+		attrs := deliveryAttrs(ropts, pm, pm.streamID, attempt, maxAttempts, deliveryErr)
+		attrs = append(attrs, slog.Duration("retry_in", delay), slog.String("error", err.Error()))
+		logger.LogAttrs(ctx, slog.LevelWarn, "target delivery failed; retrying", attrs...)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func savedEventJSON(ev savedEvent) map[string]any {
+	return map[string]any{
+		"name":         ev.Name,
+		"event_type":   ev.Meta.EventType,
+		"delivery_id":  ev.Meta.DeliveryID,
+		"stream_id":    ev.Meta.StreamID,
+		"channel":      ev.Meta.Channel,
+		"timestamp":    ev.Meta.Timestamp,
+		"saved_at":     ev.Meta.SavedAt,
+		"content_type": ev.Meta.ContentType,
+		"headers":      ev.Meta.Headers,
+		"result":       ev.Meta.Result,
+	}
+}
+
+// savedEventResult summarizes how forwarding the event went.
+func savedEventResult(ev savedEvent) string {
+	r := ev.Meta.Result
+	switch {
+	case r == nil:
+		return "-"
+	case r.Error != "" && r.Status == 0:
+		return r.ErrorKind
+	case r.Error != "":
+		return fmt.Sprintf("%d %s", r.Status, r.ErrorKind)
+	default:
+		return fmt.Sprintf("%d", r.Status)
+	}
+}
+
+func eventsReplay(c *cli.Context) error {
+	e, err := newEventsOpts(c)
+	if err != nil {
+		return err
+	}
+	if c.NArg() < 1 {
+		return fmt.Errorf("missing the target url where to replay the events, ie: http://localhost:8080")
+	}
+	targetURL := c.Args().Get(0)
+	if _, err := url.Parse(targetURL); err != nil {
+		return fmt.Errorf("target url %s is not a valid url %w", targetURL, err)
+	}
+	transforms, err := newRequestTransformsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	ropts := &replayDataOpts{
+		targetURL:         targetURL,
+		decorate:          e.output != "json" && !c.Bool("nocolor") && isatty.IsTerminal(os.Stdout.Fd()),
+		targetCnxTimeout:  c.Int("target-connection-timeout"),
+		targetRetries:     c.Int("target-retries"),
+		insecureTLSVerify: c.Bool("insecure-skip-tls-verify"),
+		transforms:        transforms,
+		resignSecret:      c.String("resign-secret"),
+	}
+	return e.replay(context.Background(), ropts, c.Args().Tail(), c.Bool("all"))
+}
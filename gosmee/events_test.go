@@ -0,0 +1,165 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func saveTestEvents(t *testing.T, dir string) {
+	t.Helper()
+	logger := slog.New(slog.DiscardHandler)
+	ropts := &replayDataOpts{saveDir: dir, targetURL: "http://localhost:8080"}
+	for _, pm := range []payloadMsg{
+		{
+			headers:     map[string]string{"X-Github-Event": "push", "X-Github-Delivery": "delivery-1"},
+			body:        []byte(`{"ref":"refs/heads/main"}`),
+			timestamp:   "2024-01-01T10.00.01.000",
+			contentType: "application/json",
+			eventType:   "push",
+			eventID:     "delivery-1",
+		},
+		{
+			headers:     map[string]string{"X-Github-Event": "pull_request", "X-Github-Delivery": "delivery-2"},
+			body:        []byte(`{"action":"opened"}`),
+			timestamp:   "2024-01-01T10.00.01.001",
+			contentType: "application/json",
+			eventType:   "pull_request",
+			eventID:     "delivery-2",
+		},
+	} {
+		assert.NilError(t, saveData(ropts, logger, pm))
+		// make sure saved_at differs so ordering is stable
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestLoadSavedEvents(t *testing.T) {
+	dir := t.TempDir()
+	saveTestEvents(t, dir)
+	// payload saved by an older gosmee without a metadata file
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "issues-2023-01-01T10.00.01.000.json"), []byte(`{}`), 0o600))
+	// copied without keeping its file time, the time comes from its name
+	copied := time.Now().Add(time.Hour)
+	assert.NilError(t, os.Chtimes(filepath.Join(dir, "issues-2023-01-01T10.00.01.000.json"), copied, copied))
+	old := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	events, err := loadSavedEvents(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[0].Meta.EventType, "issues")
+	assert.Equal(t, events[0].Meta.Timestamp, "2023-01-01T10.00.01.000")
+	assert.Equal(t, events[0].Meta.eventTime(), old)
+	assert.Equal(t, events[1].Meta.DeliveryID, "delivery-1")
+	assert.Equal(t, events[1].Meta.Headers["X-Github-Event"], "push")
+	assert.Equal(t, events[2].Name, "pull_request-2024-01-01T10.00.01.001")
+
+	filtered := filterSavedEvents(events, savedEventFilter{eventTypes: []string{"push", "issues"}})
+	assert.Equal(t, len(filtered), 2)
+	filtered = filterSavedEvents(events, savedEventFilter{since: old.Add(time.Hour)})
+	assert.Equal(t, len(filtered), 2)
+	filtered = filterSavedEvents(events, savedEventFilter{until: old.Add(time.Hour)})
+	assert.Equal(t, len(filtered), 1)
+
+	// the receive time wins over the save time, for events replayed later
+	received := savedEvent{Meta: savedEventMeta{ReceivedAt: old, SavedAt: time.Now()}}
+	assert.Assert(t, !savedEventFilter{since: old.Add(time.Hour)}.match(received))
+	assert.Assert(t, savedEventFilter{until: old.Add(time.Hour)}.match(received))
+
+	ev, ok := findSavedEvent(events, "delivery-2")
+	assert.Assert(t, ok)
+	assert.Equal(t, ev.Meta.EventType, "pull_request")
+	ev, ok = findSavedEvent(events, filepath.Join(dir, "push-2024-01-01T10.00.01.000.sh"))
+	assert.Assert(t, ok)
+	assert.Equal(t, ev.Meta.DeliveryID, "delivery-1")
+	_, ok = findSavedEvent(events, "nope")
+	assert.Assert(t, !ok)
+}
+
+func TestEventsListAndShow(t *testing.T) {
+	dir := t.TempDir()
+	saveTestEvents(t, dir)
+
+	var out bytes.Buffer
+	e := &eventsOpts{dir: dir, output: "json", out: &out, logger: slog.New(slog.DiscardHandler)}
+	assert.NilError(t, e.list())
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+	var first map[string]any
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, first["delivery_id"], "delivery-1")
+
+	out.Reset()
+	e.output = "pretty"
+	assert.NilError(t, e.show("delivery-2"))
+	assert.Assert(t, strings.Contains(out.String(), "X-Github-Event: pull_request"))
+	assert.Assert(t, strings.Contains(out.String(), `"action": "opened"`))
+
+	assert.ErrorContains(t, e.show("missing"), "no saved event")
+}
+
+func TestEventsReplay(t *testing.T) {
+	dir := t.TempDir()
+	saveTestEvents(t, dir)
+
+	var mu sync.Mutex
+	var received []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r.Header.Get("X-Github-Delivery")+" "+string(body)+" "+r.Header.Get("X-Hub-Signature-256"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	e := &eventsOpts{
+		dir:    dir,
+		filter: savedEventFilter{eventTypes: []string{"push"}},
+		out:    io.Discard,
+		logger: slog.New(slog.DiscardHandler),
+		sleep:  func(context.Context, time.Duration) error { return nil },
+	}
+	ropts := &replayDataOpts{targetURL: server.URL, targetCnxTimeout: 1, targetRetries: 2, resignSecret: "dev"}
+	assert.NilError(t, e.replay(context.Background(), ropts, nil, false))
+	assert.Equal(t, attempts, 2)
+	assert.DeepEqual(t, received, []string{
+		"delivery-1 " + `{"ref":"refs/heads/main"}` + " sha256=" + hmacSHA256Hex("dev", []byte(`{"ref":"refs/heads/main"}`)),
+	})
+
+	e.filter = savedEventFilter{}
+	assert.ErrorContains(t, e.replay(context.Background(), ropts, nil, false), "select events to replay")
+	assert.ErrorContains(t, e.replay(context.Background(), ropts, []string{"missing"}, false), "no saved event")
+}
+
+func TestEventsReplayFailureExitCode(t *testing.T) {
+	dir := t.TempDir()
+	saveTestEvents(t, dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	e := &eventsOpts{dir: dir, out: io.Discard, logger: slog.New(slog.DiscardHandler)}
+	ropts := &replayDataOpts{targetURL: server.URL, targetCnxTimeout: 1}
+	err := e.replay(context.Background(), ropts, nil, true)
+	assert.ErrorContains(t, err, "2 saved events failed to replay")
+}
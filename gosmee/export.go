@@ -0,0 +1,500 @@
+package gosmee
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	exportFormatCurl    = "curl"
+	exportFormatHttpie  = "httpie"
+	exportFormatHAR     = "har"
+	exportFormatPostman = "postman"
+	exportFormatHTTP    = "http"
+	exportFormatRaw     = "raw"
+)
+
+// exportRequest is a saved event as the HTTP request it is forwarded as.
+type exportRequest struct {
+	Name          string
+	URL           string
+	LocalDebugURL string
+	Headers       map[string]string
+	ContentType   string
+	Body          []byte
+	SavedAt       time.Time
+	Result        *deliveryResult
+}
+
+// exportFormat describes a file format saved events can be exported to.
+type exportFormat struct {
+	// ext is appended to the event name when saving one file per event.
+	ext string
+	// script formats reference the payload file saved next to them and
+	// cannot combine several events in one file.
+	script bool
+	render func(w io.Writer, reqs []exportRequest) error
+}
+
+var exportFormats = map[string]exportFormat{
+	exportFormatCurl:    {ext: ".sh", script: true, render: renderCurlScript},
+	exportFormatHttpie:  {ext: ".sh", script: true, render: renderHttpieScript},
+	exportFormatHAR:     {ext: ".har", render: renderHAR},
+	exportFormatPostman: {ext: ".postman_collection.json", render: renderPostman},
+	exportFormatHTTP:    {ext: ".http", render: renderHTTPFile},
+	exportFormatRaw:     {ext: ".raw", render: renderRawHTTP},
+}
+
+func exportFormatNames() []string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupExportFormat(name string) (exportFormat, error) {
+	f, ok := exportFormats[name]
+	if !ok {
+		return exportFormat{}, fmt.Errorf("unknown export format %q, must be one of %s", name, strings.Join(exportFormatNames(), ", "))
+	}
+	return f, nil
+}
+
+// validateExportFormats checks the formats saved next to each payload, two
+// of them cannot write the same file.
+func validateExportFormats(names []string) error {
+	exts := map[string]string{}
+	for _, name := range names {
+		f, err := lookupExportFormat(name)
+		if err != nil {
+			return err
+		}
+		if other, ok := exts[f.ext]; ok && other != name {
+			return fmt.Errorf("export formats %s and %s both write %s files, choose one", other, name, f.ext)
+		}
+		exts[f.ext] = name
+	}
+	return nil
+}
+
+// isExportFile reports whether name is a file written by an export format
+// and not a saved payload.
+func isExportFile(name string) bool {
+	for _, f := range exportFormats {
+		if strings.HasSuffix(name, f.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// savedExportFormats returns the formats to save next to each payload in
+// --saveDir, curl scripts or httpie ones with --httpie by default.
+func (rd *replayDataOpts) savedExportFormats() []string {
+	if len(rd.exportFormats) > 0 {
+		return rd.exportFormats
+	}
+	if rd.useHttpie {
+		return []string{exportFormatHttpie}
+	}
+	return []string{exportFormatCurl}
+}
+
+func newExportRequest(name string, rd *replayDataOpts, pm payloadMsg, result *deliveryResult) exportRequest {
+	return exportRequest{
+		Name:          name,
+		URL:           deliveryTargetURL(rd, pm),
+		LocalDebugURL: rd.localDebugURL,
+		Headers:       pm.headers,
+		ContentType:   pm.contentType,
+		Body:          pm.body,
+		SavedAt:       time.Now().UTC(),
+		Result:        result,
+	}
+}
+
+// writeExportFiles writes the saved event in every configured format next
+// to its payload and returns the written paths.
+func writeExportFiles(rd *replayDataOpts, req exportRequest) ([]string, error) {
+	paths := []string{}
+	for _, name := range rd.savedExportFormats() {
+		f, err := lookupExportFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(rd.saveDir, req.Name+f.ext)
+		if err := writeExportFile(path, f, []exportRequest{req}); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeExportFile(path string, f exportFormat, reqs []exportRequest) error {
+	var buf bytes.Buffer
+	if err := f.render(&buf, reqs); err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if f.script {
+		mode = 0o755
+	}
+	if err := os.WriteFile(path, buf.Bytes(), mode); err != nil {
+		return err
+	}
+	// WriteFile does not change the mode of an existing file
+	return os.Chmod(path, mode)
+}
+
+// requestHeaders returns the headers the request is forwarded with, which
+// include the content type like in deliverPayload.
+func (req exportRequest) requestHeaders() map[string]string {
+	headers := maps.Clone(req.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	if _, ok := headers["Content-Type"]; !ok && req.ContentType != "" {
+		headers["Content-Type"] = req.ContentType
+	}
+	return headers
+}
+
+func sortedHeaderNames(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// headerLine strips line breaks so a header value cannot inject extra lines
+// in line based formats.
+func headerLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+}
+
+func renderScript(w io.Writer, reqs []exportRequest, tmplName string, tmplData []byte, buildHeaders func(map[string]string) string) error {
+	if len(reqs) != 1 {
+		return fmt.Errorf("replay scripts hold a single event, got %d", len(reqs))
+	}
+	req := reqs[0]
+	tmpl := template.Must(template.New(tmplName).Parse(string(tmplData)))
+	return tmpl.Execute(w, struct {
+		Headers       string
+		TargetURL     string
+		ContentType   string
+		FileBase      string
+		LocalDebugURL string
+	}{
+		Headers: buildHeaders(req.Headers),
+		// ContentType comes from the (untrusted) webhook payload, so shell-quote
+		// it to prevent breaking out of the command and injecting shell code.
+		// Headers are quoted in build{Curl,Httpie}Headers above. TargetURL,
+		// LocalDebugURL and FileBase are operator-provided or regex-sanitized
+		// (see pmEventRe) and are quoted with literal "" in the templates.
+		TargetURL:     req.URL,
+		LocalDebugURL: req.LocalDebugURL,
+		ContentType:   shellQuote(req.ContentType),
+		FileBase:      req.Name,
+	})
+}
+
+func renderCurlScript(w io.Writer, reqs []exportRequest) error {
+	return renderScript(w, reqs, "shellScriptTmpl", shellScriptTmpl, buildCurlHeaders)
+}
+
+func renderHttpieScript(w io.Writer, reqs []exportRequest) error {
+	return renderScript(w, reqs, "shellScriptTmplHttpie", shellScriptHttpieTmpl, buildHttpieHeaders)
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    harPostData    `json:"postData"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// renderHAR writes a HAR 1.2 log, the response part holds the result of the
+// delivery to the target when it is known.
+func renderHAR(w io.Writer, reqs []exportRequest) error {
+	entries := make([]harEntry, 0, len(reqs))
+	for _, req := range reqs {
+		reqHeaders := req.requestHeaders()
+		headers := make([]harNameValue, 0, len(reqHeaders))
+		for _, k := range sortedHeaderNames(reqHeaders) {
+			headers = append(headers, harNameValue{Name: k, Value: reqHeaders[k]})
+		}
+		query := []harNameValue{}
+		if u, err := url.Parse(req.URL); err == nil {
+			for k, values := range u.Query() {
+				for _, v := range values {
+					query = append(query, harNameValue{Name: k, Value: v})
+				}
+			}
+			sort.SliceStable(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+		}
+		postData := harPostData{MimeType: req.ContentType, Text: string(req.Body)}
+		if !utf8.Valid(req.Body) {
+			postData.Text = base64.StdEncoding.EncodeToString(req.Body)
+			postData.Encoding = "base64"
+		}
+		entry := harEntry{
+			StartedDateTime: req.SavedAt.Format(time.RFC3339Nano),
+			Request: harRequest{
+				Method:      "POST",
+				URL:         req.URL,
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     headers,
+				QueryString: query,
+				PostData:    postData,
+				HeadersSize: -1,
+				BodySize:    len(req.Body),
+			},
+			Response: harResponse{
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     []harNameValue{},
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+			Timings: harTimings{Send: 0, Wait: -1, Receive: 0},
+			Comment: req.Name,
+		}
+		if r := req.Result; r != nil {
+			entry.StartedDateTime = r.At.Add(-time.Duration(r.DurationMS) * time.Millisecond).Format(time.RFC3339Nano)
+			entry.Time = r.DurationMS
+			entry.Timings.Wait = r.DurationMS
+			entry.Response.Status = r.Status
+			entry.Response.Comment = r.Error
+		}
+		entries = append(entries, entry)
+	}
+
+	har := map[string]any{
+		"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]string{"name": "gosmee", "version": strings.TrimSpace(string(Version))},
+			"entries": entries,
+		},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(har)
+}
+
+// renderPostman writes a Postman v2.1 collection with one request per event.
+func renderPostman(w io.Writer, reqs []exportRequest) error {
+	items := make([]map[string]any, 0, len(reqs))
+	for _, req := range reqs {
+		reqHeaders := req.requestHeaders()
+		headers := make([]map[string]string, 0, len(reqHeaders))
+		for _, k := range sortedHeaderNames(reqHeaders) {
+			headers = append(headers, map[string]string{"key": k, "value": reqHeaders[k]})
+		}
+		body := map[string]any{"mode": "raw", "raw": string(req.Body)}
+		if strings.Contains(req.ContentType, "json") {
+			body["options"] = map[string]any{"raw": map[string]string{"language": "json"}}
+		}
+		items = append(items, map[string]any{
+			"name": req.Name,
+			"request": map[string]any{
+				"method": "POST",
+				"header": headers,
+				"body":   body,
+				"url":    map[string]string{"raw": req.URL},
+			},
+		})
+	}
+
+	name := "gosmee events"
+	if len(reqs) == 1 {
+		name = reqs[0].Name
+	}
+	collection := map[string]any{
+		"info": map[string]string{
+			"name":   name,
+			"schema": "https://schema.getpostman.com/json/collection/v2.1.0/collection.json",
+		},
+		"item": items,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(collection)
+}
+
+// renderHTTPFile writes requests in the .http format understood by the VS
+// Code REST Client and the JetBrains HTTP client.
+func renderHTTPFile(w io.Writer, reqs []exportRequest) error {
+	for i, req := range reqs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "### %s\n", headerLine(req.Name))
+		fmt.Fprintf(w, "POST %s\n", headerLine(req.URL))
+		headers := req.requestHeaders()
+		for _, k := range sortedHeaderNames(headers) {
+			fmt.Fprintf(w, "%s: %s\n", headerLine(k), headerLine(headers[k]))
+		}
+		fmt.Fprintln(w)
+		body := req.Body
+		if !utf8.Valid(body) {
+			// the format has no escaping for binary bodies
+			body = []byte(base64.StdEncoding.EncodeToString(body))
+		}
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// renderRawHTTP writes requests as they go on the wire with HTTP/1.1, several
+// requests follow each other like on a pipelined connection.
+func renderRawHTTP(w io.Writer, reqs []exportRequest) error {
+	for _, req := range reqs {
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			return fmt.Errorf("cannot parse target url of %s: %w", req.Name, err)
+		}
+		host := u.Host
+		headers := map[string]string{}
+		for k, v := range req.requestHeaders() {
+			switch {
+			case strings.EqualFold(k, "Host"):
+				host = v
+			case strings.EqualFold(k, "Content-Length"):
+			default:
+				headers[k] = v
+			}
+		}
+		fmt.Fprintf(w, "POST %s HTTP/1.1\r\n", u.RequestURI())
+		fmt.Fprintf(w, "Host: %s\r\n", headerLine(host))
+		for _, k := range sortedHeaderNames(headers) {
+			fmt.Fprintf(w, "%s: %s\r\n", headerLine(k), headerLine(headers[k]))
+		}
+		fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(req.Body))
+		if _, err := w.Write(req.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportSavedEvents writes events in the given format to out. Combined
+// formats write a single document, out being a file or "-" for w; script
+// formats write a payload and a script per event in the out directory.
+// Events saved by older versions have no target URL and get targetURL.
+func exportSavedEvents(format, out, targetURL string, w io.Writer, events []savedEvent) error {
+	f, err := lookupExportFormat(format)
+	if err != nil {
+		return err
+	}
+	reqs := make([]exportRequest, 0, len(events))
+	for _, ev := range events {
+		pm, err := ev.payload()
+		if err != nil {
+			return fmt.Errorf("cannot read saved event %s: %w", ev.Name, err)
+		}
+		evTargetURL := ev.Meta.TargetURL
+		if evTargetURL == "" {
+			if targetURL == "" {
+				return fmt.Errorf("saved event %s has no target URL, set one with --target-url", ev.Name)
+			}
+			evTargetURL = targetURL
+		}
+		req := newExportRequest(ev.Name, &replayDataOpts{targetURL: evTargetURL, localDebugURL: defaultLocalDebugURL}, pm, ev.Meta.Result)
+		req.SavedAt = ev.Meta.SavedAt
+		reqs = append(reqs, req)
+	}
+
+	if f.script {
+		if out == "" || out == "-" {
+			return fmt.Errorf("%s scripts are written next to their payload, set --out to a directory", format)
+		}
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			return err
+		}
+		for _, req := range reqs {
+			if err := os.WriteFile(filepath.Join(out, req.Name+".json"), req.Body, 0o644); err != nil { //nolint:gosec // read by the generated replay script
+				return err
+			}
+			if err := writeExportFile(filepath.Join(out, req.Name+f.ext), f, []exportRequest{req}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if out == "" || out == "-" {
+		return f.render(w, reqs)
+	}
+	return writeExportFile(out, f, reqs)
+}
@@ -0,0 +1,203 @@
+package gosmee
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func exportTestRequests() []exportRequest {
+	return []exportRequest{
+		{
+			Name:        "push-2024-01-01T10.00.01.000",
+			URL:         "http://localhost:8080/hook?source=gosmee",
+			Headers:     map[string]string{"X-Github-Event": "push", "X-Github-Delivery": "delivery-1"},
+			ContentType: "application/json",
+			Body:        []byte(`{"ref":"refs/heads/main"}`),
+			SavedAt:     time.Date(2024, 1, 1, 10, 0, 1, 0, time.UTC),
+			Result:      &deliveryResult{Status: 202, DurationMS: 12, At: time.Date(2024, 1, 1, 10, 0, 2, 0, time.UTC)},
+		},
+		{
+			Name:        "issues-2024-01-01T10.00.02.000",
+			URL:         "http://localhost:8080/hook",
+			Headers:     map[string]string{"X-Github-Event": "issues", "X-Evil": "a\r\nInjected: yes"},
+			ContentType: "application/json",
+			Body:        []byte(`{"action":"opened"}`),
+		},
+	}
+}
+
+func TestRenderHAR(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NilError(t, renderHAR(&buf, exportTestRequests()))
+
+	var har struct {
+		Log struct {
+			Version string `json:"version"`
+			Entries []struct {
+				Request struct {
+					Method      string         `json:"method"`
+					URL         string         `json:"url"`
+					Headers     []harNameValue `json:"headers"`
+					QueryString []harNameValue `json:"queryString"`
+					PostData    harPostData    `json:"postData"`
+				} `json:"request"`
+				Response struct {
+					Status int `json:"status"`
+				} `json:"response"`
+				Time int64 `json:"time"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &har))
+	assert.Equal(t, har.Log.Version, "1.2")
+	assert.Equal(t, len(har.Log.Entries), 2)
+	entry := har.Log.Entries[0]
+	assert.Equal(t, entry.Request.Method, "POST")
+	assert.DeepEqual(t, entry.Request.QueryString, []harNameValue{{Name: "source", Value: "gosmee"}})
+	assert.DeepEqual(t, entry.Request.Headers[0], harNameValue{Name: "Content-Type", Value: "application/json"})
+	assert.Equal(t, entry.Request.PostData.Text, `{"ref":"refs/heads/main"}`)
+	assert.Equal(t, entry.Response.Status, 202)
+	assert.Equal(t, entry.Time, int64(12))
+}
+
+func TestRenderPostman(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NilError(t, renderPostman(&buf, exportTestRequests()))
+
+	var collection struct {
+		Info struct {
+			Schema string `json:"schema"`
+		} `json:"info"`
+		Item []struct {
+			Name    string `json:"name"`
+			Request struct {
+				Method string              `json:"method"`
+				Header []map[string]string `json:"header"`
+				Body   struct {
+					Mode string `json:"mode"`
+					Raw  string `json:"raw"`
+				} `json:"body"`
+				URL struct {
+					Raw string `json:"raw"`
+				} `json:"url"`
+			} `json:"request"`
+		} `json:"item"`
+	}
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &collection))
+	assert.Assert(t, strings.Contains(collection.Info.Schema, "v2.1.0"))
+	assert.Equal(t, len(collection.Item), 2)
+	assert.Equal(t, collection.Item[0].Name, "push-2024-01-01T10.00.01.000")
+	assert.Equal(t, collection.Item[0].Request.Body.Mode, "raw")
+	assert.Equal(t, collection.Item[0].Request.Body.Raw, `{"ref":"refs/heads/main"}`)
+	assert.Equal(t, collection.Item[0].Request.URL.Raw, "http://localhost:8080/hook?source=gosmee")
+}
+
+func TestRenderHTTPFile(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NilError(t, renderHTTPFile(&buf, exportTestRequests()))
+	out := buf.String()
+	assert.Assert(t, strings.HasPrefix(out, "### push-2024-01-01T10.00.01.000\nPOST http://localhost:8080/hook?source=gosmee\nContent-Type: application/json\n"))
+	assert.Assert(t, strings.Contains(out, "\n### issues-2024-01-01T10.00.02.000\n"))
+	// header values cannot inject new lines
+	assert.Assert(t, strings.Contains(out, "X-Evil: a Injected: yes\n"))
+}
+
+func TestRenderRawHTTP(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NilError(t, renderRawHTTP(&buf, exportTestRequests()))
+
+	// the dump is a valid stream of pipelined HTTP/1.1 requests
+	r := bufio.NewReader(&buf)
+	req, err := http.ReadRequest(r)
+	assert.NilError(t, err)
+	assert.Equal(t, req.Method, http.MethodPost)
+	assert.Equal(t, req.RequestURI, "/hook?source=gosmee")
+	assert.Equal(t, req.Host, "localhost:8080")
+	assert.Equal(t, req.Header.Get("X-Github-Delivery"), "delivery-1")
+	body, err := io.ReadAll(req.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, string(body), `{"ref":"refs/heads/main"}`)
+
+	req, err = http.ReadRequest(r)
+	assert.NilError(t, err)
+	assert.Equal(t, req.Header.Get("X-Evil"), "a Injected: yes")
+	assert.Equal(t, req.Header.Get("Injected"), "")
+}
+
+func TestValidateExportFormats(t *testing.T) {
+	assert.NilError(t, validateExportFormats([]string{"curl", "har", "postman", "http", "raw"}))
+	assert.ErrorContains(t, validateExportFormats([]string{"curl", "httpie"}), "both write .sh files")
+	assert.ErrorContains(t, validateExportFormats([]string{"xml"}), "unknown export format")
+}
+
+func TestSaveDataExportFormats(t *testing.T) {
+	dir := t.TempDir()
+	ropts := &replayDataOpts{saveDir: dir, targetURL: "http://localhost:8080", exportFormats: []string{"har", "postman", "http", "raw"}}
+	pm := archiveTestPayload("delivery-1", `{"n":1}`)
+	assert.NilError(t, saveData(ropts, slog.New(slog.DiscardHandler), pm))
+
+	for _, ext := range []string{".json", ".meta.json", ".har", ".postman_collection.json", ".http", ".raw"} {
+		_, err := os.Stat(filepath.Join(dir, "push-2024-01-01T10.00.01.000"+ext))
+		assert.NilError(t, err, ext)
+	}
+	_, err := os.Stat(filepath.Join(dir, "push-2024-01-01T10.00.01.000.sh"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	// exported files are not mistaken for saved payloads
+	events, err := loadSavedEvents(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+}
+
+func TestEventsExport(t *testing.T) {
+	dir := t.TempDir()
+	saveTestEvents(t, dir)
+
+	var out bytes.Buffer
+	e := &eventsOpts{dir: dir, out: &out, logger: slog.New(slog.DiscardHandler)}
+	assert.NilError(t, e.export(exportFormatHTTP, "", "", nil))
+	assert.Equal(t, strings.Count(out.String(), "### "), 2)
+
+	out.Reset()
+	assert.NilError(t, e.export(exportFormatHTTP, "", "", []string{"delivery-2"}))
+	assert.Equal(t, strings.Count(out.String(), "### "), 1)
+
+	harFile := filepath.Join(t.TempDir(), "events.har")
+	assert.NilError(t, e.export(exportFormatHAR, harFile, "", nil))
+	data, err := os.ReadFile(harFile)
+	assert.NilError(t, err)
+	assert.Assert(t, json.Valid(data))
+
+	assert.ErrorContains(t, e.export(exportFormatCurl, "", "", nil), "set --out to a directory")
+	scripts := t.TempDir()
+	assert.NilError(t, e.export(exportFormatCurl, scripts, "", nil))
+	stat, err := os.Stat(filepath.Join(scripts, "push-2024-01-01T10.00.01.000.sh"))
+	assert.NilError(t, err)
+	assert.Equal(t, stat.Mode().Perm(), os.FileMode(0o755))
+	_, err = os.Stat(filepath.Join(scripts, "push-2024-01-01T10.00.01.000.json"))
+	assert.NilError(t, err)
+}
+
+func TestEventsExportLegacyTargetURL(t *testing.T) {
+	dir := t.TempDir()
+	// saved by an older gosmee, without a metadata file
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "push-2024-01-01T10.00.01.000.json"), []byte(`{}`), 0o600))
+
+	var out bytes.Buffer
+	e := &eventsOpts{dir: dir, out: &out, logger: slog.New(slog.DiscardHandler)}
+	assert.ErrorContains(t, e.export(exportFormatHTTP, "", "", nil), "push-2024-01-01T10.00.01.000 has no target URL, set one with --target-url")
+
+	assert.NilError(t, e.export(exportFormatHTTP, "", "http://localhost:8080/hook", nil))
+	assert.Assert(t, strings.Contains(out.String(), "POST http://localhost:8080/hook"), out.String())
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package gosmee
+
+import "os"
+
+// lockFile does not lock on this platform, processes sharing a state file
+// may lose each other's updates.
+func lockFile(*os.File) error { return nil }
+
+func unlockFile(*os.File) error { return nil }
@@ -15,7 +15,7 @@ var commonFlags = []cli.Flag{
 	configFlag,
 	&cli.StringFlag{
 		Name:    "output",
-		Usage:   `Output format, one of "json", "pretty"`,
+		Usage:   `Output format, one of "json", "pretty". The replay listings also accept "yaml" and "table"`,
 		Value:   "pretty",
 		Aliases: []string{"o"},
 	},
@@ -36,6 +36,21 @@ var commonFlags = []cli.Flag{
 		Aliases: []string{"s"},
 		EnvVars: []string{"GOSMEE_SAVEDIR"},
 	},
+	&cli.StringSliceFlag{
+		Name:  "export-format",
+		Usage: "Formats to write next to each payload in --saveDir, any of curl, httpie, har, postman, http or raw. Defaults to a curl replay script. Can be specified multiple times",
+	},
+	&cli.StringFlag{
+		Name:    "save-format",
+		Usage:   `How --saveDir stores events, one of "files" (a payload, metadata and replay script per event), "jsonl" or "jsonl.gz" (an append-only archive)`,
+		Value:   saveFormatFiles,
+		EnvVars: []string{"GOSMEE_SAVE_FORMAT"},
+	},
+	&cli.Int64Flag{
+		Name:  "save-archive-max-size",
+		Usage: "Rotate the --save-format jsonl archive once it grows over this size in `MB`, 0 to never rotate",
+		Value: 100,
+	},
 	&cli.IntFlag{
 		Name:    "target-connection-timeout",
 		Usage:   "How long to wait when forwarding the request to the service",
@@ -74,6 +89,35 @@ var commonFlags = []cli.Flag{
 		Aliases: []string{"E"},
 		Usage:   "Only run --exec on these event types (e.g., push, pull_request). If not set, --exec runs on all events",
 	},
+	&cli.StringSliceFlag{
+		Name:  "set-header",
+		Usage: "Set a header on forwarded requests as `NAME=VALUE`. VALUE is a Go template, ie: 'Authorization=Bearer {{ env \"TOKEN\" }}'. Can be specified multiple times",
+	},
+	&cli.StringSliceFlag{
+		Name:  "remove-header",
+		Usage: "Remove a header from forwarded requests. Can be specified multiple times",
+	},
+	&cli.StringSliceFlag{
+		Name:  "rename-header",
+		Usage: "Rename a header on forwarded requests as `OLD=NEW`. Can be specified multiple times",
+	},
+	&cli.StringFlag{
+		Name:  "body-template",
+		Usage: "Go template `FILE` used to rewrite the body of forwarded requests",
+	},
+	&cli.StringFlag{
+		Name:  "target-url-template",
+		Usage: "Go template used to compute the target URL from event fields, ie: 'http://localhost:8080/{{ .EventType }}'",
+	},
+	&cli.StringFlag{
+		Name:    "resign-secret",
+		Usage:   "Re-compute provider signatures (GitHub, Gitea, Forgejo, GitLab, Bitbucket) of forwarded requests with this local development secret",
+		EnvVars: []string{"GOSMEE_RESIGN_SECRET"},
+	},
+	&cli.BoolFlag{
+		Name:  "save-original",
+		Usage: "Save the payload as received in --saveDir instead of the transformed request",
+	},
 	&cli.StringSliceFlag{
 		Name:    "exec-env-vars",
 		Usage:   "Additional environment variable names to pass through to --exec commands. Can be specified multiple times",
@@ -87,6 +131,45 @@ var replayFlags = []cli.Flag{
 		Usage:   "GitHub token to use to replay payloads",
 		Aliases: []string{"t"},
 	},
+	&cli.Int64Flag{
+		Name:    "github-app-id",
+		Usage:   "Authenticate as the GitHub App with this ID instead of with a token",
+		EnvVars: []string{"GOSMEE_GITHUB_APP_ID"},
+	},
+	&cli.StringFlag{
+		Name:    "github-app-private-key",
+		Usage:   "`FILE` with the PEM private key of the GitHub App",
+		EnvVars: []string{"GOSMEE_GITHUB_APP_PRIVATE_KEY"},
+	},
+	&cli.Int64Flag{
+		Name:  "github-installation-id",
+		Usage: "Installation ID of the GitHub App, looked up from the org or org/repo when not set",
+	},
+	&cli.BoolFlag{
+		Name:  "github-app-hook",
+		Usage: "Replay the deliveries of the GitHub App webhook, the only argument is then the target URL",
+	},
+	&cli.StringFlag{
+		Name:    "github-base-url",
+		Usage:   "API URL of a GitHub Enterprise Server, ie: https://github.example.com/api/v3/",
+		EnvVars: []string{"GOSMEE_GITHUB_BASE_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "provider",
+		Usage:   "Forge to read the webhook deliveries from, one of github, gitlab or gitea (for Gitea and Forgejo)",
+		Value:   providerGitHub,
+		EnvVars: []string{"GOSMEE_REPLAY_PROVIDER"},
+	},
+	&cli.StringFlag{
+		Name:    "provider-url",
+		Usage:   "URL of the GitLab, Gitea or Forgejo instance, GitLab defaults to https://gitlab.com",
+		EnvVars: []string{"GOSMEE_PROVIDER_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "provider-token",
+		Usage:   "GitLab or Gitea access token to read the webhook deliveries",
+		EnvVars: []string{"GOSMEE_PROVIDER_TOKEN"},
+	},
 	&cli.BoolFlag{
 		Name:    "list-hooks",
 		Usage:   "List hooks and its IDs on a repository",
@@ -97,11 +180,120 @@ var replayFlags = []cli.Flag{
 		Usage:   "List deliveries from on hook ID",
 		Aliases: []string{"D"},
 	},
+	&cli.StringFlag{
+		Name:  "show",
+		Usage: "Show the request and response headers and bodies of the delivery with this ID or GUID",
+	},
 	&cli.StringFlag{
 		Name:    "time-since",
 		Aliases: []string{"T"},
 		Usage:   "Replay events from this time",
 	},
+	&cli.StringFlag{
+		Name:  "until",
+		Usage: "Replay events up to this time (format: 2006-01-02T15:04:05) and exit",
+	},
+	&cli.BoolFlag{
+		Name:  "once",
+		Usage: "Replay the deliveries received so far and exit instead of waiting for new ones",
+	},
+	&cli.StringSliceFlag{
+		Name:  "status",
+		Usage: "Only replay deliveries GitHub got this status code for: a code like 502, a class like 5xx, success or failed (non-2xx). Can be specified multiple times",
+	},
+	&cli.StringSliceFlag{
+		Name:    "event-type",
+		Aliases: []string{"e"},
+		Usage:   "Only replay deliveries of this event type. Can be specified multiple times",
+	},
+	&cli.StringSliceFlag{
+		Name:  "action",
+		Usage: "Only replay deliveries with this event action, ie: opened. Can be specified multiple times",
+	},
+	&cli.StringSliceFlag{
+		Name:  "delivery-id",
+		Usage: "Only replay the delivery with this GUID or ID. Can be specified multiple times",
+	},
+	&cli.StringFlag{
+		Name:    "state-file",
+		Usage:   "`FILE` to persist the last replayed delivery of each hook, to resume from it after a restart",
+		EnvVars: []string{"GOSMEE_REPLAY_STATE_FILE"},
+	},
+	&cli.BoolFlag{
+		Name:  "redeliver",
+		Usage: "Ask GitHub to redeliver the selected deliveries to the hook URL instead of forwarding them to a target URL",
+	},
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "With --redeliver, only list the deliveries that would be redelivered",
+	},
+	&cli.IntFlag{
+		Name:  "redeliver-concurrency",
+		Usage: "With --redeliver, how many redelivery requests to send to GitHub at once",
+		Value: defaultRedeliverConcurrency,
+	},
+}
+
+// selectFlags returns the flags of base with the given names.
+func selectFlags(base []cli.Flag, names ...string) []cli.Flag {
+	res := make([]cli.Flag, 0, len(names))
+	for _, f := range base {
+		for _, name := range names {
+			if f.Names()[0] == name {
+				res = append(res, f)
+			}
+		}
+	}
+	return res
+}
+
+var eventsFilterFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:    "event-type",
+		Aliases: []string{"e"},
+		Usage:   "Only select saved events of this type. Can be specified multiple times",
+	},
+	&cli.StringSliceFlag{
+		Name:  "delivery-id",
+		Usage: "Only select saved events with this delivery ID. Can be specified multiple times",
+	},
+	&cli.StringFlag{
+		Name:  "since",
+		Usage: "Only select events received after this time, or saved for events without a receive time (format: 2006-01-02T15:04:05)",
+	},
+	&cli.StringFlag{
+		Name:  "until",
+		Usage: "Only select events received before this time, or saved for events without a receive time (format: 2006-01-02T15:04:05)",
+	},
+}
+
+var eventsFlags = mergeFlags(selectFlags(commonFlags, "config", "output", "log-level", "nocolor", "saveDir"), eventsFilterFlags...)
+
+var eventsReplayFlags = mergeFlags(
+	selectFlags(commonFlags, "target-connection-timeout", "target-retries", "insecure-skip-tls-verify",
+		"set-header", "remove-header", "rename-header", "body-template", "target-url-template", "resign-secret"),
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "Replay all saved events matching the filters",
+	},
+)
+
+var eventsExportFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "format",
+		Aliases: []string{"f"},
+		Usage:   "Export format, one of curl, httpie, har, postman, http or raw",
+		Value:   exportFormatHAR,
+	},
+	&cli.StringFlag{
+		Name:    "out",
+		Aliases: []string{"O"},
+		Usage:   "`FILE` to write the export to, or directory for curl and httpie scripts. Defaults to stdout",
+	},
+	&cli.StringFlag{
+		Name:  "target-url",
+		Usage: "`URL` to export the events saved by older versions, without their target URL, with",
+	},
 }
 
 var keygenFlags = []cli.Flag{
@@ -111,6 +303,51 @@ var keygenFlags = []cli.Flag{
 		Usage:   "Path to write the client keypair JSON file",
 		EnvVars: []string{"GOSMEE_ENCRYPTION_KEY_FILE"},
 	},
+	&cli.BoolFlag{
+		Name:  "rotate",
+		Usage: "Keep the keypair of --key-file and write a new one to --next-key-file, printing the new public key and the fingerprint of the old one",
+	},
+	&cli.StringFlag{
+		Name:  "next-key-file",
+		Usage: "Path to write the new keypair with --rotate, defaults to the --key-file path with a .next suffix",
+	},
+	&cli.BoolFlag{
+		Name:  "signing",
+		Usage: "Generate a server signing key for --signing-key-file instead of a client keypair, and print its public key for clients to pin",
+	},
+	&cli.BoolFlag{
+		Name:  "encrypt",
+		Usage: "Encrypt the private key with a passphrase, read from --key-passphrase-file, $GOSMEE_KEY_PASSPHRASE or a prompt (the --new-key-passphrase-file ones with --rotate)",
+	},
+	&cli.StringFlag{
+		Name:  "keyring",
+		Usage: "Keep the private key in an OS keyring instead of the key file: secret-service",
+	},
+	keyPassphraseFileFlag,
+	newKeyPassphraseFileFlag,
+}
+
+var keyPassphraseFileFlag = &cli.StringFlag{
+	Name:    "key-passphrase-file",
+	Usage:   "File holding the passphrase of an encrypted key file, $GOSMEE_KEY_PASSPHRASE or a prompt are used otherwise",
+	EnvVars: []string{"GOSMEE_KEY_PASSPHRASE_FILE"},
+}
+
+var newKeyPassphraseFileFlag = &cli.StringFlag{
+	Name:    "new-key-passphrase-file",
+	Usage:   "File holding the new key file passphrase, $GOSMEE_NEW_KEY_PASSPHRASE or a prompt are used otherwise",
+	EnvVars: []string{"GOSMEE_NEW_KEY_PASSPHRASE_FILE"},
+}
+
+var keyPassphraseFlags = []cli.Flag{
+	configFlag,
+	&cli.StringFlag{
+		Name:    "key-file",
+		Usage:   "Path of the client keypair JSON file",
+		EnvVars: []string{"GOSMEE_ENCRYPTION_KEY_FILE"},
+	},
+	keyPassphraseFileFlag,
+	newKeyPassphraseFileFlag,
 }
 
 var clientFlags = []cli.Flag{
@@ -148,16 +385,53 @@ var clientFlags = []cli.Flag{
 		Value:   1048576, // 1MB
 		EnvVars: []string{"GOSMEE_SSE_BUFFER_SIZE"},
 	},
+	&cli.StringFlag{
+		Name:    "sse-compression",
+		Usage:   "Payload compressions to ask the server for, preferred first (zstd, gzip), or none",
+		Value:   "zstd,gzip",
+		EnvVars: []string{"GOSMEE_SSE_COMPRESSION"},
+	},
+	&cli.StringFlag{
+		Name:    "transport",
+		Usage:   "Transport to receive events with: sse, websocket falling back to sse when the server or a proxy does not allow it, or poll for long polling",
+		Value:   transportSSE,
+		EnvVars: []string{"GOSMEE_TRANSPORT"},
+	},
 	&cli.StringFlag{
 		Name:    "encryption-key-file",
 		Usage:   "Path to the client encryption keypair JSON file",
 		EnvVars: []string{"GOSMEE_ENCRYPTION_KEY_FILE"},
 	},
+	&cli.StringFlag{
+		Name:    "next-encryption-key-file",
+		Usage:   "Path to the next client encryption keypair JSON file, used when the server refuses the current one during a key rotation",
+		EnvVars: []string{"GOSMEE_NEXT_ENCRYPTION_KEY_FILE"},
+	},
+	keyPassphraseFileFlag,
+	&cli.StringFlag{
+		Name:    "server-signing-key",
+		Usage:   "Public signing key of the gosmee server, protected channel events not signed with it are rejected",
+		EnvVars: []string{"GOSMEE_SERVER_SIGNING_KEY"},
+	},
 	&cli.StringFlag{
 		Name:    "resume-state-file",
 		Usage:   "Path to persist the last successfully processed Redis stream ID for durable resume",
 		EnvVars: []string{"GOSMEE_RESUME_STATE_FILE"},
 	},
+	&cli.StringSliceFlag{
+		Name:  "server-header",
+		Usage: "Set a header on the requests to the gosmee server as `NAME=VALUE`, for servers behind an authenticating proxy. VALUE is a Go template, ie: 'CF-Access-Client-Secret={{ file \"/run/secrets/cf\" }}' or '{{ env \"TOKEN\" }}'. Can be specified multiple times",
+	},
+	&cli.StringFlag{
+		Name:    "server-token",
+		Usage:   "Bearer token sent in the Authorization header of the requests to the gosmee server",
+		EnvVars: []string{"GOSMEE_SERVER_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "server-token-file",
+		Usage:   "File holding the bearer token for the gosmee server, read again on every connection so it can be rotated",
+		EnvVars: []string{"GOSMEE_SERVER_TOKEN_FILE"},
+	},
 }
 
 var serverFlags = []cli.Flag{
@@ -230,15 +504,32 @@ var serverFlags = []cli.Flag{
 	},
 	&cli.StringFlag{
 		Name:    "encrypted-channels-file",
-		Usage:   "Optional JSON file describing protected channel IDs and allowed client public keys",
+		Usage:   "Optional JSON file describing protected channel IDs and allowed client public keys, reloaded on SIGHUP or when it changes",
 		EnvVars: []string{"GOSMEE_ENCRYPTED_CHANNELS_FILE"},
 	},
+	&cli.StringFlag{
+		Name:    "signing-key-file",
+		Usage:   "Ed25519 key file, made with gosmee keygen --signing, to sign the events of protected channels so clients can verify them",
+		EnvVars: []string{"GOSMEE_SIGNING_KEY_FILE"},
+	},
 	&cli.StringFlag{
 		Name:    "cors-origin",
 		Usage:   "CORS origin for SSE endpoint. Set a specific origin (e.g. https://example.com) to restrict which websites can connect to the SSE stream; set empty string to omit Access-Control-Allow-Origin entirely (same-origin only)",
 		Value:   "*",
 		EnvVars: []string{"GOSMEE_CORS_ORIGIN"},
 	},
+	&cli.IntFlag{
+		Name:    "history-size",
+		Usage:   "Events kept in memory per channel for the web page to show on open, without --redis-url. Set 0 to keep none",
+		Value:   defaultHistorySize,
+		EnvVars: []string{"GOSMEE_HISTORY_SIZE"},
+	},
+	&cli.Int64Flag{
+		Name:    "history-max-memory",
+		Usage:   "Memory for the payloads of the --history-size events of all the channels in `MB`, the least recently used channels lose their oldest events first",
+		Value:   defaultHistoryMaxMemory,
+		EnvVars: []string{"GOSMEE_HISTORY_MAX_MEMORY"},
+	},
 	&cli.StringFlag{
 		Name:    "redis-url",
 		Usage:   "Redis URL for stream-backed durable cross-replica delivery. When unset, gosmee uses in-memory single-replica delivery only",
@@ -250,4 +541,25 @@ var serverFlags = []cli.Flag{
 		Value:   defaultRedisStreamMaxLen,
 		EnvVars: []string{"GOSMEE_REDIS_STREAM_MAXLEN"},
 	},
+	&cli.IntFlag{
+		Name:    "compress-min-size",
+		Usage:   "Compress payloads of at least this many bytes for SSE clients asking for it, and in Redis streams with --redis-compress. Set 0 to disable compression",
+		Value:   defaultCompressMinSize,
+		EnvVars: []string{"GOSMEE_COMPRESS_MIN_SIZE"},
+	},
+	&cli.BoolFlag{
+		Name:    "redis-compress",
+		Usage:   "Store Redis stream entries of at least --compress-min-size bytes zstd-compressed. Only enable it once every replica reads compressed entries",
+		EnvVars: []string{"GOSMEE_REDIS_COMPRESS"},
+	},
+	&cli.StringFlag{
+		Name:    "redis-data-key-file",
+		Usage:   "File with a base64 encoded 32 bytes key (e.g. openssl rand -base64 32) to encrypt the Redis stream entries of protected channels at rest",
+		EnvVars: []string{"GOSMEE_REDIS_DATA_KEY_FILE"},
+	},
+	&cli.BoolFlag{
+		Name:    "redis-channel-data-keys",
+		Usage:   "Encrypt each protected channel with its own data key, stored in Redis wrapped with --redis-data-key-file",
+		EnvVars: []string{"GOSMEE_REDIS_CHANNEL_DATA_KEYS"},
+	},
 }
@@ -0,0 +1,173 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v57/github"
+)
+
+const (
+	providerGitHub = "github"
+	providerGitLab = "gitlab"
+	providerGitea  = "gitea"
+
+	defaultGitLabURL = "https://gitlab.com"
+)
+
+var replayProviders = []string{providerGitHub, providerGitLab, providerGitea}
+
+// deliveryEventHeaders are the headers carrying the event type, by forge.
+var deliveryEventHeaders = []string{"X-GitHub-Event", "X-Gitlab-Event", "X-Gitea-Event", "X-Forgejo-Event", "X-Event-Key"}
+
+// deliveryEventType returns the event type of a delivery from its headers.
+func deliveryEventType(headers map[string]string) string {
+	for _, name := range deliveryEventHeaders {
+		for k, v := range headers {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// forgeClient does the JSON API calls of the GitLab and Gitea backends.
+type forgeClient struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	authHeader string
+	token      string
+}
+
+func newForgeClient(baseURL, apiPath, authHeader, token string) (*forgeClient, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + apiPath)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid --provider-url %q", baseURL)
+	}
+	return &forgeClient{baseURL: u, httpClient: http.DefaultClient, authHeader: authHeader, token: token}, nil
+}
+
+// do calls the API at path, relative to the API root, and decodes the JSON
+// answer in out. Errors come with the response so callGitHub can retry
+// server errors.
+func (f *forgeClient) do(ctx context.Context, method, path string, query url.Values, out any) (*github.Response, error) {
+	u := f.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if f.token != "" {
+		req.Header.Set(f.authHeader, f.token)
+	}
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	ghResp := &github.Response{Response: resp}
+	if err != nil {
+		return ghResp, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ghResp, fmt.Errorf("%s %s: %s: %s", method, u.Redacted(), resp.Status, bytes.TrimSpace(body))
+	}
+	if out != nil && len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, out); err != nil {
+			return ghResp, fmt.Errorf("cannot decode %s: %w", u.Redacted(), err)
+		}
+	}
+	return ghResp, nil
+}
+
+// pageQuery returns the query of the page given by a cursor, the forge
+// backends use page numbers as cursor.
+func pageQuery(opt *github.ListCursorOptions) (url.Values, int) {
+	page := 1
+	perPage := deliveryPageSize
+	if opt != nil {
+		if p, err := strconv.Atoi(opt.Cursor); err == nil && p > 0 {
+			page = p
+		}
+		if opt.PerPage > 0 {
+			perPage = opt.PerPage
+		}
+	}
+	return url.Values{"page": {strconv.Itoa(page)}, "per_page": {strconv.Itoa(perPage)}}, page
+}
+
+// nextPage sets the cursor of the next page, from the X-Next-Page header
+// of GitLab, or when the page was full.
+func nextPage(resp *github.Response, page, count int, opt *github.ListCursorOptions) {
+	if _, ok := resp.Header["X-Next-Page"]; ok {
+		resp.Cursor = resp.Header.Get("X-Next-Page")
+		return
+	}
+	perPage := deliveryPageSize
+	if opt != nil && opt.PerPage > 0 {
+		perPage = opt.PerPage
+	}
+	if count >= perPage {
+		resp.Cursor = strconv.Itoa(page + 1)
+	}
+}
+
+// forgeHook is a webhook as listed by GitLab and Gitea.
+type forgeHook struct {
+	ID     int64             `json:"id"`
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	URL    string            `json:"url"`
+	Config map[string]string `json:"config"`
+}
+
+func (h forgeHook) hook() *github.Hook {
+	name := h.Name
+	if name == "" {
+		name = h.Type
+	}
+	if name == "" {
+		name = "web"
+	}
+	hookURL := h.URL
+	if hookURL == "" {
+		hookURL = h.Config["url"]
+	}
+	return &github.Hook{ID: github.Int64(h.ID), Name: github.String(name), Config: map[string]any{"url": hookURL}}
+}
+
+func forgeHooks(hooks []forgeHook) []*github.Hook {
+	ret := make([]*github.Hook, 0, len(hooks))
+	for _, h := range hooks {
+		ret = append(ret, h.hook())
+	}
+	return ret
+}
+
+// payloadAction returns the action of a payload, GitLab has it in
+// object_attributes.
+func payloadAction(payload json.RawMessage) string {
+	var p struct {
+		Action           string `json:"action"`
+		ObjectAttributes struct {
+			Action string `json:"action"`
+		} `json:"object_attributes"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	if p.Action != "" {
+		return p.Action
+	}
+	return p.ObjectAttributes.Action
+}
@@ -0,0 +1,213 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// fakeGitLab serves the hooks and webhook events of the group/sub/project
+// project, pageSize events per page, newest first.
+func fakeGitLab(t *testing.T, events []gitlabHookEvent, pageSize int) (*httptest.Server, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	resent := []string{}
+	mux := http.NewServeMux()
+	auth := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("PRIVATE-TOKEN") != "glpat-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("GET /api/v4/projects/group%2Fsub%2Fproject/hooks", func(w http.ResponseWriter, r *http.Request) {
+		if auth(w, r) {
+			fmt.Fprint(w, `[{"id": 3, "url": "https://example.com/gitlab", "name": "ci"}]`)
+		}
+	})
+	mux.HandleFunc("GET /api/v4/projects/group%2Fsub%2Fproject/hooks/3/events", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start := min((page-1)*pageSize, len(events))
+		end := min(start+pageSize, len(events))
+		next := ""
+		if end < len(events) {
+			next = strconv.Itoa(page + 1)
+		}
+		w.Header().Set("X-Next-Page", next)
+		assert.NilError(t, json.NewEncoder(w).Encode(events[start:end]))
+	})
+	mux.HandleFunc("POST /api/v4/projects/group%2Fsub%2Fproject/hooks/3/events/{id}/resend", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		mu.Lock()
+		resent = append(resent, r.PathValue("id"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"response_status": 201}`)
+	})
+	return httptest.NewServer(mux), &resent
+}
+
+func gitlabTestEvents(now time.Time) []gitlabHookEvent {
+	events := []gitlabHookEvent{}
+	for i, status := range []string{"500", "200", "internal error"} {
+		events = append(events, gitlabHookEvent{
+			ID:      int64(10 - i),
+			Trigger: "merge_request_hooks",
+			RequestHeaders: map[string]string{
+				"Content-Type":        "application/json",
+				"X-Gitlab-Event":      "Merge Request Hook",
+				"X-Gitlab-Event-UUID": fmt.Sprintf("uuid-%d", i),
+			},
+			RequestData:    json.RawMessage(fmt.Sprintf(`{"object_kind": "merge_request", "object_attributes": {"action": "open", "iid": %d}}`, i)),
+			ResponseStatus: status,
+			CreatedAt:      now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+	return events
+}
+
+func TestGitLabHistory(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	server, resent := fakeGitLab(t, gitlabTestEvents(now), 2)
+	defer server.Close()
+	client, err := newForgeClient(server.URL, "/api/v4", "PRIVATE-TOKEN", "glpat-token")
+	assert.NilError(t, err)
+	history := NewGitLabLister(client, slog.New(slog.DiscardHandler), "group/sub", "project")
+
+	hooks, _, err := history.ListHooks(context.Background(), "", "", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, hooks[0].GetID(), int64(3))
+	assert.Equal(t, hooks[0].Config["url"], "https://example.com/gitlab")
+
+	r := &replayOpts{history: history, logger: slog.New(slog.DiscardHandler), sinceTime: now.Add(-time.Hour)}
+	deliveries, err := r.listHookDeliveriesSince(context.Background(), 3, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(deliveries), 3)
+	assert.Equal(t, deliveries[0].GetGUID(), "uuid-0")
+	assert.Equal(t, deliveries[0].GetStatusCode(), 500)
+	assert.Equal(t, deliveries[0].GetEvent(), "Merge Request Hook")
+	assert.Equal(t, deliveries[0].GetAction(), "open")
+	// a delivery that failed without an answer has no status code
+	assert.Equal(t, deliveries[2].GetStatusCode(), 0)
+
+	r.filter = deliveryFilter{statuses: []string{"failed"}}
+	assert.NilError(t, r.redeliverHooks(context.Background(), 3, redeliverOpts{concurrency: 1}))
+	// oldest first
+	assert.DeepEqual(t, *resent, []string{"8", "10"})
+}
+
+func TestGitLabHistoryShow(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	server, _ := fakeGitLab(t, gitlabTestEvents(now), 2)
+	defer server.Close()
+	client, err := newForgeClient(server.URL, "/api/v4", "PRIVATE-TOKEN", "glpat-token")
+	assert.NilError(t, err)
+	var out bytes.Buffer
+	r := &replayOpts{
+		history: NewGitLabLister(client, slog.New(slog.DiscardHandler), "group/sub", "project"),
+		logger:  slog.New(slog.DiscardHandler),
+		output:  "json",
+		out:     &out,
+	}
+	// on the second page, without listing the events first
+	assert.NilError(t, r.showDelivery(context.Background(), 3, "8"))
+	assert.Assert(t, strings.Contains(out.String(), `"guid":"uuid-2"`), out.String())
+}
+
+func TestGitLabHistoryReplay(t *testing.T) {
+	var received []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Gitlab-Event")+" "+r.Header.Get("X-Gitlab-Event-UUID"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	now := time.Now().Truncate(time.Second)
+	server, _ := fakeGitLab(t, gitlabTestEvents(now), 100)
+	defer server.Close()
+	client, err := newForgeClient(server.URL, "/api/v4", "PRIVATE-TOKEN", "glpat-token")
+	assert.NilError(t, err)
+	r := &replayOpts{
+		history:   NewGitLabLister(client, slog.New(slog.DiscardHandler), "group/sub", "project"),
+		logger:    slog.New(slog.DiscardHandler),
+		sinceTime: now.Add(-time.Hour),
+		once:      true,
+		replayDataOpts: &replayDataOpts{
+			targetURL:        target.URL,
+			targetCnxTimeout: 1,
+		},
+	}
+	assert.NilError(t, r.replayHooks(context.Background(), 3))
+	assert.DeepEqual(t, received, []string{"Merge Request Hook uuid-2", "Merge Request Hook uuid-1", "Merge Request Hook uuid-0"})
+}
+
+func TestGiteaHistory(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	task := func(id int64, delivered bool, status int) giteaHookTask {
+		t := giteaHookTask{ID: id, UUID: fmt.Sprintf("uuid-%d", id), EventType: "push", IsDelivered: delivered, Delivered: now.Add(-time.Duration(10-id) * time.Minute)}
+		t.RequestInfo.Headers = map[string]string{"X-Gitea-Event": "push", "X-Gitea-Delivery": t.UUID}
+		t.RequestInfo.Body = `{"ref": "refs/heads/main"}`
+		t.ResponseInfo.Status = status
+		return t
+	}
+	tasks := []giteaHookTask{task(9, false, 0), task(8, true, 502), task(7, true, 200)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/hooks/5/tasks", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("Authorization"), "token gitea-token")
+		assert.Equal(t, r.URL.Query().Get("limit"), "100")
+		assert.NilError(t, json.NewEncoder(w).Encode(tasks))
+	})
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/hooks/5/tasks/8", func(w http.ResponseWriter, _ *http.Request) {
+		assert.NilError(t, json.NewEncoder(w).Encode(tasks[1]))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := newForgeClient(server.URL, "/api/v1", "Authorization", "token gitea-token")
+	assert.NilError(t, err)
+	r := &replayOpts{history: NewGiteaLister(client, slog.New(slog.DiscardHandler), "owner", "repo"), logger: slog.New(slog.DiscardHandler)}
+	deliveries, err := r.listHookDeliveriesSince(context.Background(), 5, time.Time{})
+	assert.NilError(t, err)
+	// the queued task is not listed
+	assert.Equal(t, len(deliveries), 2)
+	assert.Equal(t, deliveries[0].GetStatusCode(), 502)
+
+	delivery, err := r.getHookDelivery(context.Background(), 5, 8)
+	assert.NilError(t, err)
+	assert.Equal(t, deliveryEventType(delivery.Request.Headers), "push")
+	assert.Equal(t, string(delivery.Request.GetRawPayload()), `{"ref": "refs/heads/main"}`)
+
+	err = r.redeliverHooks(context.Background(), 5, redeliverOpts{concurrency: 1})
+	assert.ErrorContains(t, err, "2 deliveries failed to be redelivered")
+}
+
+func TestGiteaRedeliverRejected(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := makeapp().Run([]string{"gosmee", "replay", "--provider", providerGitea, "--provider-url", server.URL,
+		"--redeliver", "--status", "failed", "owner/repo", "5"})
+	assert.ErrorIs(t, err, errGiteaRedeliver)
+	// rejected before listing the deliveries
+	assert.Equal(t, calls, 0)
+}
@@ -0,0 +1,121 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// giteaHookTask is a webhook delivery as Gitea and Forgejo record it.
+type giteaHookTask struct {
+	ID          int64     `json:"id"`
+	UUID        string    `json:"uuid"`
+	EventType   string    `json:"event_type"`
+	IsDelivered bool      `json:"is_delivered"`
+	IsSucceed   bool      `json:"is_succeed"`
+	Delivered   time.Time `json:"delivered"`
+	RequestInfo struct {
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body"`
+	} `json:"request_info"`
+	ResponseInfo struct {
+		Status int `json:"status"`
+	} `json:"response_info"`
+}
+
+func (t giteaHookTask) delivery() *github.HookDelivery {
+	payload := json.RawMessage(t.RequestInfo.Body)
+	if !json.Valid(payload) {
+		// form encoded hooks are replayed as a JSON string
+		payload, _ = json.Marshal(t.RequestInfo.Body)
+	}
+	event := deliveryEventType(t.RequestInfo.Headers)
+	if event == "" {
+		event = t.EventType
+	}
+	return &github.HookDelivery{
+		ID:          github.Int64(t.ID),
+		GUID:        github.String(t.UUID),
+		DeliveredAt: &github.Timestamp{Time: t.Delivered},
+		StatusCode:  github.Int(t.ResponseInfo.Status),
+		Event:       github.String(event),
+		Action:      github.String(payloadAction(payload)),
+		Request:     &github.HookRequest{Headers: t.RequestInfo.Headers, RawPayload: &payload},
+	}
+}
+
+// GiteaOP reads the hook tasks Gitea or Forgejo keep for a repository or
+// organization webhook.
+type GiteaOP struct {
+	client *forgeClient
+	logger *slog.Logger
+	// hooksPath is repos/:owner/:repo/hooks or orgs/:org/hooks
+	hooksPath string
+}
+
+var _ DeliveryHistory = (*GiteaOP)(nil)
+
+func NewGiteaLister(client *forgeClient, logger *slog.Logger, org, repo string) *GiteaOP {
+	hooksPath := "orgs/" + url.PathEscape(org) + "/hooks"
+	if repo != "" {
+		hooksPath = "repos/" + url.PathEscape(org) + "/" + url.PathEscape(repo) + "/hooks"
+	}
+	return &GiteaOP{client: client, logger: logger, hooksPath: hooksPath}
+}
+
+func (g *GiteaOP) Starting() {
+	g.logger.InfoContext(context.Background(), "watching Gitea hook tasks on", "hooks", g.hooksPath)
+}
+
+func (g *GiteaOP) ListHooks(ctx context.Context, _, _ string, _ *github.ListOptions) ([]*github.Hook, *github.Response, error) {
+	var hooks []forgeHook
+	resp, err := g.client.do(ctx, http.MethodGet, g.hooksPath, url.Values{"limit": {"50"}}, &hooks)
+	if err != nil {
+		return nil, resp, err
+	}
+	return forgeHooks(hooks), resp, nil
+}
+
+func (g *GiteaOP) ListHookDeliveries(ctx context.Context, _, _ string, hookID int64, opt *github.ListCursorOptions) ([]*github.HookDelivery, *github.Response, error) {
+	query, page := pageQuery(opt)
+	// Gitea names the page size limit
+	query.Set("limit", query.Get("per_page"))
+	query.Del("per_page")
+	var tasks []giteaHookTask
+	resp, err := g.client.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d/tasks", g.hooksPath, hookID), query, &tasks)
+	if err != nil {
+		return nil, resp, err
+	}
+	deliveries := make([]*github.HookDelivery, 0, len(tasks))
+	for _, t := range tasks {
+		// tasks still queued have nothing to replay yet
+		if !t.IsDelivered {
+			continue
+		}
+		deliveries = append(deliveries, t.delivery())
+	}
+	nextPage(resp, page, len(tasks), opt)
+	return deliveries, resp, nil
+}
+
+func (g *GiteaOP) GetHookDelivery(ctx context.Context, _, _ string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	var task giteaHookTask
+	resp, err := g.client.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d/tasks/%d", g.hooksPath, hookID, deliveryID), nil, &task)
+	if err != nil {
+		return nil, resp, err
+	}
+	return task.delivery(), resp, nil
+}
+
+var errGiteaRedeliver = errors.New("redelivering is not supported by the Gitea and Forgejo API, use the replay button of the webhook settings")
+
+func (g *GiteaOP) RedeliverHookDelivery(context.Context, string, string, int64, int64) (*github.HookDelivery, *github.Response, error) {
+	return nil, nil, errGiteaRedeliver
+}
@@ -0,0 +1,220 @@
+package gosmee
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// GitHub refuses App JWTs valid for more than ten minutes, and
+	// backdating the issue time covers clock drift.
+	githubAppJWTLifetime = 9 * time.Minute
+	githubAppJWTBackdate = time.Minute
+	// installation tokens live an hour, they are refreshed a bit before
+	githubTokenRefreshMargin = 5 * time.Minute
+)
+
+// githubAuth holds how replay authenticates to GitHub: a token, or a GitHub
+// App ID and private key.
+type githubAuth struct {
+	token          string
+	appID          int64
+	key            *rsa.PrivateKey
+	installationID int64
+	baseURL        string
+}
+
+func githubAuthFromFlags(c *cli.Context) (*githubAuth, error) {
+	auth := &githubAuth{
+		token:          c.String("github-token"),
+		appID:          c.Int64("github-app-id"),
+		installationID: c.Int64("github-installation-id"),
+		baseURL:        c.String("github-base-url"),
+	}
+	keyFile := c.String("github-app-private-key")
+	switch {
+	case auth.appID != 0 && keyFile == "":
+		return nil, fmt.Errorf("--github-app-id needs --github-app-private-key")
+	case auth.appID == 0 && keyFile != "":
+		return nil, fmt.Errorf("--github-app-private-key needs --github-app-id")
+	case auth.appID != 0 && auth.token != "":
+		return nil, fmt.Errorf("use either --github-token or a GitHub App, not both")
+	case auth.appID == 0 && auth.token == "":
+		return nil, fmt.Errorf("required flag \"github-token\" not set, or --github-app-id and --github-app-private-key to authenticate as a GitHub App")
+	}
+	if keyFile != "" {
+		key, err := loadGitHubAppKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.key = key
+	}
+	return auth, nil
+}
+
+// loadGitHubAppKey reads the PEM private key GitHub generates for an App.
+func loadGitHubAppKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read GitHub App private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("cannot read GitHub App private key %s: no PEM data", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse GitHub App private key %s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key %s is not an RSA key", path)
+	}
+	return key, nil
+}
+
+// newGitHubAppJWT mints the RS256 JWT authenticating as the App itself.
+func newGitHubAppJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, time.Time, error) {
+	expires := now.Add(githubAppJWTLifetime)
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-githubAppJWTBackdate).Unix(),
+		"exp": expires.Unix(),
+		"iss": strconv.FormatInt(appID, 10),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot sign GitHub App JWT: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), expires, nil
+}
+
+// cachedToken is a bearer token reused until shortly before it expires.
+type cachedToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	now     func() time.Time
+	mint    func(ctx context.Context, now time.Time) (string, time.Time, error)
+}
+
+func (t *cachedToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if t.token != "" && now.Add(githubTokenRefreshMargin).Before(t.expires) {
+		return t.token, nil
+	}
+	token, expires, err := t.mint(ctx, now)
+	if err != nil {
+		return "", err
+	}
+	t.token, t.expires = token, expires
+	return token, nil
+}
+
+// bearerTransport sets the Authorization header from a cachedToken.
+type bearerTransport struct {
+	base  http.RoundTripper
+	token *cachedToken
+}
+
+func (b *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := b.token.get(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return b.base.RoundTrip(req)
+}
+
+func (a *githubAuth) newClient(httpClient *http.Client) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if a.baseURL == "" {
+		return client, nil
+	}
+	client, err := client.WithEnterpriseURLs(a.baseURL, a.baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --github-base-url: %w", err)
+	}
+	return client, nil
+}
+
+// appClient returns a client authenticated as the App, with a JWT. It can
+// only use the /app endpoints.
+func (a *githubAuth) appClient() (*github.Client, error) {
+	jwt := &cachedToken{now: time.Now, mint: func(_ context.Context, now time.Time) (string, time.Time, error) {
+		return newGitHubAppJWT(a.appID, a.key, now)
+	}}
+	return a.newClient(&http.Client{Transport: &bearerTransport{base: http.DefaultTransport, token: jwt}})
+}
+
+// client returns the client used to read the hooks of org or org/repo: with
+// a token, or with an installation token of the App installed there.
+func (a *githubAuth) client(org, repo string) (*github.Client, error) {
+	if a.key == nil {
+		client, err := a.newClient(nil)
+		if err != nil {
+			return nil, err
+		}
+		return client.WithAuthToken(a.token), nil
+	}
+	appClient, err := a.appClient()
+	if err != nil {
+		return nil, err
+	}
+	installationID := a.installationID
+	installation := &cachedToken{now: time.Now, mint: func(ctx context.Context, _ time.Time) (string, time.Time, error) {
+		if installationID == 0 {
+			var inst *github.Installation
+			var err error
+			if repo != "" {
+				inst, _, err = appClient.Apps.FindRepositoryInstallation(ctx, org, repo)
+			} else {
+				inst, _, err = appClient.Apps.FindOrganizationInstallation(ctx, org)
+			}
+			if err != nil {
+				return "", time.Time{}, fmt.Errorf("cannot find the GitHub App installation: %w", err)
+			}
+			installationID = inst.GetID()
+		}
+		token, _, err := appClient.Apps.CreateInstallationToken(ctx, installationID, nil)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("cannot create a GitHub App installation token: %w", err)
+		}
+		if token.GetToken() == "" {
+			return "", time.Time{}, errors.New("GitHub returned an empty installation token")
+		}
+		return token.GetToken(), token.GetExpiresAt().Time, nil
+	}}
+	return a.newClient(&http.Client{Transport: &bearerTransport{base: http.DefaultTransport, token: installation}})
+}
@@ -0,0 +1,188 @@
+package gosmee
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func newTestAppKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	return key
+}
+
+func TestNewGitHubAppJWT(t *testing.T) {
+	key := newTestAppKey(t)
+	now := time.Unix(1700000000, 0)
+	jwt, expires, err := newGitHubAppJWT(1234, key, now)
+	assert.NilError(t, err)
+	assert.Equal(t, expires, now.Add(githubAppJWTLifetime))
+
+	parts := strings.Split(jwt, ".")
+	assert.Equal(t, len(parts), 3)
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NilError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NilError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NilError(t, err)
+	var claims struct {
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+	}
+	assert.NilError(t, json.Unmarshal(raw, &claims))
+	assert.Equal(t, claims.Iss, "1234")
+	assert.Equal(t, claims.Iat, now.Add(-githubAppJWTBackdate).Unix())
+	assert.Equal(t, claims.Exp, expires.Unix())
+}
+
+func TestLoadGitHubAppKey(t *testing.T) {
+	key := newTestAppKey(t)
+	dir := t.TempDir()
+
+	pkcs1 := filepath.Join(dir, "pkcs1.pem")
+	assert.NilError(t, os.WriteFile(pkcs1, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+	loaded, err := loadGitHubAppKey(pkcs1)
+	assert.NilError(t, err)
+	assert.Assert(t, loaded.Equal(key))
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NilError(t, err)
+	pkcs8 := filepath.Join(dir, "pkcs8.pem")
+	assert.NilError(t, os.WriteFile(pkcs8, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	loaded, err = loadGitHubAppKey(pkcs8)
+	assert.NilError(t, err)
+	assert.Assert(t, loaded.Equal(key))
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	assert.NilError(t, os.WriteFile(garbage, []byte("not a key"), 0o600))
+	_, err = loadGitHubAppKey(garbage)
+	assert.ErrorContains(t, err, "no PEM data")
+}
+
+func TestGitHubAppInstallationClient(t *testing.T) {
+	key := newTestAppKey(t)
+	minted := 0
+	mux := http.NewServeMux()
+	appAuth := func(r *http.Request) bool {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return strings.Count(token, ".") == 2
+	}
+	mux.HandleFunc("GET /api/v3/repos/org/repo/installation", func(w http.ResponseWriter, r *http.Request) {
+		if !appAuth(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"id": 42}`)
+	})
+	mux.HandleFunc("POST /api/v3/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if !appAuth(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		minted++
+		fmt.Fprintf(w, `{"token": "ghs_installation", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("GET /api/v3/repos/org/repo/hooks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ghs_installation" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `[{"id": 7, "name": "web", "config": {"url": "https://example.com/hook"}}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	auth := &githubAuth{appID: 1234, key: key, baseURL: server.URL}
+	client, err := auth.client("org", "repo")
+	assert.NilError(t, err)
+	ghop := NewRepoLister(client, slog.New(slog.DiscardHandler), "org", "repo")
+	for range 2 {
+		hooks, _, err := ghop.ListHooks(context.Background(), "org", "repo", nil)
+		assert.NilError(t, err)
+		assert.Equal(t, len(hooks), 1)
+		assert.Equal(t, hooks[0].GetID(), int64(7))
+	}
+	// the installation token is reused until it expires
+	assert.Equal(t, minted, 1)
+}
+
+func TestAppOP(t *testing.T) {
+	key := newTestAppKey(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/app/hook/config", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"url": "https://example.com/app-hook", "content_type": "json"}`)
+	})
+	mux.HandleFunc("GET /api/v3/app/hook/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("per_page"), "100")
+		fmt.Fprint(w, `[{"id": 2, "guid": "guid-2", "event": "installation"}, {"id": 1, "guid": "guid-1", "event": "push"}]`)
+	})
+	mux.HandleFunc("GET /api/v3/app/hook/deliveries/2", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"id": 2, "guid": "guid-2", "event": "installation", "request": {"headers": {"X-GitHub-Event": "installation"}, "payload": {"action": "created"}}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	auth := &githubAuth{appID: 1234, key: key, baseURL: server.URL}
+	client, err := auth.appClient()
+	assert.NilError(t, err)
+	ghop := NewAppLister(client, slog.New(slog.DiscardHandler))
+
+	hooks, _, err := ghop.ListHooks(context.Background(), "", "", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, hooks[0].Config["url"], "https://example.com/app-hook")
+
+	r := &replayOpts{history: ghop, logger: slog.New(slog.DiscardHandler)}
+	deliveries, err := r.listHookDeliveriesSince(context.Background(), 0, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(deliveries), 2)
+
+	delivery, err := r.getHookDelivery(context.Background(), 0, deliveries[0].GetID())
+	assert.NilError(t, err)
+	assert.Equal(t, delivery.GetRequest().Headers["X-GitHub-Event"], "installation")
+}
+
+func TestGitHubAuthFromFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "token", args: []string{"--github-token", "t"}},
+		{name: "nothing", args: []string{}, err: `required flag "github-token" not set`},
+		{name: "app without key", args: []string{"--github-app-id", "1"}, err: "needs --github-app-private-key"},
+		{name: "key without app", args: []string{"--github-app-private-key", "k.pem"}, err: "needs --github-app-id"},
+		{name: "both", args: []string{"--github-token", "t", "--github-app-id", "1", "--github-app-private-key", "k.pem"}, err: "not both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := githubAuthFromFlags(newReplayContextForTest(t, tt.args))
+			if tt.err == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
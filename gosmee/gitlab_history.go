@@ -0,0 +1,137 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// gitlabHookEvent is a webhook event as listed by
+// /projects/:id/hooks/:hook_id/events.
+type gitlabHookEvent struct {
+	ID             int64             `json:"id"`
+	Trigger        string            `json:"trigger"`
+	RequestHeaders map[string]string `json:"request_headers"`
+	RequestData    json.RawMessage   `json:"request_data"`
+	ResponseStatus string            `json:"response_status"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+func (e gitlabHookEvent) delivery() *github.HookDelivery {
+	guid := e.RequestHeaders["X-Gitlab-Event-UUID"]
+	if guid == "" {
+		guid = strconv.FormatInt(e.ID, 10)
+	}
+	// response_status is the HTTP status, or an error like "internal error"
+	status, _ := strconv.Atoi(e.ResponseStatus)
+	payload := e.RequestData
+	return &github.HookDelivery{
+		ID:          github.Int64(e.ID),
+		GUID:        github.String(guid),
+		DeliveredAt: &github.Timestamp{Time: e.CreatedAt},
+		StatusCode:  github.Int(status),
+		Status:      github.String(e.ResponseStatus),
+		Event:       github.String(deliveryEventType(e.RequestHeaders)),
+		Action:      github.String(payloadAction(payload)),
+		Request:     &github.HookRequest{Headers: e.RequestHeaders, RawPayload: &payload},
+	}
+}
+
+// GitLabOP reads the webhook events GitLab keeps for a project hook, or a
+// group hook when only a group is given.
+type GitLabOP struct {
+	client *forgeClient
+	logger *slog.Logger
+	// hooksPath is projects/:id/hooks or groups/:id/hooks
+	hooksPath string
+
+	mu sync.Mutex
+	// GitLab has no endpoint for a single event, the listed ones are kept
+	events map[int64]*github.HookDelivery
+}
+
+var _ DeliveryHistory = (*GitLabOP)(nil)
+
+func NewGitLabLister(client *forgeClient, logger *slog.Logger, group, project string) *GitLabOP {
+	hooksPath := "groups/" + url.PathEscape(group) + "/hooks"
+	if project != "" {
+		hooksPath = "projects/" + url.PathEscape(group+"/"+project) + "/hooks"
+	}
+	return &GitLabOP{client: client, logger: logger, hooksPath: hooksPath, events: map[int64]*github.HookDelivery{}}
+}
+
+func (g *GitLabOP) Starting() {
+	g.logger.InfoContext(context.Background(), "watching GitLab webhook events on", "hooks", g.hooksPath)
+}
+
+func (g *GitLabOP) ListHooks(ctx context.Context, _, _ string, _ *github.ListOptions) ([]*github.Hook, *github.Response, error) {
+	var hooks []forgeHook
+	resp, err := g.client.do(ctx, http.MethodGet, g.hooksPath, url.Values{"per_page": {"100"}}, &hooks)
+	if err != nil {
+		return nil, resp, err
+	}
+	return forgeHooks(hooks), resp, nil
+}
+
+func (g *GitLabOP) ListHookDeliveries(ctx context.Context, _, _ string, hookID int64, opt *github.ListCursorOptions) ([]*github.HookDelivery, *github.Response, error) {
+	query, page := pageQuery(opt)
+	var events []gitlabHookEvent
+	resp, err := g.client.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d/events", g.hooksPath, hookID), query, &events)
+	if err != nil {
+		return nil, resp, err
+	}
+	deliveries := make([]*github.HookDelivery, 0, len(events))
+	g.mu.Lock()
+	for _, e := range events {
+		d := e.delivery()
+		g.events[e.ID] = d
+		deliveries = append(deliveries, d)
+	}
+	g.mu.Unlock()
+	nextPage(resp, page, len(events), opt)
+	return deliveries, resp, nil
+}
+
+// GetHookDelivery returns an event listed before, listed events already
+// have their payload. The events of the hook are listed, newest first, to
+// find one that was not.
+func (g *GitLabOP) GetHookDelivery(ctx context.Context, _, _ string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	if d := g.listed(deliveryID); d != nil {
+		return d, &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	}
+	opt := &github.ListCursorOptions{}
+	for {
+		deliveries, resp, err := g.ListHookDeliveries(ctx, "", "", hookID, opt)
+		if err != nil {
+			return nil, resp, err
+		}
+		if d := g.listed(deliveryID); d != nil {
+			return d, resp, nil
+		}
+		// event IDs grow with time, the next pages only have older events
+		if resp.Cursor == "" || len(deliveries) == 0 || deliveries[len(deliveries)-1].GetID() < deliveryID {
+			break
+		}
+		opt.Cursor = resp.Cursor
+	}
+	return nil, &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}, fmt.Errorf("GitLab webhook event %d not found", deliveryID)
+}
+
+func (g *GitLabOP) listed(deliveryID int64) *github.HookDelivery {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.events[deliveryID]
+}
+
+func (g *GitLabOP) RedeliverHookDelivery(ctx context.Context, _, _ string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	resp, err := g.client.do(ctx, http.MethodPost, fmt.Sprintf("%s/%d/events/%d/resend", g.hooksPath, hookID, deliveryID), nil, nil)
+	return nil, resp, err
+}
@@ -0,0 +1,226 @@
+package gosmee
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// GET /history/{channel}?before=<id>&limit=50 returns the most recent events
+// of the channel, newest first, for the web page to show on open:
+//
+//	{"events":[{"id":"<id>","data":{...}}],"next":"<id>"}
+//
+// next is the before of the following, older, page and is omitted on the
+// oldest one. With Redis the history is the stream of the channel and the IDs
+// are stream IDs. The in-process broker keeps the last --history-size events
+// of each channel in memory, numbered from 1 when the server starts, within
+// --history-max-memory for all the channels.
+const (
+	historyPath         = "/history/{channel:" + channelIDPattern + "}"
+	historyDefaultLimit = 50
+	historyMaxLimit     = 500
+	defaultHistorySize  = 50
+	// defaultHistoryMaxMemory is the default --history-max-memory, in MB.
+	defaultHistoryMaxMemory = 64
+	// localHistoryMaxChannels bounds the channels kept in memory, the one
+	// published to least recently is forgotten first.
+	localHistoryMaxChannels = 1000
+)
+
+var errInvalidHistoryID = errors.New("invalid history event id")
+
+type historyResponse struct {
+	Events []pollEvent `json:"events"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// eventHistory returns up to count events of a channel older than beforeID,
+// or the newest ones when beforeID is empty, newest first.
+type eventHistory interface {
+	History(ctx context.Context, channel, beforeID string, count int64) ([]relayEvent, error)
+}
+
+type channelHistory struct {
+	events    []relayEvent // oldest first
+	lastID    uint64
+	published uint64
+}
+
+// localHistory keeps the last events of each channel of the in-process
+// broker.
+type localHistory struct {
+	mu        sync.Mutex
+	size      int
+	maxBytes  int64
+	bytes     int64 // payload bytes of the kept events
+	channels  map[string]*channelHistory
+	published uint64 // orders the channels by their last publish
+}
+
+// newLocalHistory keeps size events per channel and maxBytes of payloads
+// overall, nil when size or maxBytes is 0.
+func newLocalHistory(size int, maxBytes int64) *localHistory {
+	if size <= 0 || maxBytes <= 0 {
+		return nil
+	}
+	return &localHistory{
+		size:     size,
+		maxBytes: maxBytes,
+		channels: make(map[string]*channelHistory),
+	}
+}
+
+// add keeps event in the history of channel and returns it with its history
+// ID, for the live event to carry the same ID as its history copy.
+func (h *localHistory) add(channel string, event relayEvent) relayEvent {
+	if h == nil {
+		return event
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	history, ok := h.channels[channel]
+	if !ok {
+		if len(h.channels) >= localHistoryMaxChannels {
+			h.forgetLeastRecent()
+		}
+		history = &channelHistory{}
+		h.channels[channel] = history
+	}
+	h.published++
+	history.published = h.published
+	history.lastID++
+	event.ID = strconv.FormatUint(history.lastID, 10)
+	history.events = append(history.events, event)
+	h.bytes += int64(len(event.Data))
+	if extra := len(history.events) - h.size; extra > 0 {
+		h.dropOldest(history, extra)
+	}
+	// over the memory budget, the least recently published channels lose
+	// their oldest events first
+	for h.bytes > h.maxBytes {
+		h.dropOldest(h.channels[h.leastRecent()], 1)
+	}
+	return event
+}
+
+func (h *localHistory) dropOldest(history *channelHistory, count int) {
+	for _, event := range history.events[:count] {
+		h.bytes -= int64(len(event.Data))
+	}
+	history.events = slices.Delete(history.events, 0, count)
+}
+
+func (h *localHistory) leastRecent() string {
+	oldest := ""
+	var oldestPublished uint64 = math.MaxUint64
+	for channel, history := range h.channels {
+		if len(history.events) > 0 && history.published < oldestPublished {
+			oldest, oldestPublished = channel, history.published
+		}
+	}
+	return oldest
+}
+
+func (h *localHistory) forgetLeastRecent() {
+	oldest := ""
+	var oldestPublished uint64 = math.MaxUint64
+	for channel, history := range h.channels {
+		if history.published < oldestPublished {
+			oldest, oldestPublished = channel, history.published
+		}
+	}
+	h.dropOldest(h.channels[oldest], len(h.channels[oldest].events))
+	delete(h.channels, oldest)
+}
+
+func (h *localHistory) History(_ context.Context, channel, beforeID string, count int64) ([]relayEvent, error) {
+	before := uint64(math.MaxUint64)
+	if beforeID != "" {
+		var err error
+		if before, err = strconv.ParseUint(beforeID, 10, 64); err != nil {
+			return nil, errInvalidHistoryID
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	history, ok := h.channels[channel]
+	if !ok {
+		return nil, nil
+	}
+	events := make([]relayEvent, 0)
+	for i := len(history.events) - 1; i >= 0 && int64(len(events)) < count; i-- {
+		// the IDs of the kept events are consecutive up to lastID
+		if history.lastID-uint64(len(history.events)-1-i) < before {
+			events = append(events, history.events[i])
+		}
+	}
+	return events, nil
+}
+
+// handleHistoryGet returns the history of a channel, encrypted for the key of
+// the request on protected channels like the live events.
+func handleHistoryGet(history eventHistory, signingKey ed25519.PrivateKey, protectedChannels *ProtectedChannels, corsOrigin string, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, pubKey, ok := authorizeEventSubscriber(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		limit := historyDefaultLimit
+		if value := query.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", value), http.StatusBadRequest)
+				return
+			}
+			limit = min(n, historyMaxLimit)
+		}
+
+		// one more event tells whether there is an older page
+		events, err := history.History(r.Context(), channel, query.Get("before"), int64(limit+1))
+		if errors.Is(err, errInvalidHistoryID) {
+			http.Error(w, fmt.Sprintf("invalid before %q", query.Get("before")), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.LogAttrs(r.Context(), slog.LevelWarn, "history read failed",
+				slog.String("request_id", middleware.GetReqID(r.Context())), slog.String("channel", channel),
+				slog.String("error", err.Error()))
+			http.Error(w, "read history failed", http.StatusInternalServerError)
+			return
+		}
+
+		resp := historyResponse{Events: []pollEvent{}}
+		if len(events) > limit {
+			events = events[:limit]
+			resp.Next = events[limit-1].ID
+		}
+		for _, event := range events {
+			payload, err := encryptRelayEvent(event, channel, pubKey, signingKey)
+			if err != nil {
+				logger.LogAttrs(r.Context(), slog.LevelWarn, "history encryption failed",
+					slog.String("channel", channel), slog.String("stream_id", event.ID), slog.String("error", err.Error()))
+				continue
+			}
+			resp.Events = append(resp.Events, pollEvent{ID: payload.ID, Data: pollEventData("", 0, payload.Data)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if corsOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", corsOrigin)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,221 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"gotest.tools/v3/assert"
+)
+
+func getTestHistory(t *testing.T, url string) (int, historyResponse) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	assert.NilError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	var body historyResponse
+	if resp.StatusCode == http.StatusOK {
+		assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(&body))
+	}
+	return resp.StatusCode, body
+}
+
+func historyIDs(events []relayEvent) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestLocalHistory(t *testing.T) {
+	assert.Assert(t, newLocalHistory(0, 1024) == nil)
+	var disabled *localHistory
+	disabled.add("test-channel", relayEvent{Data: []byte(`{}`)})
+
+	history := newLocalHistory(3, 1024)
+	for i := 1; i <= 5; i++ {
+		history.add("test-channel", relayEvent{Data: fmt.Appendf(nil, `{"n":%d}`, i)})
+	}
+	events, err := history.History(context.Background(), "test-channel", "", 10)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, historyIDs(events), []string{"5", "4", "3"})
+	assert.Equal(t, string(events[0].Data), `{"n":5}`)
+
+	events, err = history.History(context.Background(), "test-channel", "5", 1)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, historyIDs(events), []string{"4"})
+	events, err = history.History(context.Background(), "test-channel", "3", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 0)
+	events, err = history.History(context.Background(), "other-channel", "", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 0)
+	_, err = history.History(context.Background(), "test-channel", "1-0", 10)
+	assert.ErrorIs(t, err, errInvalidHistoryID)
+
+	t.Run("least recently published channel is forgotten", func(t *testing.T) {
+		history := newLocalHistory(1, 1024)
+		for i := range localHistoryMaxChannels {
+			history.add(fmt.Sprintf("channel-%d", i), relayEvent{})
+		}
+		history.add("channel-0", relayEvent{})
+		history.add("new-channel", relayEvent{})
+		assert.Equal(t, len(history.channels), localHistoryMaxChannels)
+		_, kept := history.channels["channel-0"]
+		assert.Assert(t, kept)
+		_, kept = history.channels["channel-1"]
+		assert.Assert(t, !kept)
+	})
+
+	t.Run("memory budget drops the oldest events of idle channels", func(t *testing.T) {
+		history := newLocalHistory(10, 10)
+		history.add("idle-channel", relayEvent{Data: []byte("12345")})
+		history.add("busy-channel", relayEvent{Data: []byte("12345")})
+		event := history.add("busy-channel", relayEvent{Data: []byte("678")})
+		assert.Equal(t, event.ID, "2")
+		assert.Equal(t, history.bytes, int64(8))
+
+		events, err := history.History(context.Background(), "idle-channel", "", 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 0)
+		events, err = history.History(context.Background(), "busy-channel", "", 10)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, historyIDs(events), []string{"2", "1"})
+
+		// over budget on its own, the channel keeps its newest events
+		history.add("busy-channel", relayEvent{Data: []byte("12345678")})
+		events, err = history.History(context.Background(), "busy-channel", "", 10)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, historyIDs(events), []string{"3"})
+	})
+}
+
+func TestLocalRelayLiveEventHistoryID(t *testing.T) {
+	eventBroker := NewEventBroker()
+	subscriber := eventBroker.Subscribe("test-channel", nil)
+	defer eventBroker.Unsubscribe("test-channel", subscriber)
+	relay := newLocalPayloadRelay(eventBroker)
+	relay.history = newLocalHistory(10, 1024)
+
+	_, err := relay.Publish(context.Background(), "test-channel", []byte(`{}`))
+	assert.NilError(t, err)
+	live := <-subscriber.Events
+	events, err := relay.history.History(context.Background(), "test-channel", "", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, live.ID, "1")
+	assert.Equal(t, live.ID, events[0].ID)
+}
+
+func TestHandleHistoryGet(t *testing.T) {
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	protectedChannels := mustProtectedChannels(t, map[string][]string{
+		"protected-channel": {EncodePublicKey(publicKey)},
+	})
+	relay := newLocalPayloadRelay(NewEventBroker())
+	relay.history = newLocalHistory(10, 1024)
+	for i := 1; i <= 3; i++ {
+		_, err := relay.Publish(context.Background(), "test-channel", fmt.Appendf(nil, `{"n":%d}`, i))
+		assert.NilError(t, err)
+	}
+	_, err = relay.Publish(context.Background(), "protected-channel", []byte(`{"secret":true}`))
+	assert.NilError(t, err)
+
+	router := chi.NewRouter()
+	router.Get(historyPath, handleHistoryGet(relay.history, nil, protectedChannels, "*", slog.New(slog.DiscardHandler)))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("pages from the newest event", func(t *testing.T) {
+		status, resp := getTestHistory(t, server.URL+"/history/test-channel?limit=2")
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, len(resp.Events), 2)
+		assert.Equal(t, resp.Events[0].ID, "3")
+		assert.Equal(t, string(resp.Events[0].Data), `{"n":3}`)
+		assert.Equal(t, resp.Next, "2")
+
+		status, resp = getTestHistory(t, server.URL+"/history/test-channel?limit=2&before="+resp.Next)
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, len(resp.Events), 1)
+		assert.Equal(t, resp.Events[0].ID, "1")
+		assert.Equal(t, resp.Next, "")
+	})
+
+	t.Run("unknown channel has no events", func(t *testing.T) {
+		status, resp := getTestHistory(t, server.URL+"/history/empty-channel")
+		assert.Equal(t, status, http.StatusOK)
+		assert.Assert(t, resp.Events != nil)
+		assert.Equal(t, len(resp.Events), 0)
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		status, _ := getTestHistory(t, server.URL+"/history/test-channel?limit=0")
+		assert.Equal(t, status, http.StatusBadRequest)
+		status, _ = getTestHistory(t, server.URL+"/history/test-channel?before=nope")
+		assert.Equal(t, status, http.StatusBadRequest)
+	})
+
+	t.Run("protected channel needs an allowed key", func(t *testing.T) {
+		status, _ := getTestHistory(t, server.URL+"/history/protected-channel")
+		assert.Equal(t, status, http.StatusNotFound)
+		status, _ = getTestHistory(t, server.URL+"/history/protected-channel?pubkey="+url.QueryEscape(mustGeneratePublicKey(t)))
+		assert.Equal(t, status, http.StatusNotFound)
+
+		status, resp := getTestHistory(t, server.URL+"/history/protected-channel?pubkey="+url.QueryEscape(EncodePublicKey(publicKey)))
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, len(resp.Events), 1)
+		plaintext, err := Decrypt(resp.Events[0].Data, privateKey)
+		assert.NilError(t, err)
+		assert.Equal(t, string(plaintext), `{"secret":true}`)
+	})
+}
+
+func TestRedisHistory(t *testing.T) {
+	client := &fakeRedisStreamClient{
+		xrevrangeMessages: []redis.XMessage{
+			{ID: "3-0", Values: map[string]any{"payload": `{"x-github-event":"push"}`}},
+			{ID: "2-0", Values: map[string]any{"payload": `{"x-github-event":"ping"}`}},
+		},
+	}
+	relay := newRedisPayloadRelayWithClient(client, 0)
+
+	events, err := relay.History(context.Background(), "test-channel", "", 3)
+	assert.NilError(t, err)
+	assert.Equal(t, client.xrevrangeEnd, "+")
+	assert.Equal(t, client.xrevrangeCount, int64(3))
+	assert.DeepEqual(t, historyIDs(events), []string{"3-0", "2-0"})
+	assert.Equal(t, events[0].EventType, "push")
+
+	_, err = relay.History(context.Background(), "test-channel", "4-0", 3)
+	assert.NilError(t, err)
+	assert.Equal(t, client.xrevrangeEnd, "(4-0")
+
+	_, err = relay.History(context.Background(), "test-channel", "4", 3)
+	assert.ErrorIs(t, err, errInvalidHistoryID)
+
+	t.Run("served newest first", func(t *testing.T) {
+		protectedChannels, err := LoadProtectedChannels("")
+		assert.NilError(t, err)
+		router := chi.NewRouter()
+		router.Get(historyPath, handleHistoryGet(relay, nil, protectedChannels, "*", slog.New(slog.DiscardHandler)))
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		status, resp := getTestHistory(t, server.URL+"/history/test-channel?limit=1")
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, len(resp.Events), 1)
+		assert.Equal(t, resp.Events[0].ID, "3-0")
+		assert.Equal(t, resp.Next, "3-0")
+	})
+}
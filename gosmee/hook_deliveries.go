@@ -0,0 +1,221 @@
+package gosmee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+const (
+	// deliveryPageSize is the largest page of hook deliveries GitHub returns.
+	deliveryPageSize = 100
+	// maxGitHubAttempts bounds how many times a rate limited or failing
+	// GitHub API call is retried.
+	maxGitHubAttempts = 10
+)
+
+var errDeliveryNotFound = errors.New("delivery not found")
+
+func (r *replayOpts) wait(ctx context.Context, delay time.Duration) error {
+	if r.sleep != nil {
+		return r.sleep(ctx, delay)
+	}
+	return sleepWithContext(ctx, delay)
+}
+
+// callGitHub runs call and retries it when GitHub rate limits it or fails
+// with a server error. It waits until X-RateLimit-Reset for the primary rate
+// limit, for Retry-After or with a backoff otherwise. Once the rate limit is
+// used up by a successful call, it waits for the reset before returning so
+// the next call goes through.
+func (r *replayOpts) callGitHub(ctx context.Context, what string, call func() (*github.Response, error)) (*github.Response, error) {
+	backoff := newRetryBackoff()
+	for attempt := 1; ; attempt++ {
+		resp, err := call()
+		if err == nil {
+			if resp != nil && resp.Rate.Limit > 0 && resp.Rate.Remaining == 0 {
+				if delay := time.Until(resp.Rate.Reset.Time); delay > 0 {
+					r.logger.LogAttrs(ctx, slog.LevelWarn, "GitHub API rate limit used up; waiting for reset",
+						slog.String("call", what), slog.Time("reset", resp.Rate.Reset.Time), slog.Duration("wait", delay))
+					if err := r.wait(ctx, delay+time.Second); err != nil {
+						return resp, err
+					}
+				}
+			}
+			return resp, nil
+		}
+
+		delay, retryable := githubRetryDelay(resp, err, backoff)
+		if !retryable || attempt >= maxGitHubAttempts {
+			return resp, err
+		}
+		r.logger.LogAttrs(ctx, slog.LevelWarn, "GitHub API call failed; retrying",
+			slog.String("call", what), slog.Int("attempt", attempt), slog.Duration("retry_in", delay), slog.String("error", err.Error()))
+		if err := r.wait(ctx, delay); err != nil {
+			return resp, err
+		}
+	}
+}
+
+// githubRetryDelay tells whether a failed GitHub API call is worth retrying
+// and how long to wait before doing so.
+func githubRetryDelay(resp *github.Response, err error, backoff *retryBackoff) (time.Duration, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		delay := time.Until(rateErr.Rate.Reset.Time) + time.Second
+		if delay <= time.Second {
+			// the reset time already passed or is unknown
+			delay = backoff.Next()
+		}
+		return delay, true
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil && *abuseErr.RetryAfter > 0 {
+			return *abuseErr.RetryAfter, true
+		}
+		return backoff.Next(), true
+	}
+	if resp != nil && resp.Response != nil && (resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests) {
+		return backoff.Next(), true
+	}
+	return 0, false
+}
+
+// listHookDeliveriesSince returns the deliveries of hookID, newest first,
+// following the pagination cursor until a delivery older than since shows
+// up. A zero since lists every delivery GitHub still has.
+func (r *replayOpts) listHookDeliveriesSince(ctx context.Context, hookID int64, since time.Time) ([]*github.HookDelivery, error) {
+	return r.listHookDeliveryPages(ctx, hookID, since, 0)
+}
+
+// listHookDeliveryPages is listHookDeliveriesSince stopping after maxPages
+// pages, 0 for no limit.
+func (r *replayOpts) listHookDeliveryPages(ctx context.Context, hookID int64, since time.Time, maxPages int) ([]*github.HookDelivery, error) {
+	ret := []*github.HookDelivery{}
+	opt := &github.ListCursorOptions{PerPage: deliveryPageSize}
+	for page := 1; ; page++ {
+		var deliveries []*github.HookDelivery
+		resp, err := r.callGitHub(ctx, "list hook deliveries", func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			deliveries, resp, err = r.history.ListHookDeliveries(ctx, r.org, r.repo, hookID, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, deliveries...)
+
+		if len(deliveries) == 0 || resp == nil || resp.Cursor == "" || (maxPages > 0 && page >= maxPages) {
+			return ret, nil
+		}
+		if !since.IsZero() && deliveries[len(deliveries)-1].GetDeliveredAt().Before(since) {
+			return ret, nil
+		}
+		if resp.Cursor == opt.Cursor {
+			return nil, fmt.Errorf("GitHub returned the same deliveries page cursor twice: %s", resp.Cursor)
+		}
+		r.logger.LogAttrs(ctx, slog.LevelDebug, "fetching next hook deliveries page", slog.Int("page", page+1), slog.Int("deliveries", len(ret)))
+		opt = &github.ListCursorOptions{PerPage: deliveryPageSize, Cursor: resp.Cursor}
+	}
+}
+
+// getHookDelivery returns the full delivery, waiting a bit when it was just
+// listed and is not yet available from the API.
+func (r *replayOpts) getHookDelivery(ctx context.Context, hookID, deliveryID int64) (*github.HookDelivery, error) {
+	for range 3 {
+		var delivery *github.HookDelivery
+		resp, err := r.callGitHub(ctx, "get hook delivery", func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			delivery, resp, err = r.history.GetHookDelivery(ctx, r.org, r.repo, hookID, deliveryID)
+			return resp, err
+		})
+		if resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound {
+			if err := r.wait(ctx, time.Second); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return delivery, nil
+	}
+	return nil, fmt.Errorf("%w: %d", errDeliveryNotFound, deliveryID)
+}
+
+// deliveryFilter selects the GitHub deliveries to replay, an empty field
+// matches every delivery.
+type deliveryFilter struct {
+	statuses    []string
+	events      []string
+	actions     []string
+	deliveryIDs []string
+	ignore      []string
+}
+
+func validateDeliveryStatuses(statuses []string) error {
+	for _, s := range statuses {
+		switch {
+		case s == "failed" || s == "success":
+		case len(s) == 3 && s[0] >= '1' && s[0] <= '5' && (strings.HasSuffix(s, "xx") || isDigits(s[1:])):
+		default:
+			return fmt.Errorf("invalid delivery status %q, use a status code like 502, a class like 5xx, failed or success", s)
+		}
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// matchStatus tells whether code matches a status given as a code (502), a
+// class (5xx), success (2xx) or failed (anything else, including deliveries
+// GitHub could not connect for).
+func matchStatus(status string, code int) bool {
+	switch {
+	case status == "failed":
+		return code < 200 || code > 299
+	case status == "success":
+		return code >= 200 && code <= 299
+	case strings.HasSuffix(status, "xx"):
+		return code/100 == int(status[0]-'0')
+	default:
+		return strconv.Itoa(code) == status
+	}
+}
+
+func (f deliveryFilter) match(d *github.HookDelivery) bool {
+	if len(f.statuses) > 0 && !slices.ContainsFunc(f.statuses, func(s string) bool { return matchStatus(s, d.GetStatusCode()) }) {
+		return false
+	}
+	if slices.Contains(f.ignore, d.GetEvent()) {
+		return false
+	}
+	if len(f.events) > 0 && !slices.Contains(f.events, d.GetEvent()) {
+		return false
+	}
+	if len(f.actions) > 0 && !slices.Contains(f.actions, d.GetAction()) {
+		return false
+	}
+	if len(f.deliveryIDs) > 0 && !slices.Contains(f.deliveryIDs, d.GetGUID()) && !slices.Contains(f.deliveryIDs, strconv.FormatInt(d.GetID(), 10)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+	"gotest.tools/v3/assert"
+)
+
+// pagedGHOp serves deliveries by pages of pageSize, newest first, and can
+// fail the first calls with a rate limit error.
+type pagedGHOp struct {
+	mockGHOp
+	pageSize    int
+	rateLimited int
+	cursors     []string
+}
+
+func (m *pagedGHOp) ListHookDeliveries(_ context.Context, _, _ string, _ int64, opt *github.ListCursorOptions) ([]*github.HookDelivery, *github.Response, error) {
+	if m.rateLimited > 0 {
+		m.rateLimited--
+		req, _ := http.NewRequest(http.MethodGet, "https://api.github.com/repos/o/r/hooks/1/deliveries", nil)
+		resp := &http.Response{StatusCode: http.StatusForbidden, Request: req}
+		return nil, &github.Response{Response: resp}, &github.RateLimitError{
+			Rate:     github.Rate{Limit: 5000, Remaining: 0, Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}},
+			Response: resp,
+		}
+	}
+	m.cursors = append(m.cursors, opt.Cursor)
+	start := 0
+	if opt.Cursor != "" {
+		for i, d := range m.deliveries {
+			if d.GetGUID() == opt.Cursor {
+				start = i
+			}
+		}
+	}
+	end := min(start+m.pageSize, len(m.deliveries))
+	resp := &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}
+	if end < len(m.deliveries) {
+		resp.Cursor = m.deliveries[end].GetGUID()
+	}
+	return m.deliveries[start:end], resp, nil
+}
+
+func pagedDeliveries(n int, newest time.Time) []*github.HookDelivery {
+	ret := make([]*github.HookDelivery, 0, n)
+	for i := range n {
+		ret = append(ret, &github.HookDelivery{
+			ID:          github.Int64(int64(n - i)),
+			GUID:        github.String(fmt.Sprintf("guid-%d", i)),
+			DeliveredAt: &github.Timestamp{Time: newest.Add(-time.Duration(i) * time.Minute)},
+		})
+	}
+	return ret
+}
+
+func TestListHookDeliveriesSincePaginates(t *testing.T) {
+	now := time.Now()
+	ghop := &pagedGHOp{mockGHOp: mockGHOp{deliveries: pagedDeliveries(250, now)}, pageSize: 100}
+	r := &replayOpts{history: ghop, logger: slog.New(slog.DiscardHandler)}
+
+	all, err := r.listHookDeliveriesSince(context.Background(), 1, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(all), 250)
+	assert.Equal(t, len(ghop.cursors), 3)
+	assert.Equal(t, ghop.cursors[0], "")
+
+	// stops once a page reaches deliveries older than since
+	ghop.cursors = nil
+	since := now.Add(-50 * time.Minute)
+	deliveries, err := r.listHookDeliveriesSince(context.Background(), 1, since)
+	assert.NilError(t, err)
+	assert.Equal(t, len(ghop.cursors), 1)
+	r.sinceTime = since
+	chosen := r.chooseDeliveries(deliveries)
+	assert.Equal(t, len(chosen), 51)
+	assert.Equal(t, chosen[0].GetID(), int64(200)) // oldest first
+}
+
+func TestListDeliveriesFetchesOnePage(t *testing.T) {
+	now := time.Now()
+	ghop := &pagedGHOp{mockGHOp: mockGHOp{deliveries: pagedDeliveries(250, now)}, pageSize: 100}
+	var out bytes.Buffer
+	r := &replayOpts{history: ghop, logger: slog.New(slog.DiscardHandler), output: "json", out: &out}
+
+	assert.NilError(t, r.listDeliveries(context.Background(), 1))
+	assert.Equal(t, len(ghop.cursors), 1)
+	assert.Equal(t, strings.Count(out.String(), "\n"), 100)
+
+	// a GUID past the first page is only found within a time window
+	assert.ErrorContains(t, r.showDelivery(context.Background(), 1, "guid-150"), "set --time-since")
+	ghop.cursors = nil
+	r.sinceTime = now.Add(-200 * time.Minute)
+	assert.NilError(t, r.showDelivery(context.Background(), 1, "guid-150"))
+	assert.Equal(t, len(ghop.cursors), 3)
+}
+
+func TestListHookDeliveriesSinceRateLimit(t *testing.T) {
+	ghop := &pagedGHOp{mockGHOp: mockGHOp{deliveries: pagedDeliveries(3, time.Now())}, pageSize: 100, rateLimited: 2}
+	var waits []time.Duration
+	r := &replayOpts{
+		history: ghop,
+		logger:  slog.New(slog.DiscardHandler),
+		sleep: func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		},
+	}
+	deliveries, err := r.listHookDeliveriesSince(context.Background(), 1, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(deliveries), 3)
+	assert.Equal(t, len(waits), 2)
+	// waits until X-RateLimit-Reset, about an hour away
+	assert.Assert(t, waits[0] > 59*time.Minute, "waited %s", waits[0])
+
+	// gives up after maxGitHubAttempts
+	ghop.rateLimited = maxGitHubAttempts
+	_, err = r.listHookDeliveriesSince(context.Background(), 1, time.Time{})
+	var rateErr *github.RateLimitError
+	assert.Assert(t, errors.As(err, &rateErr))
+}
+
+func TestCallGitHubWaitsWhenRateLimitUsedUp(t *testing.T) {
+	var waited time.Duration
+	r := &replayOpts{
+		logger: slog.New(slog.DiscardHandler),
+		sleep: func(_ context.Context, d time.Duration) error {
+			waited = d
+			return nil
+		},
+	}
+	reset := time.Now().Add(10 * time.Minute)
+	_, err := r.callGitHub(context.Background(), "test", func() (*github.Response, error) {
+		return &github.Response{
+			Response: &http.Response{StatusCode: http.StatusOK},
+			Rate:     github.Rate{Limit: 5000, Remaining: 0, Reset: github.Timestamp{Time: reset}},
+		}, nil
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, waited > 9*time.Minute)
+
+	// client errors are not retried
+	calls := 0
+	_, err = r.callGitHub(context.Background(), "test", func() (*github.Response, error) {
+		calls++
+		return &github.Response{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, errors.New("bad credentials")
+	})
+	assert.ErrorContains(t, err, "bad credentials")
+	assert.Equal(t, calls, 1)
+}
+
+func TestDeliveryFilter(t *testing.T) {
+	d := &github.HookDelivery{
+		ID:         github.Int64(42),
+		GUID:       github.String("guid-42"),
+		StatusCode: github.Int(502),
+		Event:      github.String("pull_request"),
+		Action:     github.String("opened"),
+	}
+	tests := []struct {
+		name   string
+		filter deliveryFilter
+		want   bool
+	}{
+		{name: "empty", filter: deliveryFilter{}, want: true},
+		{name: "failed", filter: deliveryFilter{statuses: []string{"failed"}}, want: true},
+		{name: "success", filter: deliveryFilter{statuses: []string{"success"}}, want: false},
+		{name: "class", filter: deliveryFilter{statuses: []string{"4xx", "5xx"}}, want: true},
+		{name: "code", filter: deliveryFilter{statuses: []string{"500"}}, want: false},
+		{name: "event", filter: deliveryFilter{events: []string{"push"}}, want: false},
+		{name: "ignored event", filter: deliveryFilter{ignore: []string{"pull_request"}}, want: false},
+		{name: "action", filter: deliveryFilter{events: []string{"pull_request"}, actions: []string{"opened"}}, want: true},
+		{name: "guid", filter: deliveryFilter{deliveryIDs: []string{"guid-42"}}, want: true},
+		{name: "id", filter: deliveryFilter{deliveryIDs: []string{"42"}}, want: true},
+		{name: "other id", filter: deliveryFilter{deliveryIDs: []string{"43"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.filter.match(d), tt.want)
+		})
+	}
+
+	assert.NilError(t, validateDeliveryStatuses([]string{"502", "5xx", "failed", "success"}))
+	assert.ErrorContains(t, validateDeliveryStatuses([]string{"50x"}), "invalid delivery status")
+	assert.ErrorContains(t, validateDeliveryStatuses([]string{"bad"}), "invalid delivery status")
+}
+
+func TestReplayHooksOnce(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-GitHub-Delivery"))
+		if r.Header.Get("X-GitHub-Delivery") == "guid-3" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	payload := json.RawMessage(`{}`)
+	deliveries := []*github.HookDelivery{}
+	for i, status := range []int{500, 200, 502, 503} {
+		guid := fmt.Sprintf("guid-%d", i)
+		deliveries = append(deliveries, &github.HookDelivery{
+			ID:          github.Int64(int64(i)),
+			GUID:        github.String(guid),
+			StatusCode:  github.Int(status),
+			Event:       github.String("push"),
+			DeliveredAt: &github.Timestamp{Time: now.Add(-time.Duration(i) * time.Minute)},
+			Request: &github.HookRequest{
+				Headers:    map[string]string{"X-GitHub-Event": "push", "X-GitHub-Delivery": guid},
+				RawPayload: &payload,
+			},
+		})
+	}
+
+	r := &replayOpts{
+		history:   &mockGHOpForReplay{deliveries: deliveries},
+		logger:    slog.New(slog.DiscardHandler),
+		sinceTime: now.Add(-time.Hour),
+		once:      true,
+		filter:    deliveryFilter{statuses: []string{"5xx"}},
+		replayDataOpts: &replayDataOpts{
+			targetURL:        server.URL,
+			targetCnxTimeout: 1,
+		},
+	}
+	err := r.replayHooks(context.Background(), 1)
+	assert.ErrorContains(t, err, "1 deliveries failed to replay")
+	// oldest first, guid-1 was delivered fine by GitHub
+	assert.DeepEqual(t, received, []string{"guid-3", "guid-2", "guid-0"})
+	assert.Equal(t, r.summary, replaySummary{replayed: 2, failed: 1, skipped: 1})
+}
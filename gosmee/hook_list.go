@@ -1,22 +1,84 @@
 package gosmee
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/google/go-github/v57/github"
 	"github.com/mgutz/ansi"
+	"gopkg.in/yaml.v3"
 )
 
+// listFormats are the --output values of --list-hooks, --list-deliveries
+// and --show, "pretty" is the table.
+var listFormats = []string{"json", "yaml", "table", "pretty"}
+
+func (r *replayOpts) stdout() io.Writer {
+	if r.out != nil {
+		return r.out
+	}
+	return os.Stdout
+}
+
+func (r *replayOpts) structuredOutput() bool {
+	return r.output == "json" || r.output == "yaml"
+}
+
+// writeStructured writes v as JSON on a single line, or as YAML with the
+// same field names as the JSON.
+func writeStructured(w io.Writer, format string, v any) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writeStructuredList writes items as JSON lines, for jq, or as a YAML list.
+func writeStructuredList[T any](w io.Writer, format string, items []T) error {
+	if format == "yaml" {
+		return writeStructured(w, format, items)
+	}
+	for _, item := range items {
+		if err := writeStructured(w, format, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *replayOpts) listHooks(ctx context.Context) error {
 	var hooks []*github.Hook
 	var err error
-	if hooks, _, err = r.ghop.ListHooks(ctx, r.org, r.repo, nil); err != nil {
+	if hooks, _, err = r.history.ListHooks(ctx, r.org, r.repo, nil); err != nil {
 		return fmt.Errorf("cannot list hooks: %w", err)
 	}
 
-	fmt.Fprint(os.Stdout, ansi.Color(fmt.Sprintf("%-20s %-20s %s\n", "ID", "Name", "URL"), "cyan+b")) // nolint:staticcheck
+	out := r.stdout()
+	if r.structuredOutput() {
+		return writeStructuredList(out, r.output, hooks)
+	}
+	fmt.Fprint(out, ansi.Color(fmt.Sprintf("%-20s %-20s %s\n", "ID", "Name", "URL"), "cyan+b")) // nolint:staticcheck
 	for _, h := range hooks {
 		url := ""
 		if _url, here := h.Config["url"]; here {
@@ -25,21 +87,124 @@ func (r *replayOpts) listHooks(ctx context.Context) error {
 				url = ""
 			}
 		}
-		fmt.Fprintf(os.Stdout, "%-20d %-20s %s\n", h.GetID(), h.GetName(), url)
+		fmt.Fprintf(out, "%-20d %-20s %s\n", h.GetID(), h.GetName(), url)
 	}
 	return nil
 }
 
+// listingDeliveries returns the deliveries of hookID a listing goes
+// through, newest first: the ones since --time-since or up to --until, or
+// the last page only. Paging through the whole history of a busy hook
+// would use up the API rate limit.
+func (r *replayOpts) listingDeliveries(ctx context.Context, hookID int64) ([]*github.HookDelivery, error) {
+	maxPages := 1
+	if !r.sinceTime.IsZero() || !r.untilTime.IsZero() {
+		maxPages = 0
+	}
+	return r.listHookDeliveryPages(ctx, hookID, r.sinceTime, maxPages)
+}
+
 func (r *replayOpts) listDeliveries(ctx context.Context, hookID int64) error {
-	var deliveries []*github.HookDelivery
-	var err error
-	opt := &github.ListCursorOptions{PerPage: 50}
-	if deliveries, _, err = r.ghop.ListHookDeliveries(ctx, r.org, r.repo, hookID, opt); err != nil {
+	deliveries, err := r.listingDeliveries(ctx, hookID)
+	if err != nil {
 		return fmt.Errorf("cannot list deliveries: %w", err)
 	}
-	fmt.Fprint(os.Stdout, ansi.Color(fmt.Sprintf("%-12s %-12s %s\n", "ID", "Event", "Delivered At"), "cyan+b")) // nolint:staticcheck
+	deliveries = slices.DeleteFunc(r.chooseDeliveries(deliveries), func(d *github.HookDelivery) bool {
+		return !r.filter.match(d) || (!r.untilTime.IsZero() && d.GetDeliveredAt().After(r.untilTime))
+	})
+	slices.Reverse(deliveries) // newest first
+
+	out := r.stdout()
+	if r.structuredOutput() {
+		return writeStructuredList(out, r.output, deliveries)
+	}
+	fmt.Fprint(out, ansi.Color(fmt.Sprintf("%-12s %-12s %-6s %s\n", "ID", "Event", "Status", "Delivered At"), "cyan+b")) // nolint:staticcheck
 	for _, d := range deliveries {
-		fmt.Fprintf(os.Stdout, "%-12d %-12s %s\n", d.GetID(), d.GetEvent(), d.GetDeliveredAt().Format(userTSFormat))
+		fmt.Fprintf(out, "%-12d %-12s %-6d %s\n", d.GetID(), d.GetEvent(), d.GetStatusCode(), d.GetDeliveredAt().Format(userTSFormat))
 	}
 	return nil
 }
+
+// showDelivery prints the request and response of a delivery, given by ID
+// or GUID.
+func (r *replayOpts) showDelivery(ctx context.Context, hookID int64, id string) error {
+	deliveryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		// a GUID, look for its ID in the deliveries since --time-since
+		deliveries, err := r.listingDeliveries(ctx, hookID)
+		if err != nil {
+			return fmt.Errorf("cannot list deliveries: %w", err)
+		}
+		idx := slices.IndexFunc(deliveries, func(d *github.HookDelivery) bool { return d.GetGUID() == id })
+		if idx < 0 {
+			return fmt.Errorf("no delivery %q on hook %d, set --time-since if it is older", id, hookID)
+		}
+		deliveryID = deliveries[idx].GetID()
+	}
+	delivery, err := r.getHookDelivery(ctx, hookID, deliveryID)
+	if err != nil {
+		return fmt.Errorf("cannot get delivery: %w", err)
+	}
+
+	out := r.stdout()
+	if r.structuredOutput() {
+		return writeStructured(out, r.output, delivery)
+	}
+
+	field := func(name, value string) {
+		fmt.Fprintf(out, "%s %s\n", ansi.Color(name+":", "cyan+b"), value)
+	}
+	field("ID", strconv.FormatInt(delivery.GetID(), 10))
+	field("GUID", delivery.GetGUID())
+	event := delivery.GetEvent()
+	if delivery.GetAction() != "" {
+		event += "." + delivery.GetAction()
+	}
+	field("Event", event)
+	field("Delivered At", delivery.GetDeliveredAt().Format(userTSFormat))
+	field("Status", fmt.Sprintf("%d %s", delivery.GetStatusCode(), delivery.GetStatus()))
+	if d := delivery.GetDuration(); d != nil {
+		field("Duration", time.Duration(*d*float64(time.Second)).String())
+	}
+	field("Redelivery", strconv.FormatBool(delivery.GetRedelivery()))
+
+	section := func(name string, headers map[string]string, body json.RawMessage) {
+		fmt.Fprintf(out, "\n%s\n", ansi.Color(name+" Headers:", "cyan+b"))
+		writeHeaderMap(out, headers)
+		fmt.Fprintf(out, "\n%s\n", ansi.Color(name+" Body:", "cyan+b"))
+		writeDeliveryBody(out, body)
+	}
+	section("Request", delivery.GetRequest().GetHeaders(), delivery.GetRequest().GetRawPayload())
+	section("Response", delivery.GetResponse().GetHeaders(), delivery.GetResponse().GetRawPayload())
+	return nil
+}
+
+func writeHeaderMap(w io.Writer, headers map[string]string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\n", name, headers[name])
+	}
+}
+
+// writeDeliveryBody writes a JSON body indented, and a JSON string, which is
+// how GitHub returns the bodies that are not JSON, as is.
+func writeDeliveryBody(w io.Writer, body json.RawMessage) {
+	if len(body) == 0 {
+		return
+	}
+	var s string
+	if json.Unmarshal(body, &s) == nil {
+		fmt.Fprintln(w, s)
+		return
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		fmt.Fprintln(w, string(body))
+		return
+	}
+	fmt.Fprintln(w, indented.String())
+}
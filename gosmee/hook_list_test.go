@@ -1,9 +1,13 @@
 package gosmee
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"log/slog"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,11 +15,13 @@ import (
 	"gotest.tools/v3/assert"
 )
 
-// mockGHOp is a mock implementation of the GHOp interface for testing.
+// mockGHOp is a mock implementation of the DeliveryHistory interface for testing.
 type mockGHOp struct {
-	hooks      []*github.Hook
-	deliveries []*github.HookDelivery
-	err        error
+	hooks       []*github.Hook
+	deliveries  []*github.HookDelivery
+	err         error
+	mu          sync.Mutex
+	redelivered []int64
 }
 
 func (m *mockGHOp) Starting() {}
@@ -34,6 +40,17 @@ func (m *mockGHOp) ListHookDeliveries(_ context.Context, _, _ string, _ int64, _
 	return m.deliveries, &github.Response{}, nil
 }
 
+func (m *mockGHOp) RedeliverHookDelivery(_ context.Context, _, _ string, _, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	if m.err != nil {
+		return nil, &github.Response{}, m.err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redelivered = append(m.redelivered, deliveryID)
+	// GitHub queues redeliveries and answers 202 Accepted
+	return nil, &github.Response{}, &github.AcceptedError{}
+}
+
 func (m *mockGHOp) GetHookDelivery(_ context.Context, _, _ string, _, _ int64) (*github.HookDelivery, *github.Response, error) {
 	if m.err != nil {
 		return nil, &github.Response{}, m.err
@@ -67,15 +84,15 @@ func TestListHooks(t *testing.T) {
 			},
 		}
 
-		// Create mock GHOp implementation
+		// Create mock DeliveryHistory implementation
 		mockGh := &mockGHOp{hooks: hooks}
 
 		// Create test replayOpts
 		opts := &replayOpts{
-			logger: logger,
-			org:    "testorg",
-			repo:   "testrepo",
-			ghop:   mockGh,
+			logger:  logger,
+			org:     "testorg",
+			repo:    "testrepo",
+			history: mockGh,
 		}
 
 		// Call listHooks
@@ -86,15 +103,15 @@ func TestListHooks(t *testing.T) {
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		// Create mock GHOp with error
+		// Create mock DeliveryHistory with error
 		mockGh := &mockGHOp{err: errors.New("test error")}
 
 		// Create test replayOpts
 		opts := &replayOpts{
-			logger: logger,
-			org:    "testorg",
-			repo:   "testrepo",
-			ghop:   mockGh,
+			logger:  logger,
+			org:     "testorg",
+			repo:    "testrepo",
+			history: mockGh,
 		}
 
 		// Call listHooks
@@ -126,15 +143,15 @@ func TestListDeliveries(t *testing.T) {
 			},
 		}
 
-		// Create mock GHOp implementation
+		// Create mock DeliveryHistory implementation
 		mockGh := &mockGHOp{deliveries: deliveries}
 
 		// Create test replayOpts
 		opts := &replayOpts{
-			logger: logger,
-			org:    "testorg",
-			repo:   "testrepo",
-			ghop:   mockGh,
+			logger:  logger,
+			org:     "testorg",
+			repo:    "testrepo",
+			history: mockGh,
 		}
 
 		// Call listDeliveries
@@ -145,15 +162,15 @@ func TestListDeliveries(t *testing.T) {
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		// Create mock GHOp with error
+		// Create mock DeliveryHistory with error
 		mockGh := &mockGHOp{err: errors.New("test error")}
 
 		// Create test replayOpts
 		opts := &replayOpts{
-			logger: logger,
-			org:    "testorg",
-			repo:   "testrepo",
-			ghop:   mockGh,
+			logger:  logger,
+			org:     "testorg",
+			repo:    "testrepo",
+			history: mockGh,
 		}
 
 		// Call listDeliveries
@@ -163,3 +180,75 @@ func TestListDeliveries(t *testing.T) {
 		assert.Assert(t, err != nil)
 	})
 }
+
+func TestListDeliveriesStructuredOutput(t *testing.T) {
+	now := time.Now()
+	duration := 0.5
+	deliveries := []*github.HookDelivery{
+		{
+			ID: github.Int64(2), GUID: github.String("guid-2"), DeliveredAt: &github.Timestamp{Time: now},
+			Event: github.String("pull_request"), Action: github.String("opened"), StatusCode: github.Int(502),
+			Duration: &duration, Redelivery: github.Bool(true), InstallationID: github.Int64(7), RepositoryID: github.Int64(8),
+		},
+		{ID: github.Int64(1), GUID: github.String("guid-1"), DeliveredAt: &github.Timestamp{Time: now.Add(-time.Minute)}, Event: github.String("push"), StatusCode: github.Int(200)},
+	}
+
+	var out bytes.Buffer
+	opts := &replayOpts{
+		logger:  slog.New(slog.DiscardHandler),
+		history: &mockGHOp{deliveries: deliveries},
+		output:  "json",
+		out:     &out,
+		filter:  deliveryFilter{statuses: []string{"failed"}},
+	}
+	assert.NilError(t, opts.listDeliveries(context.Background(), 123))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), 1)
+	var got map[string]any
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, got["status_code"], float64(502))
+	assert.Equal(t, got["action"], "opened")
+	assert.Equal(t, got["duration"], 0.5)
+	assert.Equal(t, got["redelivery"], true)
+	assert.Equal(t, got["installation_id"], float64(7))
+	assert.Equal(t, got["repository_id"], float64(8))
+
+	out.Reset()
+	opts.output = "yaml"
+	opts.filter = deliveryFilter{}
+	assert.NilError(t, opts.listDeliveries(context.Background(), 123))
+	assert.Assert(t, strings.HasPrefix(out.String(), "- action: opened\n"), out.String())
+	assert.Assert(t, strings.Contains(out.String(), "- delivered_at:"))
+	assert.Assert(t, strings.Contains(out.String(), "  guid: guid-1\n"))
+}
+
+func TestShowDelivery(t *testing.T) {
+	payload := json.RawMessage(`{"action":"opened"}`)
+	response := json.RawMessage(`"bad gateway"`)
+	delivery := &github.HookDelivery{
+		ID: github.Int64(42), GUID: github.String("guid-42"), DeliveredAt: &github.Timestamp{Time: time.Now()},
+		Event: github.String("pull_request"), Action: github.String("opened"), StatusCode: github.Int(502), Status: github.String("Bad Gateway"),
+		Request:  &github.HookRequest{Headers: map[string]string{"X-GitHub-Event": "pull_request", "Content-Type": "application/json"}, RawPayload: &payload},
+		Response: &github.HookResponse{Headers: map[string]string{"Server": "nginx"}, RawPayload: &response},
+	}
+	var out bytes.Buffer
+	opts := &replayOpts{
+		logger:  slog.New(slog.DiscardHandler),
+		history: &mockGHOp{deliveries: []*github.HookDelivery{delivery}},
+		output:  "table",
+		out:     &out,
+	}
+	assert.NilError(t, opts.showDelivery(context.Background(), 123, "guid-42"))
+	for _, want := range []string{"pull_request.opened", "502 Bad Gateway", "Content-Type: application/json\nX-GitHub-Event: pull_request\n", "\"action\": \"opened\"", "Server: nginx", "bad gateway\n"} {
+		assert.Assert(t, strings.Contains(out.String(), want), "missing %q in %s", want, out.String())
+	}
+
+	out.Reset()
+	opts.output = "json"
+	assert.NilError(t, opts.showDelivery(context.Background(), 123, "42"))
+	var got github.HookDelivery
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, got.GetResponse().GetHeaders()["Server"], "nginx")
+
+	assert.ErrorContains(t, opts.showDelivery(context.Background(), 123, "guid-unknown"), `no delivery "guid-unknown"`)
+}
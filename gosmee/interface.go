@@ -7,16 +7,25 @@ import (
 	"github.com/google/go-github/v57/github"
 )
 
-type GHOp interface {
+// DeliveryHistory reads the webhook deliveries a forge keeps for a hook. The
+// go-github types are the common representation, the GitLab and Gitea
+// backends convert their records to them.
+type DeliveryHistory interface {
 	ListHooks(ctx context.Context, org, repo string, opt *github.ListOptions) ([]*github.Hook, *github.Response, error)
+	// ListHookDeliveries returns a page of deliveries, newest first. The
+	// returned Response.Cursor is passed as opt.Cursor to get the next page,
+	// it is empty on the last one.
 	ListHookDeliveries(ctx context.Context, org, repo string, hookID int64, opt *github.ListCursorOptions) ([]*github.HookDelivery, *github.Response, error)
 	GetHookDelivery(ctx context.Context, org, repo string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error)
+	// RedeliverHookDelivery asks the forge to send the delivery again to
+	// the hook URL.
+	RedeliverHookDelivery(ctx context.Context, org, repo string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error)
 	Starting()
 }
 
 var (
-	_ GHOp = (*RepoOP)(nil)
-	_ GHOp = (*OrgOP)(nil)
+	_ DeliveryHistory = (*RepoOP)(nil)
+	_ DeliveryHistory = (*OrgOP)(nil)
 )
 
 type RepoOP struct {
@@ -45,7 +54,11 @@ func (r *RepoOP) GetHookDelivery(ctx context.Context, org, repo string, hookID,
 	return r.client.Repositories.GetHookDelivery(ctx, org, repo, hookID, deliveryID)
 }
 
-var _ GHOp = (*RepoOP)(nil)
+func (r *RepoOP) RedeliverHookDelivery(ctx context.Context, org, repo string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	return r.client.Repositories.RedeliverHookDelivery(ctx, org, repo, hookID, deliveryID)
+}
+
+var _ DeliveryHistory = (*RepoOP)(nil)
 
 type OrgOP struct {
 	client    *github.Client
@@ -69,6 +82,52 @@ func (r *OrgOP) GetHookDelivery(ctx context.Context, org, _ string, hookID, deli
 	return r.client.Organizations.GetHookDelivery(ctx, org, hookID, deliveryID)
 }
 
+func (r *OrgOP) RedeliverHookDelivery(ctx context.Context, org, _ string, hookID, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	return r.client.Organizations.RedeliverHookDelivery(ctx, org, hookID, deliveryID)
+}
+
 func (r *OrgOP) Starting() {
 	r.logger.InfoContext(context.Background(), "watching deliveries on", "org", r.org)
 }
+
+// AppOP reads the deliveries of the webhook of a GitHub App, authenticated
+// as the App itself. An App has a single webhook, the hook ID is ignored.
+type AppOP struct {
+	client *github.Client
+	logger *slog.Logger
+}
+
+var _ DeliveryHistory = (*AppOP)(nil)
+
+func NewAppLister(client *github.Client, logger *slog.Logger) *AppOP {
+	return &AppOP{client: client, logger: logger}
+}
+
+// ListHooks returns the App webhook, with an ID of 0.
+func (r *AppOP) ListHooks(ctx context.Context, _, _ string, _ *github.ListOptions) ([]*github.Hook, *github.Response, error) {
+	config, resp, err := r.client.Apps.GetHookConfig(ctx)
+	if err != nil {
+		return nil, resp, err
+	}
+	return []*github.Hook{{
+		ID:     github.Int64(0),
+		Name:   github.String("app"),
+		Config: map[string]any{"url": config.GetURL()},
+	}}, resp, nil
+}
+
+func (r *AppOP) ListHookDeliveries(ctx context.Context, _, _ string, _ int64, opt *github.ListCursorOptions) ([]*github.HookDelivery, *github.Response, error) {
+	return r.client.Apps.ListHookDeliveries(ctx, opt)
+}
+
+func (r *AppOP) GetHookDelivery(ctx context.Context, _, _ string, _, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	return r.client.Apps.GetHookDelivery(ctx, deliveryID)
+}
+
+func (r *AppOP) RedeliverHookDelivery(ctx context.Context, _, _ string, _, deliveryID int64) (*github.HookDelivery, *github.Response, error) {
+	return r.client.Apps.RedeliverHookDelivery(ctx, deliveryID)
+}
+
+func (r *AppOP) Starting() {
+	r.logger.InfoContext(context.Background(), "watching deliveries of the GitHub App webhook")
+}
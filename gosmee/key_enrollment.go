@@ -0,0 +1,315 @@
+package gosmee
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keysPathPrefix     = "/keys/"
+	keysEnrollPath     = "/keys/{channel:" + channelIDPattern + "}"
+	keysRevokePath     = "/keys/{channel:" + channelIDPattern + "}/revoke"
+	redisEnrolledKeys  = "gosmee:enrolled:"
+	maxEnrollBodyBytes = 4096
+)
+
+// keyRegistry keeps the client keys enrolled with the enrollment API.
+type keyRegistry interface {
+	Register(ctx context.Context, channel string, key protectedChannelKey) error
+	// Revoke returns false when publicKey was not enrolled in channel.
+	Revoke(ctx context.Context, channel, publicKey string) (bool, error)
+}
+
+// liveKeyRegistry is a registry kept outside of the channels file, its keys
+// are read again periodically so that several servers share them.
+type liveKeyRegistry interface {
+	keyRegistry
+	Enrolled(ctx context.Context, channel string) ([]protectedChannelKey, error)
+}
+
+// fileKeyRegistry writes the enrolled keys to the channels file, under
+// enrolled_keys.
+type fileKeyRegistry struct {
+	mu       sync.Mutex
+	channels *ProtectedChannels
+}
+
+func (f *fileKeyRegistry) update(channel string, change func(cfg *protectedChannelConfig) bool) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.channels.path)
+	if err != nil {
+		return false, err
+	}
+	var cfg protectedChannelsFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return false, fmt.Errorf("unmarshal encrypted channels file: %w", err)
+	}
+	channelCfg, ok := cfg.Channels[channel]
+	if !ok {
+		return false, fmt.Errorf("channel %q is not in the encrypted channels file", channel)
+	}
+	if !change(&channelCfg) {
+		return false, nil
+	}
+	cfg.Channels[channel] = channelCfg
+
+	data, err = json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := writeStateFileAtomic(f.channels.path, "encrypted channels", append(data, '\n')); err != nil {
+		return false, err
+	}
+	return true, f.channels.Reload()
+}
+
+func (f *fileKeyRegistry) Register(_ context.Context, channel string, key protectedChannelKey) error {
+	_, err := f.update(channel, func(cfg *protectedChannelConfig) bool {
+		cfg.EnrolledKeys = slices.DeleteFunc(cfg.EnrolledKeys, func(k protectedChannelKey) bool { return k.PublicKey == key.PublicKey })
+		cfg.EnrolledKeys = append(cfg.EnrolledKeys, key)
+		return true
+	})
+	return err
+}
+
+func (f *fileKeyRegistry) Revoke(_ context.Context, channel, publicKey string) (bool, error) {
+	return f.update(channel, func(cfg *protectedChannelConfig) bool {
+		before := len(cfg.EnrolledKeys)
+		cfg.EnrolledKeys = slices.DeleteFunc(cfg.EnrolledKeys, func(k protectedChannelKey) bool { return k.PublicKey == publicKey })
+		return len(cfg.EnrolledKeys) != before
+	})
+}
+
+type redisHashClient interface {
+	HSet(ctx context.Context, key string, values ...any) *redis.IntCmd
+	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+}
+
+// redisKeyRegistry keeps the enrolled keys of a channel in a Redis hash, from
+// public key to expiry, empty for none.
+type redisKeyRegistry struct {
+	client redisHashClient
+}
+
+func (r *redisKeyRegistry) Register(ctx context.Context, channel string, key protectedChannelKey) error {
+	expires := ""
+	if key.ExpiresAt != nil {
+		expires = key.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if err := r.client.HSet(ctx, redisEnrolledKeys+channel, key.PublicKey, expires).Err(); err != nil {
+		return fmt.Errorf("enroll key in redis: %w", err)
+	}
+	return nil
+}
+
+func (r *redisKeyRegistry) Revoke(ctx context.Context, channel, publicKey string) (bool, error) {
+	removed, err := r.client.HDel(ctx, redisEnrolledKeys+channel, publicKey).Result()
+	if err != nil {
+		return false, fmt.Errorf("revoke key in redis: %w", err)
+	}
+	return removed > 0, nil
+}
+
+func (r *redisKeyRegistry) Enrolled(ctx context.Context, channel string) ([]protectedChannelKey, error) {
+	values, err := r.client.HGetAll(ctx, redisEnrolledKeys+channel).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read enrolled keys from redis: %w", err)
+	}
+	keys := make([]protectedChannelKey, 0, len(values))
+	for publicKey, expires := range values {
+		key := protectedChannelKey{PublicKey: publicKey}
+		if expires != "" {
+			at, err := time.Parse(time.RFC3339, expires)
+			if err != nil {
+				return nil, fmt.Errorf("expiry of enrolled key %s: %w", publicKey, err)
+			}
+			key.ExpiresAt = &at
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// UseRedisRegistry keeps the enrolled keys in Redis instead of the channels
+// file.
+func (p *ProtectedChannels) UseRedisRegistry(ctx context.Context, client redisHashClient) error {
+	p.registry = &redisKeyRegistry{client: client}
+	return p.refreshEnrolled(ctx)
+}
+
+// refreshEnrolled reads the keys of a live registry again.
+func (p *ProtectedChannels) refreshEnrolled(ctx context.Context) error {
+	live, ok := p.registry.(liveKeyRegistry)
+	if !ok {
+		return nil
+	}
+	p.mu.RLock()
+	channels := make([]string, 0, len(p.adminTokens))
+	for channel := range p.adminTokens {
+		channels = append(channels, channel)
+	}
+	p.mu.RUnlock()
+
+	enrolled := make(map[string]map[string]time.Time, len(channels))
+	for _, channel := range channels {
+		keys, err := live.Enrolled(ctx, channel)
+		if err != nil {
+			return err
+		}
+		allowed, err := allowedKeys(channel, keys)
+		if err != nil {
+			return err
+		}
+		enrolled[channel] = allowed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enrolled = enrolled
+	return nil
+}
+
+// checkAdminToken tells whether token is the admin token of channel.
+func (p *ProtectedChannels) checkAdminToken(channel, token string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	expected, ok := p.adminTokens[channel]
+	if !ok || token == "" {
+		return false
+	}
+	provided := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(provided[:], expected) == 1
+}
+
+// Enroll allows key to subscribe to channel.
+func (p *ProtectedChannels) Enroll(ctx context.Context, channel string, key protectedChannelKey) error {
+	if err := p.registry.Register(ctx, channel, key); err != nil {
+		return err
+	}
+	return p.refreshEnrolled(ctx)
+}
+
+// Revoke removes an enrolled key, its subscribers are disconnected.
+func (p *ProtectedChannels) Revoke(ctx context.Context, channel, publicKey string) (bool, error) {
+	removed, err := p.registry.Revoke(ctx, channel, publicKey)
+	if err != nil || !removed {
+		return removed, err
+	}
+	return true, p.refreshEnrolled(ctx)
+}
+
+type enrollmentResponse struct {
+	Channel     string     `json:"channel"`
+	PublicKey   string     `json:"public_key"`
+	Fingerprint string     `json:"fingerprint"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Revoked     bool       `json:"revoked,omitempty"`
+}
+
+// enrollmentRequest authenticates the admin token and reads the public key
+// of an enrollment request. Channels without an admin token, and wrong
+// tokens, get the same not-found response as other protected channel
+// requests.
+func enrollmentRequest(w http.ResponseWriter, r *http.Request, protectedChannels *ProtectedChannels) (string, protectedChannelKey, *[32]byte, bool) {
+	channel := chi.URLParam(r, "channel")
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !protectedChannels.checkAdminToken(channel, token) {
+		rejectProtectedChannelRequest(w)
+		return "", protectedChannelKey{}, nil, false
+	}
+
+	var key protectedChannelKey
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEnrollBodyBytes)).Decode(&key); err != nil {
+		http.Error(w, fmt.Sprintf("invalid enrollment request: %v", err), http.StatusBadRequest)
+		return "", protectedChannelKey{}, nil, false
+	}
+	publicKey, err := ParsePublicKey(key.PublicKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid public key: %v", err), http.StatusBadRequest)
+		return "", protectedChannelKey{}, nil, false
+	}
+	key.PublicKey = EncodePublicKey(publicKey)
+	return channel, key, publicKey, true
+}
+
+func writeEnrollmentResponse(w http.ResponseWriter, status int, resp enrollmentResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleKeyEnroll registers the public key posted by the admin of a channel.
+func handleKeyEnroll(protectedChannels *ProtectedChannels, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, key, publicKey, ok := enrollmentRequest(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at is in the past", http.StatusBadRequest)
+			return
+		}
+		if err := protectedChannels.Enroll(r.Context(), channel, key); err != nil {
+			logger.LogAttrs(r.Context(), slog.LevelError, "key enrollment failed",
+				slog.String("channel", channel), slog.String("error", err.Error()))
+			http.Error(w, "key enrollment failed", http.StatusInternalServerError)
+			return
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "client key enrolled",
+			slog.String("channel", channel), slog.String("key_fingerprint", PublicKeyFingerprint(publicKey)))
+		writeEnrollmentResponse(w, http.StatusCreated, enrollmentResponse{
+			Channel:     channel,
+			PublicKey:   key.PublicKey,
+			Fingerprint: PublicKeyFingerprint(publicKey),
+			ExpiresAt:   key.ExpiresAt,
+		})
+	}
+}
+
+// handleKeyRevoke removes a key enrolled with handleKeyEnroll.
+func handleKeyRevoke(protectedChannels *ProtectedChannels, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, key, publicKey, ok := enrollmentRequest(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		removed, err := protectedChannels.Revoke(r.Context(), channel, key.PublicKey)
+		if err != nil {
+			logger.LogAttrs(r.Context(), slog.LevelError, "key revocation failed",
+				slog.String("channel", channel), slog.String("error", err.Error()))
+			http.Error(w, "key revocation failed", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "public key is not enrolled in this channel", http.StatusNotFound)
+			return
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "client key revoked",
+			slog.String("channel", channel), slog.String("key_fingerprint", PublicKeyFingerprint(publicKey)))
+		writeEnrollmentResponse(w, http.StatusOK, enrollmentResponse{
+			Channel:     channel,
+			PublicKey:   key.PublicKey,
+			Fingerprint: PublicKeyFingerprint(publicKey),
+			Revoked:     true,
+		})
+	}
+}
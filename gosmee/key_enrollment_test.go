@@ -0,0 +1,175 @@
+package gosmee
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"gotest.tools/v3/assert"
+)
+
+const testAdminToken = "channel-admin-token"
+
+func enrollableChannels(t *testing.T) (*ProtectedChannels, string) {
+	t.Helper()
+	tokenHash := sha256.Sum256([]byte(testAdminToken))
+	path := filepath.Join(t.TempDir(), "channels.json")
+	writeProtectedChannelsFile(t, path, protectedChannelsFile{
+		Channels: map[string]protectedChannelConfig{
+			"test-channel": {AdminTokenSHA256: hex.EncodeToString(tokenHash[:])},
+		},
+	})
+	protectedChannels, err := LoadProtectedChannels(path)
+	assert.NilError(t, err)
+	return protectedChannels, path
+}
+
+func enrollmentRouter(protectedChannels *ProtectedChannels) http.Handler {
+	router := chi.NewRouter()
+	logger := slog.New(slog.DiscardHandler)
+	router.Post(keysEnrollPath, handleKeyEnroll(protectedChannels, logger))
+	router.Post(keysRevokePath, handleKeyRevoke(protectedChannels, logger))
+	return router
+}
+
+func postEnrollment(t *testing.T, router http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestKeyEnrollmentInChannelsFile(t *testing.T) {
+	protectedChannels, path := enrollableChannels(t)
+	router := enrollmentRouter(protectedChannels)
+	publicKey, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	body := `{"public_key":"` + EncodePublicKey(publicKey) + `"}`
+
+	assert.Assert(t, protectedChannels.Has("test-channel"))
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", publicKey))
+
+	t.Run("wrong or missing token looks like an unknown channel", func(t *testing.T) {
+		assert.Equal(t, postEnrollment(t, router, "/keys/test-channel", "wrong", body).Code, http.StatusNotFound)
+		assert.Equal(t, postEnrollment(t, router, "/keys/test-channel", "", body).Code, http.StatusNotFound)
+		assert.Equal(t, postEnrollment(t, router, "/keys/other-channel", testAdminToken, body).Code, http.StatusNotFound)
+	})
+
+	t.Run("invalid public key", func(t *testing.T) {
+		w := postEnrollment(t, router, "/keys/test-channel", testAdminToken, `{"public_key":"nope"}`)
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+	})
+
+	t.Run("enroll", func(t *testing.T) {
+		w := postEnrollment(t, router, "/keys/test-channel", testAdminToken, body)
+		assert.Equal(t, w.Code, http.StatusCreated)
+		var resp enrollmentResponse
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, resp.Fingerprint, PublicKeyFingerprint(publicKey))
+		assert.Assert(t, protectedChannels.IsAllowed("test-channel", publicKey))
+
+		// persisted, a restart keeps the key
+		reloaded, err := LoadProtectedChannels(path)
+		assert.NilError(t, err)
+		assert.Assert(t, reloaded.IsAllowed("test-channel", publicKey))
+		data, err := os.ReadFile(path)
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(string(data), "enrolled_keys"))
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		w := postEnrollment(t, router, "/keys/test-channel/revoke", testAdminToken, body)
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Assert(t, !protectedChannels.IsAllowed("test-channel", publicKey))
+		assert.Assert(t, !protectedChannels.StillAuthorized("test-channel", publicKey))
+
+		w = postEnrollment(t, router, "/keys/test-channel/revoke", testAdminToken, body)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	})
+}
+
+type fakeRedisHashClient struct {
+	hashes map[string]map[string]string
+}
+
+func (f *fakeRedisHashClient) HSet(_ context.Context, key string, values ...any) *redis.IntCmd {
+	if f.hashes[key] == nil {
+		f.hashes[key] = map[string]string{}
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		f.hashes[key][values[i].(string)] = values[i+1].(string)
+	}
+	return redis.NewIntResult(int64(len(values)/2), nil)
+}
+
+func (f *fakeRedisHashClient) HDel(_ context.Context, key string, fields ...string) *redis.IntCmd {
+	removed := 0
+	for _, field := range fields {
+		if _, ok := f.hashes[key][field]; ok {
+			delete(f.hashes[key], field)
+			removed++
+		}
+	}
+	return redis.NewIntResult(int64(removed), nil)
+}
+
+func (f *fakeRedisHashClient) HGetAll(_ context.Context, key string) *redis.MapStringStringCmd {
+	values := map[string]string{}
+	for field, value := range f.hashes[key] {
+		values[field] = value
+	}
+	return redis.NewMapStringStringResult(values, nil)
+}
+
+func TestKeyEnrollmentInRedis(t *testing.T) {
+	protectedChannels, path := enrollableChannels(t)
+	client := &fakeRedisHashClient{hashes: map[string]map[string]string{}}
+	assert.NilError(t, protectedChannels.UseRedisRegistry(context.Background(), client))
+	router := enrollmentRouter(protectedChannels)
+
+	publicKey, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	body := `{"public_key":"` + EncodePublicKey(publicKey) + `","expires_at":"` + expiresAt.Format(time.RFC3339) + `"}`
+
+	w := postEnrollment(t, router, "/keys/test-channel", testAdminToken, body)
+	assert.Equal(t, w.Code, http.StatusCreated)
+	assert.Equal(t, client.hashes[redisEnrolledKeys+"test-channel"][EncodePublicKey(publicKey)], expiresAt.Format(time.RFC3339))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", publicKey))
+	protectedChannels.now = func() time.Time { return expiresAt }
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", publicKey))
+	protectedChannels.now = time.Now
+
+	// the channels file is left alone
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(data), "enrolled_keys"))
+
+	// keys enrolled by another server show up on refresh
+	otherKey, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	client.hashes[redisEnrolledKeys+"test-channel"][EncodePublicKey(otherKey)] = ""
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", otherKey))
+	assert.NilError(t, protectedChannels.refreshEnrolled(context.Background()))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", otherKey))
+
+	w = postEnrollment(t, router, "/keys/test-channel/revoke", testAdminToken, body)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", publicKey))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", otherKey))
+}
@@ -0,0 +1,430 @@
+package gosmee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Client key files come in three formats, told apart by LoadKeyPair: plain
+// (storedKeyPair), encrypted with a passphrase (encryptedKeyFile) and kept
+// in an OS keyring (keyringKeyFile).
+
+const (
+	encryptedKeyFileVersion = 1
+	keyFileKDF              = "scrypt"
+	// scrypt parameters recommended for interactive logins in 2017, about
+	// 100ms and 32MB.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// bounds of the scrypt parameters read from a key file, so that a
+	// tampered file cannot make gosmee allocate gigabytes or spin for hours
+	maxScryptMemory = 1 << 30
+	maxScryptP      = 16
+
+	keyPassphraseEnv    = "GOSMEE_KEY_PASSPHRASE"
+	newKeyPassphraseEnv = "GOSMEE_NEW_KEY_PASSPHRASE"
+)
+
+// encryptedKeyFile keeps the private key sealed with secretbox, under a key
+// derived from a passphrase with scrypt. The public key stays readable.
+type encryptedKeyFile struct {
+	Version    int    `json:"version"`
+	PublicKey  string `json:"public_key"`
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// keyringKeyFile points to a private key kept in an OS keyring.
+type keyringKeyFile struct {
+	PublicKey string `json:"public_key"`
+	Keyring   string `json:"keyring"`
+}
+
+// keyFileFormat holds the fields telling the key file formats apart.
+type keyFileFormat struct {
+	PrivateKey string `json:"private_key"`
+	KDF        string `json:"kdf"`
+	Keyring    string `json:"keyring"`
+}
+
+// PassphraseFunc returns the passphrase of the encrypted key file at path.
+type PassphraseFunc func(path string) ([]byte, error)
+
+// KeyFileWriter saves a client keypair to path, in one of the key file
+// formats.
+type KeyFileWriter func(path string, publicKey, privateKey *[32]byte) error
+
+// keyringBackend stores private keys outside of the key files, under the
+// encoded public key.
+type keyringBackend interface {
+	Store(publicKey, secret string) error
+	Lookup(publicKey string) (string, error)
+}
+
+// keyringBackends are the --keyring values.
+var keyringBackends = map[string]keyringBackend{
+	"secret-service": secretToolKeyring{},
+}
+
+// secretToolKeyring talks to the freedesktop Secret Service, GNOME Keyring
+// or KWallet, with the secret-tool command from libsecret.
+type secretToolKeyring struct{}
+
+func (secretToolKeyring) Store(publicKey, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "gosmee client key "+publicKey, "application", "gosmee", "public_key", publicKey)
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (secretToolKeyring) Lookup(publicKey string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "application", "gosmee", "public_key", publicKey)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool lookup: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if len(out) == 0 {
+		return "", fmt.Errorf("no gosmee key %s in the keyring", publicKey)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func LoadKeyPairWithPassphrase(path string, passphrase PassphraseFunc) (*[32]byte, *[32]byte, error) {
+	if path == "" {
+		return nil, nil, fmt.Errorf("key file path is required")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var format keyFileFormat
+	if err := json.Unmarshal(data, &format); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal key file: %w", err)
+	}
+	switch {
+	case format.KDF != "":
+		if passphrase == nil {
+			passphrase = NewPassphraseFunc("")
+		}
+		return loadEncryptedKeyPair(path, data, passphrase)
+	case format.Keyring != "":
+		return loadKeyringKeyPair(data)
+	}
+	return loadKeyPair(data)
+}
+
+func loadEncryptedKeyPair(path string, data []byte, passphrase PassphraseFunc) (*[32]byte, *[32]byte, error) {
+	var stored encryptedKeyFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal key file: %w", err)
+	}
+	if stored.Version != encryptedKeyFileVersion {
+		return nil, nil, fmt.Errorf("unsupported encrypted key file version %d", stored.Version)
+	}
+	if stored.KDF != keyFileKDF {
+		return nil, nil, fmt.Errorf("unsupported key derivation %q", stored.KDF)
+	}
+	if err := checkScryptParams(stored.N, stored.R, stored.P); err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := decodeStdKey(stored.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode public key: %w", err)
+	}
+	salt, err := base64.StdEncoding.DecodeString(stored.Salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode salt: %w", err)
+	}
+	nonceBytes, err := base64.StdEncoding.DecodeString(stored.Nonce)
+	if err != nil || len(nonceBytes) != 24 {
+		return nil, nil, fmt.Errorf("invalid nonce in key file")
+	}
+	var nonce [24]byte
+	copy(nonce[:], nonceBytes)
+	ciphertext, err := base64.StdEncoding.DecodeString(stored.Ciphertext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode ciphertext: %w", err)
+	}
+
+	secret, err := passphrase(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := scrypt.Key(secret, salt, stored.N, stored.R, stored.P, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("derive key file key: %w", err)
+	}
+	var secretKey [32]byte
+	copy(secretKey[:], key)
+	plaintext, ok := secretbox.Open(nil, ciphertext, &nonce, &secretKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("decrypt key file %s: wrong passphrase", path)
+	}
+	privateKey, err := bytesToKey(plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode private key: %w", err)
+	}
+	return publicKey, privateKey, nil
+}
+
+func loadKeyringKeyPair(data []byte) (*[32]byte, *[32]byte, error) {
+	var stored keyringKeyFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal key file: %w", err)
+	}
+	backend, ok := keyringBackends[stored.Keyring]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown keyring %q", stored.Keyring)
+	}
+	publicKey, err := decodeStdKey(stored.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode public key: %w", err)
+	}
+	secret, err := backend.Lookup(stored.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, err := decodeStdKey(secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode private key from the keyring: %w", err)
+	}
+	return publicKey, privateKey, nil
+}
+
+// SaveEncryptedKeyPair writes the keypair with the private key encrypted
+// with passphrase.
+func SaveEncryptedKeyPair(path string, publicKey, privateKey *[32]byte, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return fmt.Errorf("the key file passphrase cannot be empty")
+	}
+	var salt [16]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	key, err := scrypt.Key(passphrase, salt[:], scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return err
+	}
+	var secretKey [32]byte
+	copy(secretKey[:], key)
+
+	return writeKeyFile(path, encryptedKeyFile{
+		Version:    encryptedKeyFileVersion,
+		PublicKey:  base64.StdEncoding.EncodeToString(publicKey[:]),
+		KDF:        keyFileKDF,
+		Salt:       base64.StdEncoding.EncodeToString(salt[:]),
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce[:]),
+		Ciphertext: base64.StdEncoding.EncodeToString(secretbox.Seal(nil, privateKey[:], &nonce, &secretKey)),
+	})
+}
+
+// SaveKeyringKeyPair stores the private key in the keyring and writes a key
+// file pointing to it.
+func SaveKeyringKeyPair(path string, publicKey, privateKey *[32]byte, keyring string) error {
+	backend, ok := keyringBackends[keyring]
+	if !ok {
+		return fmt.Errorf("unknown keyring %q", keyring)
+	}
+	encodedPublicKey := base64.StdEncoding.EncodeToString(publicKey[:])
+	if err := backend.Store(encodedPublicKey, base64.StdEncoding.EncodeToString(privateKey[:])); err != nil {
+		return err
+	}
+	return writeKeyFile(path, keyringKeyFile{PublicKey: encodedPublicKey, Keyring: keyring})
+}
+
+// ChangeKeyPassphrase encrypts the key file at path with the passphrase
+// newPassphrase returns once the current one is known, a plain key file gets
+// encrypted.
+func ChangeKeyPassphrase(path string, passphrase PassphraseFunc, newPassphrase func() ([]byte, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var format keyFileFormat
+	if err := json.Unmarshal(data, &format); err != nil {
+		return fmt.Errorf("unmarshal key file: %w", err)
+	}
+	if format.Keyring != "" {
+		return fmt.Errorf("the private key of %s is kept in the %s keyring, it has no passphrase", path, format.Keyring)
+	}
+	publicKey, privateKey, err := LoadKeyPairWithPassphrase(path, passphrase)
+	if err != nil {
+		return err
+	}
+	secret, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	return SaveEncryptedKeyPair(path, publicKey, privateKey, secret)
+}
+
+// keyFileWriterFromFlags picks the format keygen writes new keypairs in, from
+// --encrypt and --keyring. newPassphraseFile and newPassphraseEnv are where
+// the passphrase of an encrypted key file comes from.
+func keyFileWriterFromFlags(c *cli.Context, newPassphraseFile, newPassphraseEnv string) (KeyFileWriter, error) {
+	keyring := c.String("keyring")
+	switch {
+	case c.Bool("encrypt") && keyring != "":
+		return nil, fmt.Errorf("--encrypt cannot be used with --keyring")
+	case c.Bool("encrypt"):
+		passphrase, err := NewKeyPassphrase(newPassphraseFile, newPassphraseEnv)
+		if err != nil {
+			return nil, err
+		}
+		return func(path string, publicKey, privateKey *[32]byte) error {
+			return SaveEncryptedKeyPair(path, publicKey, privateKey, passphrase)
+		}, nil
+	case keyring != "":
+		if _, ok := keyringBackends[keyring]; !ok {
+			return nil, fmt.Errorf("unknown keyring %q, use one of %s", keyring, strings.Join(slices.Sorted(maps.Keys(keyringBackends)), ", "))
+		}
+		return func(path string, publicKey, privateKey *[32]byte) error {
+			return SaveKeyringKeyPair(path, publicKey, privateKey, keyring)
+		}, nil
+	}
+	return SaveKeyPair, nil
+}
+
+func keygen(c *cli.Context) error {
+	keyFile := c.String("key-file")
+	if keyFile == "" {
+		return fmt.Errorf("required flag \"key-file\" not set")
+	}
+	if c.Bool("signing") {
+		if c.Bool("rotate") || c.Bool("encrypt") || c.String("keyring") != "" {
+			return fmt.Errorf("--signing cannot be used with --rotate, --encrypt or --keyring")
+		}
+		publicKey, privateKey, err := GenerateSigningKey()
+		if err != nil {
+			return err
+		}
+		if err := SaveSigningKey(keyFile, privateKey); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, EncodeSigningPublicKey(publicKey))
+		return nil
+	}
+
+	if c.Bool("rotate") {
+		nextKeyFile := c.String("next-key-file")
+		if nextKeyFile == "" {
+			nextKeyFile = keyFile + ".next"
+		}
+		save, err := keyFileWriterFromFlags(c, c.String("new-key-passphrase-file"), newKeyPassphraseEnv)
+		if err != nil {
+			return err
+		}
+		fingerprint, publicKey, err := RotateKeyPair(keyFile, nextKeyFile, NewPassphraseFunc(c.String("key-passphrase-file")), save)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "current key: %s (%s)\n", fingerprint, keyFile)
+		fmt.Fprintf(os.Stdout, "next key:    %s (%s)\n", EncodePublicKey(publicKey), nextKeyFile)
+		return nil
+	}
+
+	save, err := keyFileWriterFromFlags(c, c.String("key-passphrase-file"), keyPassphraseEnv)
+	if err != nil {
+		return err
+	}
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := save(keyFile, publicKey, privateKey); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, EncodePublicKey(publicKey))
+	return nil
+}
+
+// NewPassphraseFunc reads the passphrase from file when set, then from
+// $GOSMEE_KEY_PASSPHRASE, and prompts for it on a terminal otherwise.
+func NewPassphraseFunc(file string) PassphraseFunc {
+	return func(path string) ([]byte, error) {
+		return readPassphrase(file, keyPassphraseEnv, fmt.Sprintf("Passphrase for %s: ", path), false)
+	}
+}
+
+// NewKeyPassphrase returns the passphrase to encrypt a key file with, from
+// file when set, then from envVar, or prompted twice on a terminal.
+func NewKeyPassphrase(file, envVar string) ([]byte, error) {
+	return readPassphrase(file, envVar, "New key file passphrase: ", true)
+}
+
+func readPassphrase(file, envVar, prompt string, confirm bool) ([]byte, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read passphrase file: %w", err)
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	if value := os.Getenv(envVar); value != "" {
+		return []byte(value), nil
+	}
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return nil, fmt.Errorf("a key file passphrase is needed, set %s or use a passphrase file", envVar)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("read passphrase: %w", err)
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		again, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("read passphrase: %w", err)
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, fmt.Errorf("the passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// checkScryptParams rejects scrypt parameters a key file written by gosmee
+// would not use, scrypt needs 128*N*r bytes of memory.
+func checkScryptParams(n, r, p int) error {
+	if n <= 1 || n&(n-1) != 0 || r <= 0 || p <= 0 || p > maxScryptP || n > maxScryptMemory/128/r {
+		return fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d in key file", n, r, p)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package gosmee
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func staticPassphrase(passphrase string) PassphraseFunc {
+	return func(string) ([]byte, error) { return []byte(passphrase), nil }
+}
+
+func TestEncryptedKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client-key.json")
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveEncryptedKeyPair(path, publicKey, privateKey, []byte("correct horse")))
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(data), "private_key"))
+	info, err := os.Stat(path)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+	loadedPublicKey, loadedPrivateKey, err := LoadKeyPairWithPassphrase(path, staticPassphrase("correct horse"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loadedPublicKey, publicKey)
+	assert.DeepEqual(t, loadedPrivateKey, privateKey)
+
+	_, _, err = LoadKeyPairWithPassphrase(path, staticPassphrase("battery staple"))
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	t.Setenv(keyPassphraseEnv, "correct horse")
+	_, loadedPrivateKey, err = LoadKeyPair(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loadedPrivateKey, privateKey)
+
+	assert.ErrorContains(t, SaveEncryptedKeyPair(path, publicKey, privateKey, nil), "cannot be empty")
+
+	// a tampered file cannot ask for unbounded scrypt work
+	var stored map[string]any
+	assert.NilError(t, json.Unmarshal(data, &stored))
+	stored["n"] = 1 << 30
+	tampered, err := json.Marshal(stored)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(path, tampered, 0o600))
+	_, _, err = LoadKeyPairWithPassphrase(path, staticPassphrase("correct horse"))
+	assert.ErrorContains(t, err, "unsupported scrypt parameters")
+}
+
+func TestChangeKeyPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client-key.json")
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveKeyPair(path, publicKey, privateKey))
+
+	newPassphrase := func(passphrase string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(passphrase), nil }
+	}
+	// a plain key file gets encrypted
+	assert.NilError(t, ChangeKeyPassphrase(path, nil, newPassphrase("first")))
+	_, loadedPrivateKey, err := LoadKeyPairWithPassphrase(path, staticPassphrase("first"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loadedPrivateKey, privateKey)
+
+	err = ChangeKeyPassphrase(path, staticPassphrase("wrong"), func() ([]byte, error) {
+		t.Fatal("the new passphrase is asked before the current one is checked")
+		return nil, nil
+	})
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	assert.NilError(t, ChangeKeyPassphrase(path, staticPassphrase("first"), newPassphrase("second")))
+	_, _, err = LoadKeyPairWithPassphrase(path, staticPassphrase("first"))
+	assert.ErrorContains(t, err, "wrong passphrase")
+	_, loadedPrivateKey, err = LoadKeyPairWithPassphrase(path, staticPassphrase("second"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loadedPrivateKey, privateKey)
+}
+
+type fakeKeyring map[string]string
+
+func (k fakeKeyring) Store(publicKey, secret string) error {
+	k[publicKey] = secret
+	return nil
+}
+
+func (k fakeKeyring) Lookup(publicKey string) (string, error) {
+	secret, ok := k[publicKey]
+	if !ok {
+		return "", fmt.Errorf("no gosmee key %s in the keyring", publicKey)
+	}
+	return secret, nil
+}
+
+func TestKeyringKeyFile(t *testing.T) {
+	keyring := fakeKeyring{}
+	keyringBackends["fake"] = keyring
+	defer delete(keyringBackends, "fake")
+
+	path := filepath.Join(t.TempDir(), "client-key.json")
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveKeyringKeyPair(path, publicKey, privateKey, "fake"))
+
+	var stored map[string]string
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal(data, &stored))
+	assert.DeepEqual(t, stored, map[string]string{"public_key": stored["public_key"], "keyring": "fake"})
+
+	loadedPublicKey, loadedPrivateKey, err := LoadKeyPair(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loadedPublicKey, publicKey)
+	assert.DeepEqual(t, loadedPrivateKey, privateKey)
+
+	assert.ErrorContains(t, ChangeKeyPassphrase(path, nil, nil), "kept in the fake keyring")
+
+	clear(keyring)
+	_, _, err = LoadKeyPair(path)
+	assert.ErrorContains(t, err, "no gosmee key")
+
+	assert.ErrorContains(t, SaveKeyringKeyPair(path, publicKey, privateKey, "nope"), `unknown keyring "nope"`)
+}
+
+func TestReadPassphrase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "passphrase")
+	assert.NilError(t, os.WriteFile(file, []byte("from file\n"), 0o600))
+	t.Setenv(keyPassphraseEnv, "from env")
+
+	passphrase, err := NewPassphraseFunc(file)("key.json")
+	assert.NilError(t, err)
+	assert.Equal(t, string(passphrase), "from file")
+
+	passphrase, err = NewPassphraseFunc("")("key.json")
+	assert.NilError(t, err)
+	assert.Equal(t, string(passphrase), "from env")
+
+	_, err = NewPassphraseFunc(filepath.Join(t.TempDir(), "missing"))("key.json")
+	assert.ErrorContains(t, err, "read passphrase file")
+}
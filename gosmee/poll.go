@@ -0,0 +1,461 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GET /poll/{channel}?after=<id>&wait=30s&max=50 returns the events after
+// the after cursor, waiting up to wait for the first one:
+//
+//	{"events":[{"id":"<stream id>","data":{...}}],"next":"<cursor>","gap":{...}}
+//
+// The next cursor is the after of the following poll. With Redis it is a
+// stream ID, and an empty after starts from the live tail. The in-process
+// broker keeps no history, the first poll opens a session queueing the
+// events of the channel until the next poll, and next names that session.
+// Sessions not polled for pollSessionTTL are closed, pollNewSessionTTL for
+// the ones never polled again, and opening a session over
+// pollMaxChannelSessions or pollMaxSessions closes the least recently polled
+// one.
+//
+// A response stops taking events once over pollResponseSoftLen, the next
+// poll gets the rest.
+const (
+	pollPath               = "/poll/{channel:" + channelIDPattern + "}"
+	pollDefaultWait        = 30 * time.Second
+	pollMaxWait            = 60 * time.Second
+	pollDefaultMax         = 50
+	pollMaxEvents          = 500
+	pollSessionTTL         = 2 * pollMaxWait
+	pollNewSessionTTL      = 30 * time.Second
+	pollMaxChannelSessions = 16
+	pollMaxSessions        = 4096
+	pollSessionPrefix      = "session-"
+	pollClientTimeout      = pollMaxWait + 30*time.Second
+	pollResponseSoftLen    = 32 << 20
+	// the last event of a response can be a full payload, quoted when it is
+	// not JSON
+	maxPollResponseLen = pollResponseSoftLen + 2*maxDecompressedSize
+)
+
+type pollEvent struct {
+	ID   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data"`
+}
+
+type pollResponse struct {
+	Events []pollEvent      `json:"events"`
+	Next   string           `json:"next"`
+	Gap    *json.RawMessage `json:"gap,omitempty"`
+}
+
+type pollRequest struct {
+	after string
+	wait  time.Duration
+	max   int
+}
+
+// parsePollRequest reads the query of a poll, wait is a duration or a number
+// of seconds.
+func parsePollRequest(r *http.Request) (pollRequest, error) {
+	query := r.URL.Query()
+	req := pollRequest{after: query.Get("after"), wait: pollDefaultWait, max: pollDefaultMax}
+	if value := query.Get("wait"); value != "" {
+		wait, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, convErr := strconv.Atoi(value)
+			if convErr != nil {
+				return req, fmt.Errorf("invalid wait %q", value)
+			}
+			wait = time.Duration(seconds) * time.Second
+		}
+		if wait < 0 {
+			return req, fmt.Errorf("invalid wait %q", value)
+		}
+		req.wait = min(wait, pollMaxWait)
+	}
+	if value := query.Get("max"); value != "" {
+		maxEvents, err := strconv.Atoi(value)
+		if err != nil || maxEvents < 1 {
+			return req, fmt.Errorf("invalid max %q", value)
+		}
+		req.max = min(maxEvents, pollMaxEvents)
+	}
+	return req, nil
+}
+
+// pollEventData returns the data of an event for a poll response, compressed
+// as for SSE when the client asked for it.
+func pollEventData(encoding string, compressMinSize int, data []byte) json.RawMessage {
+	data = compressSSEData(encoding, compressMinSize, data)
+	if json.Valid(data) {
+		return data
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
+
+func writePollResponse(w http.ResponseWriter, corsOrigin, encoding string, resp pollResponse) {
+	if resp.Events == nil {
+		resp.Events = []pollEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if corsOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", corsOrigin)
+	}
+	if encoding != "" {
+		w.Header().Set(encodingHeader, encoding)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type pollSession struct {
+	channel    string
+	pubKey     *[32]byte
+	subscriber *Subscriber
+	lastPoll   time.Time
+	resumed    bool // polled again after the poll opening it
+	polling    int  // polls in progress, the session does not expire meanwhile
+}
+
+// pollSessions keeps the broker subscriptions of the in-process poll API.
+type pollSessions struct {
+	mu          sync.Mutex
+	eventBroker *EventBroker
+	sessions    map[string]*pollSession
+	now         func() time.Time
+}
+
+func newPollSessions(eventBroker *EventBroker) *pollSessions {
+	return &pollSessions{
+		eventBroker: eventBroker,
+		sessions:    make(map[string]*pollSession),
+		now:         time.Now,
+	}
+}
+
+// session returns the session named cursor, or opens a new one when it is
+// unknown, expired or opened for another channel or key.
+func (p *pollSessions) session(cursor, channel string, pubKey *[32]byte) (string, *pollSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session, ok := p.sessions[cursor]; ok && session.channel == channel && samePublicKey(session.pubKey, pubKey) {
+		session.lastPoll = p.now()
+		session.resumed = true
+		session.polling++
+		return cursor, session, true
+	}
+	if p.count(channel) >= pollMaxChannelSessions {
+		p.closeLeastRecent(channel)
+	}
+	if len(p.sessions) >= pollMaxSessions {
+		p.closeLeastRecent("")
+	}
+	id := pollSessionPrefix + randomString(24)
+	session := &pollSession{
+		channel:    channel,
+		pubKey:     pubKey,
+		subscriber: p.eventBroker.Subscribe(channel, pubKey),
+		lastPoll:   p.now(),
+		polling:    1,
+	}
+	p.sessions[id] = session
+	return id, session, false
+}
+
+// done ends a poll of session, its TTL starts again from now.
+func (p *pollSessions) done(session *pollSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session.lastPoll = p.now()
+	session.polling--
+}
+
+func (p *pollSessions) count(channel string) int {
+	n := 0
+	for _, session := range p.sessions {
+		if session.channel == channel {
+			n++
+		}
+	}
+	return n
+}
+
+// closeLeastRecent closes the least recently polled session of channel, or
+// of all the channels when channel is empty.
+func (p *pollSessions) closeLeastRecent(channel string) {
+	oldestID := ""
+	var oldest *pollSession
+	for id, session := range p.sessions {
+		if channel != "" && session.channel != channel {
+			continue
+		}
+		if oldest == nil || session.lastPoll.Before(oldest.lastPoll) {
+			oldestID, oldest = id, session
+		}
+	}
+	if oldest != nil {
+		p.closeLocked(oldestID, oldest)
+	}
+}
+
+func (p *pollSessions) closeLocked(id string, session *pollSession) {
+	p.eventBroker.Unsubscribe(session.channel, session.subscriber)
+	delete(p.sessions, id)
+}
+
+func samePublicKey(a, b *[32]byte) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// expire closes the sessions not polled for pollSessionTTL, or
+// pollNewSessionTTL when they were never polled again.
+func (p *pollSessions) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, session := range p.sessions {
+		ttl := pollSessionTTL
+		if !session.resumed {
+			ttl = pollNewSessionTTL
+		}
+		if session.polling == 0 && p.now().Sub(session.lastPoll) > ttl {
+			p.closeLocked(id, session)
+		}
+	}
+}
+
+// Run expires sessions until ctx is done.
+func (p *pollSessions) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollNewSessionTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.expire()
+		}
+	}
+}
+
+// handlePollGet answers polls from the sessions of the in-process broker.
+func handlePollGet(sessions *pollSessions, protectedChannels *ProtectedChannels, corsOrigin string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, pubKey, ok := authorizeEventSubscriber(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		req, err := parsePollRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		compressMinSize := sessions.eventBroker.compressMinSize
+		encoding := negotiateEncoding(r, compressMinSize)
+
+		id, session, resumed := sessions.session(req.after, channel, pubKey)
+		resp := pollResponse{Next: id}
+		if req.after != "" && !resumed {
+			gap := json.RawMessage(fmt.Sprintf(`{"error":"poll_session_expired","requested_id":%q}`, req.after))
+			resp.Gap = &gap
+		}
+
+		timer := time.NewTimer(req.wait)
+		defer timer.Stop()
+		size := 0
+		for len(resp.Events) < req.max && size < pollResponseSoftLen {
+			var event relayEvent
+			var ok bool
+			if len(resp.Events) == 0 {
+				select {
+				case event, ok = <-session.subscriber.Events:
+				case <-timer.C:
+				case <-r.Context().Done():
+					sessions.done(session)
+					return
+				}
+			} else {
+				// send what is queued already with the first event
+				select {
+				case event, ok = <-session.subscriber.Events:
+				default:
+				}
+			}
+			if !ok {
+				break
+			}
+			_, span := startRelayEventSpan(r.Context(), "gosmee.poll.send", channel, event, trace.WithSpanKind(trace.SpanKindProducer))
+			data := pollEventData(encoding, compressMinSize, event.Data)
+			resp.Events = append(resp.Events, pollEvent{ID: event.ID, Data: data})
+			size += len(data)
+			span.End()
+		}
+		sessions.done(session)
+		writePollResponse(w, corsOrigin, encoding, resp)
+	}
+}
+
+// handleRedisPollGet answers polls from the Redis stream of the channel.
+func handleRedisPollGet(redisRelay *redisPayloadRelay, protectedChannels *ProtectedChannels, corsOrigin string, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, pubKey, ok := authorizeEventSubscriber(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		req, err := parsePollRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		readAfterID, gapEvent, status, err := redisResumePosition(r.Context(), redisRelay, channel, req.after)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		encoding := negotiateEncoding(r, redisRelay.compressMinSize)
+
+		resp := pollResponse{Next: readAfterID}
+		if len(gapEvent) > 0 {
+			gap := json.RawMessage(gapEvent)
+			resp.Gap = &gap
+		}
+		block := req.wait
+		if block <= 0 {
+			block = -1 // no BLOCK, 0 would wait forever
+		}
+		events, err := redisRelay.Read(r.Context(), channel, readAfterID, block, int64(req.max))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			logger.LogAttrs(r.Context(), slog.LevelWarn, "Redis stream read failed",
+				slog.String("request_id", middleware.GetReqID(r.Context())), slog.String("channel", channel),
+				slog.String("error", err.Error()))
+			http.Error(w, "read redis stream failed", http.StatusInternalServerError)
+			return
+		}
+		size := 0
+		for _, event := range events {
+			if size >= pollResponseSoftLen {
+				break
+			}
+			resp.Next = event.ID
+			_, span := startRelayEventSpan(r.Context(), "gosmee.poll.send", channel, event, trace.WithSpanKind(trace.SpanKindProducer))
+			payload, err := encryptRelayEvent(event, channel, pubKey, redisRelay.signingKey)
+			endSpan(span, err)
+			if err != nil {
+				logger.LogAttrs(r.Context(), slog.LevelWarn, "Redis poll encryption failed",
+					slog.String("channel", channel), slog.String("stream_id", event.ID), slog.String("error", err.Error()))
+				continue
+			}
+			data := pollEventData(encoding, redisRelay.compressMinSize, payload.Data)
+			resp.Events = append(resp.Events, pollEvent{ID: payload.ID, Data: data})
+			size += len(data)
+		}
+		writePollResponse(w, corsOrigin, encoding, resp)
+	}
+}
+
+// consumePoll receives events by long polling, processing them like
+// consumeSSEStream. The cursor starts from the resume checkpoint.
+func (c goSmee) consumePoll(ctx context.Context, httpClient *http.Client, sseURL, version string, privateKey *[32]byte, state *resumeState, reconnectBackoff *retryBackoff) error {
+	pollURL, err := subscriptionEndpoint(sseURL, "poll")
+	if err != nil {
+		return err
+	}
+	cursor := state.ID()
+	ready := false
+	for {
+		query := pollURL.Query()
+		query.Set("wait", pollDefaultWait.String())
+		query.Set("max", strconv.Itoa(pollDefaultMax))
+		if cursor != "" {
+			query.Set("after", cursor)
+		} else {
+			query.Del("after")
+		}
+		pollURL.RawQuery = query.Encode()
+
+		resp, err := c.poll(ctx, httpClient, pollURL.String(), version, privateKey)
+		if err != nil {
+			return err
+		}
+		reconnectBackoff.Reset()
+		if !ready {
+			ready = true
+			if err := c.processClientEventWithRetry(ctx, clientSSEEvent{Event: "ready"}, privateKey, state); err != nil {
+				return err
+			}
+		}
+		if resp.Gap != nil {
+			if err := c.processClientEventWithRetry(ctx, clientSSEEvent{Event: "gosmee-gap", Data: *resp.Gap}, privateKey, state); err != nil {
+				return err
+			}
+		}
+		for _, event := range resp.Events {
+			data := []byte(event.Data)
+			var quoted string
+			if json.Unmarshal(event.Data, &quoted) == nil {
+				data = []byte(quoted)
+			}
+			if err := c.processClientEventWithRetry(ctx, clientSSEEvent{ID: event.ID, Data: data}, privateKey, state); err != nil {
+				return err
+			}
+		}
+		cursor = resp.Next
+	}
+}
+
+func (c goSmee) poll(ctx context.Context, httpClient *http.Client, pollURL, version string, privateKey *[32]byte) (pollResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, pollClientTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pollURL, nil)
+	if err != nil {
+		return pollResponse{}, fmt.Errorf("create poll request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("gosmee/%s", version))
+	if len(c.replayDataOpts.sseEncodings) > 0 {
+		req.Header.Set(acceptEncodingHeader, strings.Join(c.replayDataOpts.sseEncodings, ", "))
+	}
+	if err := c.replayDataOpts.relayAuth.Apply(req.Header); err != nil {
+		return pollResponse{}, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return pollResponse{}, fmt.Errorf("poll events: %w", err)
+	}
+	defer resp.Body.Close()
+	if privateKey != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden) {
+		return pollResponse{}, fmt.Errorf("%w: poll endpoint returned %s", errSubscriptionRejected, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return pollResponse{}, fmt.Errorf("poll endpoint returned %s", resp.Status)
+	}
+	var body pollResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPollResponseLen)).Decode(&body); err != nil {
+		return pollResponse{}, fmt.Errorf("decode poll response: %w", err)
+	}
+	if body.Next == "" {
+		return pollResponse{}, errors.New("poll response without a next cursor")
+	}
+	return body, nil
+}
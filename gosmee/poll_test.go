@@ -0,0 +1,256 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"gotest.tools/v3/assert"
+)
+
+func getTestPoll(t *testing.T, url string) (int, pollResponse) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	assert.NilError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	var body pollResponse
+	if resp.StatusCode == http.StatusOK {
+		assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(&body))
+	}
+	return resp.StatusCode, body
+}
+
+func TestParsePollRequest(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    pollRequest
+		wantErr string
+	}{
+		{query: "", want: pollRequest{wait: pollDefaultWait, max: pollDefaultMax}},
+		{query: "after=1-0&wait=5s&max=10", want: pollRequest{after: "1-0", wait: 5 * time.Second, max: 10}},
+		{query: "wait=15", want: pollRequest{wait: 15 * time.Second, max: pollDefaultMax}},
+		{query: "wait=1h&max=100000", want: pollRequest{wait: pollMaxWait, max: pollMaxEvents}},
+		{query: "wait=0", want: pollRequest{max: pollDefaultMax}},
+		{query: "wait=soon", wantErr: `invalid wait "soon"`},
+		{query: "max=0", wantErr: `invalid max "0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/poll/test-channel?"+tt.query, nil)
+			got, err := parsePollRequest(req)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestHandlePollGet(t *testing.T) {
+	eventBroker := NewEventBroker()
+	sessions := newPollSessions(eventBroker)
+	allowedKey := mustGeneratePublicKey(t)
+	protectedChannels := mustProtectedChannels(t, map[string][]string{
+		"test-channel": {allowedKey},
+	})
+	router := chi.NewRouter()
+	router.Get(pollPath, handlePollGet(sessions, protectedChannels, "*"))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("protected channel without an allowed key", func(t *testing.T) {
+		status, _ := getTestPoll(t, server.URL+"/poll/test-channel?wait=0")
+		assert.Equal(t, status, http.StatusNotFound)
+	})
+
+	t.Run("events are queued between polls", func(t *testing.T) {
+		status, resp := getTestPoll(t, server.URL+"/poll/plain-channel?wait=0")
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, len(resp.Events), 0)
+		assert.Assert(t, resp.Gap == nil)
+		cursor := resp.Next
+
+		eventBroker.Publish("plain-channel", []byte(`{"first":true}`))
+		eventBroker.Publish("plain-channel", []byte(`{"second":true}`))
+		eventBroker.Publish("plain-channel", []byte(`{"third":true}`))
+		status, resp = getTestPoll(t, server.URL+"/poll/plain-channel?wait=1s&max=2&after="+cursor)
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, resp.Next, cursor)
+		assert.Equal(t, len(resp.Events), 2)
+		assert.Equal(t, string(resp.Events[0].Data), `{"first":true}`)
+		assert.Equal(t, string(resp.Events[1].Data), `{"second":true}`)
+
+		status, resp = getTestPoll(t, server.URL+"/poll/plain-channel?wait=1s&after="+cursor)
+		assert.Equal(t, status, http.StatusOK)
+		assert.Equal(t, len(resp.Events), 1)
+		assert.Equal(t, string(resp.Events[0].Data), `{"third":true}`)
+	})
+
+	t.Run("unknown sessions report a gap", func(t *testing.T) {
+		status, resp := getTestPoll(t, server.URL+"/poll/plain-channel?wait=0&after=session-gone")
+		assert.Equal(t, status, http.StatusOK)
+		assert.Assert(t, resp.Gap != nil)
+		assert.Assert(t, resp.Next != "session-gone")
+		var gap map[string]string
+		assert.NilError(t, json.Unmarshal(*resp.Gap, &gap))
+		assert.Equal(t, gap["error"], "poll_session_expired")
+	})
+
+	t.Run("idle sessions expire", func(t *testing.T) {
+		now := time.Now()
+		sessions.now = func() time.Time { return now.Add(pollSessionTTL + time.Second) }
+		defer func() { sessions.now = time.Now }()
+		sessions.expire()
+		sessions.mu.Lock()
+		defer sessions.mu.Unlock()
+		assert.Equal(t, len(sessions.sessions), 0)
+		eventBroker.RLock()
+		defer eventBroker.RUnlock()
+		assert.Equal(t, len(eventBroker.subscribers["plain-channel"]), 0)
+	})
+}
+
+func TestPollSessionsLimits(t *testing.T) {
+	eventBroker := NewEventBroker()
+	sessions := newPollSessions(eventBroker)
+	now := time.Now()
+	sessions.now = func() time.Time { return now }
+
+	t.Run("sessions never polled again expire sooner", func(t *testing.T) {
+		id, session, _ := sessions.session("", "test-channel", nil)
+		sessions.done(session)
+		resumedID, resumed, _ := sessions.session("", "test-channel", nil)
+		sessions.done(resumed)
+		_, resumed, _ = sessions.session(resumedID, "test-channel", nil)
+		sessions.done(resumed)
+		polling, _, _ := sessions.session("", "test-channel", nil)
+
+		sessions.now = func() time.Time { return now.Add(pollNewSessionTTL + time.Second) }
+		sessions.expire()
+		_, kept := sessions.sessions[id]
+		assert.Assert(t, !kept)
+		_, kept = sessions.sessions[resumedID]
+		assert.Assert(t, kept)
+		_, kept = sessions.sessions[polling]
+		assert.Assert(t, kept)
+	})
+
+	t.Run("a channel keeps its most recently polled sessions", func(t *testing.T) {
+		sessions := newPollSessions(eventBroker)
+		start := time.Now()
+		ids := []string{}
+		for i := range pollMaxChannelSessions + 1 {
+			sessions.now = func() time.Time { return start.Add(time.Duration(i) * time.Second) }
+			id, session, _ := sessions.session("", "busy-channel", nil)
+			sessions.done(session)
+			ids = append(ids, id)
+		}
+		assert.Equal(t, sessions.count("busy-channel"), pollMaxChannelSessions)
+		_, kept := sessions.sessions[ids[0]]
+		assert.Assert(t, !kept)
+		_, kept = sessions.sessions[ids[len(ids)-1]]
+		assert.Assert(t, kept)
+	})
+}
+
+func TestHandleRedisPollGet(t *testing.T) {
+	protectedChannels, err := LoadProtectedChannels("")
+	assert.NilError(t, err)
+
+	newServer := func(client *fakeRedisStreamClient) string {
+		relay := newRedisPayloadRelayWithClient(client, 10000)
+		router := chi.NewRouter()
+		router.Get(pollPath, handleRedisPollGet(relay, protectedChannels, "*", slog.New(slog.DiscardHandler)))
+		server := httptest.NewServer(router)
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	t.Run("returns the events after the cursor and reports gaps", func(t *testing.T) {
+		client := &fakeRedisStreamClient{
+			xrangeMessages: []redis.XMessage{{ID: "1700000000005-0"}},
+			xreadResults: [][]redis.XStream{{{
+				Stream: "gosmee:stream:test-channel",
+				Messages: []redis.XMessage{
+					{ID: "1700000000005-0", Values: map[string]any{"payload": `{"newer":true}`}},
+					{ID: "1700000000006-0", Values: map[string]any{"payload": `{"newest":true}`}},
+				},
+			}}},
+		}
+		serverURL := newServer(client)
+		status, resp := getTestPoll(t, serverURL+"/poll/test-channel?after=1700000000001-0&max=10")
+		assert.Equal(t, status, http.StatusOK)
+		assert.Assert(t, resp.Gap != nil)
+		assert.Assert(t, json.Valid(*resp.Gap))
+		assert.Equal(t, len(resp.Events), 2)
+		assert.Equal(t, resp.Events[0].ID, "1700000000005-0")
+		assert.Equal(t, string(resp.Events[1].Data), `{"newest":true}`)
+		assert.Equal(t, resp.Next, "1700000000006-0")
+
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		assert.DeepEqual(t, client.xreadStreams[0], []string{"gosmee:stream:test-channel", "0-0"})
+	})
+
+	t.Run("malformed cursor", func(t *testing.T) {
+		status, _ := getTestPoll(t, newServer(&fakeRedisStreamClient{})+"/poll/test-channel?after=nope")
+		assert.Equal(t, status, http.StatusBadRequest)
+	})
+}
+
+func TestRunSSEClientPoll(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	client := &fakeRedisStreamClient{
+		xreadResults: [][]redis.XStream{{{
+			Stream: "gosmee:stream:test-channel",
+			Messages: []redis.XMessage{{
+				ID:     "1700000000001-0",
+				Values: map[string]any{"payload": simpleJSON},
+			}},
+		}}},
+	}
+	relay := newRedisPayloadRelayWithClient(client, 10000)
+	protectedChannels, err := LoadProtectedChannels("")
+	assert.NilError(t, err)
+	router := chi.NewRouter()
+	router.Get(pollPath, handleRedisPollGet(relay, protectedChannels, "*", slog.New(slog.DiscardHandler)))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statePath := filepath.Join(t.TempDir(), "resume.state")
+	checkpointed := func() bool {
+		data, err := os.ReadFile(statePath)
+		return err == nil && string(data) == "1700000000001-0\n"
+	}
+	go func() {
+		if eventually(t, checkpointed) {
+			cancel()
+		}
+	}()
+
+	_, sseURL, _, err := prepareSubscription(server.URL+"/test-channel", "", nil)
+	assert.NilError(t, err)
+	gs := newTestGoSmeeForProcessing(&replayDataOpts{targetURL: target.URL, transport: transportPoll, resumeStateFile: statePath})
+	err = gs.runSSEClient(ctx, []sseSubscription{{url: sseURL}}, "dev")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Assert(t, checkpointed())
+}
@@ -1,71 +1,217 @@
 package gosmee
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// protectedChannelsReloadInterval is how often the encrypted channels file
+// is checked for changes.
+const protectedChannelsReloadInterval = 5 * time.Second
+
 type protectedChannelsFile struct {
 	Channels map[string]protectedChannelConfig `json:"channels"`
 }
 
 type protectedChannelConfig struct {
-	AllowedPublicKeys []string `json:"allowed_public_keys"`
+	AllowedPublicKeys []string              `json:"allowed_public_keys"`
+	Keys              []protectedChannelKey `json:"keys,omitempty"`
+	// AdminTokenSHA256 is the hex SHA-256 of the token allowed to enroll
+	// and revoke keys with the enrollment API.
+	AdminTokenSHA256 string `json:"admin_token_sha256,omitempty"`
+	// EnrolledKeys are the keys enrolled with the API, when they are not
+	// kept in Redis.
+	EnrolledKeys []protectedChannelKey `json:"enrolled_keys,omitempty"`
+}
+
+// protectedChannelKey is an allowed public key with an optional expiry,
+// after which subscriptions with it are refused and closed.
+type protectedChannelKey struct {
+	PublicKey string     `json:"public_key"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type ProtectedChannels struct {
-	channels map[string]map[string]struct{}
+	mu          sync.RWMutex
+	path        string
+	modTime     time.Time
+	size        int64
+	now         func() time.Time
+	channels    map[string]map[string]time.Time
+	adminTokens map[string][]byte
+	// registry keeps the keys enrolled with the API, enrolled caches the
+	// ones it does not write to the channels file.
+	registry keyRegistry
+	enrolled map[string]map[string]time.Time
 }
 
 func LoadProtectedChannels(path string) (*ProtectedChannels, error) {
+	protected := &ProtectedChannels{
+		path:     path,
+		now:      time.Now,
+		channels: make(map[string]map[string]time.Time),
+		enrolled: make(map[string]map[string]time.Time),
+	}
 	if path == "" {
-		return &ProtectedChannels{
-			channels: make(map[string]map[string]struct{}),
-		}, nil
+		return protected, nil
 	}
-
-	data, err := os.ReadFile(path)
-	if err != nil {
+	protected.registry = &fileKeyRegistry{channels: protected}
+	if err := protected.Reload(); err != nil {
 		return nil, err
 	}
+	return protected, nil
+}
+
+// allowedKeys maps the encoded public keys to their expiry, a zero time
+// never expires.
+func allowedKeys(channel string, keys []protectedChannelKey) (map[string]time.Time, error) {
+	allowed := make(map[string]time.Time, len(keys))
+	for _, key := range keys {
+		publicKey, err := ParsePublicKey(key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key for channel %q: %w", channel, err)
+		}
+		var expires time.Time
+		if key.ExpiresAt != nil {
+			expires = *key.ExpiresAt
+		}
+		allowed[EncodePublicKey(publicKey)] = expires
+	}
+	return allowed, nil
+}
 
+func parseProtectedChannels(data []byte) (map[string]map[string]time.Time, map[string][]byte, error) {
 	var cfg protectedChannelsFile
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("unmarshal encrypted channels file: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal encrypted channels file: %w", err)
 	}
 	if len(cfg.Channels) == 0 {
-		return nil, fmt.Errorf("encrypted channels file must define at least one channel")
-	}
-
-	protected := &ProtectedChannels{
-		channels: make(map[string]map[string]struct{}, len(cfg.Channels)),
+		return nil, nil, fmt.Errorf("encrypted channels file must define at least one channel")
 	}
 
+	channels := make(map[string]map[string]time.Time, len(cfg.Channels))
+	adminTokens := make(map[string][]byte)
 	for channel, channelCfg := range cfg.Channels {
 		if channel == "" {
-			return nil, fmt.Errorf("encrypted channels file contains an empty channel id")
+			return nil, nil, fmt.Errorf("encrypted channels file contains an empty channel id")
 		}
 		if !isValidChannelID(channel) {
-			return nil, fmt.Errorf("encrypted channel %q must match %q", channel, channelIDPattern)
-		}
-		if len(channelCfg.AllowedPublicKeys) == 0 {
-			return nil, fmt.Errorf("encrypted channel %q must define at least one allowed public key", channel)
+			return nil, nil, fmt.Errorf("encrypted channel %q must match %q", channel, channelIDPattern)
 		}
-
-		allowed := make(map[string]struct{}, len(channelCfg.AllowedPublicKeys))
+		keys := make([]protectedChannelKey, 0, len(channelCfg.AllowedPublicKeys)+len(channelCfg.Keys)+len(channelCfg.EnrolledKeys))
 		for _, encodedKey := range channelCfg.AllowedPublicKeys {
-			publicKey, err := ParsePublicKey(encodedKey)
-			if err != nil {
-				return nil, fmt.Errorf("invalid public key for channel %q: %w", channel, err)
+			keys = append(keys, protectedChannelKey{PublicKey: encodedKey})
+		}
+		keys = append(keys, channelCfg.Keys...)
+		keys = append(keys, channelCfg.EnrolledKeys...)
+		if channelCfg.AdminTokenSHA256 != "" {
+			tokenHash, err := hex.DecodeString(channelCfg.AdminTokenSHA256)
+			if err != nil || len(tokenHash) != sha256.Size {
+				return nil, nil, fmt.Errorf("admin_token_sha256 of channel %q must be a hex SHA-256", channel)
 			}
-			allowed[EncodePublicKey(publicKey)] = struct{}{}
+			adminTokens[channel] = tokenHash
+		} else if len(keys) == 0 {
+			return nil, nil, fmt.Errorf("encrypted channel %q must define at least one allowed public key or an admin token", channel)
 		}
 
-		protected.channels[channel] = allowed
+		allowed, err := allowedKeys(channel, keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		channels[channel] = allowed
 	}
+	return channels, adminTokens, nil
+}
 
-	return protected, nil
+// Reload reads the encrypted channels file again. The previous channels are
+// kept when it is invalid.
+func (p *ProtectedChannels) Reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	channels, adminTokens, err := parseProtectedChannels(data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.channels = channels
+	p.adminTokens = adminTokens
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	return nil
+}
+
+// changed tells whether the file was modified since the last reload.
+func (p *ProtectedChannels) changed() bool {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !info.ModTime().Equal(p.modTime) || info.Size() != p.size
+}
+
+// Watch reloads the encrypted channels file on SIGHUP, or when it changes,
+// until ctx is done.
+func (p *ProtectedChannels) Watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	if p == nil || p.path == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reason := ""
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reason = "SIGHUP"
+		case <-ticker.C:
+			if err := p.refreshEnrolled(ctx); err != nil {
+				logger.LogAttrs(ctx, slog.LevelWarn, "refreshing enrolled keys failed",
+					slog.String("error", err.Error()))
+			}
+			if !p.changed() {
+				continue
+			}
+			reason = "file change"
+		}
+		if err := p.Reload(); err != nil {
+			logger.LogAttrs(ctx, slog.LevelError, "reloading encrypted channels file failed, keeping the previous channels",
+				slog.String("path", p.path), slog.String("reason", reason), slog.String("error", err.Error()))
+			continue
+		}
+		logger.LogAttrs(ctx, slog.LevelInfo, "reloaded encrypted channels file",
+			slog.String("path", p.path), slog.String("reason", reason), slog.Int("channels", p.count()))
+	}
+}
+
+func (p *ProtectedChannels) count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.channels)
 }
 
 func (p *ProtectedChannels) Has(channel string) bool {
@@ -73,6 +219,8 @@ func (p *ProtectedChannels) Has(channel string) bool {
 		return false
 	}
 
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	_, ok := p.channels[channel]
 	return ok
 }
@@ -82,11 +230,34 @@ func (p *ProtectedChannels) IsAllowed(channel string, publicKey *[32]byte) bool
 		return false
 	}
 
-	allowedKeys, ok := p.channels[channel]
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	allowed, ok := p.channels[channel]
 	if !ok {
 		return false
 	}
 
-	_, ok = allowedKeys[EncodePublicKey(publicKey)]
-	return ok
+	encoded := EncodePublicKey(publicKey)
+	expires, ok := allowed[encoded]
+	if !ok {
+		if expires, ok = p.enrolled[channel][encoded]; !ok {
+			return false
+		}
+	}
+	return expires.IsZero() || p.now().Before(expires)
+}
+
+// StillAuthorized tells whether a subscriber of channel, with publicKey or
+// none, may keep receiving its events after a reload or a key expiry.
+func (p *ProtectedChannels) StillAuthorized(channel string, publicKey *[32]byte) bool {
+	if !p.Has(channel) {
+		return true
+	}
+	return p.IsAllowed(channel, publicKey)
+}
+
+// PublicKeyFingerprint identifies a public key in logs, like ssh does.
+func PublicKeyFingerprint(key *[32]byte) string {
+	sum := sha256.Sum256(key[:])
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
 }
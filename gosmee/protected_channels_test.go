@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"gotest.tools/v3/assert"
 )
@@ -81,3 +82,87 @@ func TestLoadProtectedChannels(t *testing.T) {
 		assert.ErrorContains(t, err, `must match`)
 	})
 }
+
+func writeProtectedChannelsFile(t *testing.T, path string, cfg protectedChannelsFile) {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestProtectedChannelKeyExpiry(t *testing.T) {
+	current, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	next, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "channels.json")
+	writeProtectedChannelsFile(t, path, protectedChannelsFile{
+		Channels: map[string]protectedChannelConfig{
+			"test-channel": {
+				Keys: []protectedChannelKey{
+					{PublicKey: EncodePublicKey(current), ExpiresAt: &expiresAt},
+					{PublicKey: EncodePublicKey(next)},
+				},
+			},
+		},
+	})
+
+	protectedChannels, err := LoadProtectedChannels(path)
+	assert.NilError(t, err)
+	protectedChannels.now = func() time.Time { return expiresAt.Add(-time.Minute) }
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", current))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", next))
+
+	protectedChannels.now = func() time.Time { return expiresAt }
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", current))
+	assert.Assert(t, !protectedChannels.StillAuthorized("test-channel", current))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", next))
+	// unprotected channels keep their subscribers
+	assert.Assert(t, protectedChannels.StillAuthorized("plain-channel", nil))
+}
+
+func TestProtectedChannelsReload(t *testing.T) {
+	current, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	next, _, err := GenerateKeyPair()
+	assert.NilError(t, err)
+
+	path := filepath.Join(t.TempDir(), "channels.json")
+	channelWith := func(keys ...*[32]byte) protectedChannelsFile {
+		cfg := protectedChannelConfig{}
+		for _, key := range keys {
+			cfg.AllowedPublicKeys = append(cfg.AllowedPublicKeys, EncodePublicKey(key))
+		}
+		return protectedChannelsFile{Channels: map[string]protectedChannelConfig{"test-channel": cfg}}
+	}
+	writeProtectedChannelsFile(t, path, channelWith(current))
+
+	protectedChannels, err := LoadProtectedChannels(path)
+	assert.NilError(t, err)
+	assert.Assert(t, !protectedChannels.changed())
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", next))
+
+	writeProtectedChannelsFile(t, path, channelWith(current, next))
+	assert.Assert(t, protectedChannels.changed())
+	assert.NilError(t, protectedChannels.Reload())
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", current))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", next))
+
+	// an invalid file keeps the previous channels
+	assert.NilError(t, os.WriteFile(path, []byte("{"), 0o600))
+	assert.ErrorContains(t, protectedChannels.Reload(), "unmarshal encrypted channels file")
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", next))
+
+	writeProtectedChannelsFile(t, path, channelWith(next))
+	assert.NilError(t, protectedChannels.Reload())
+	assert.Assert(t, !protectedChannels.IsAllowed("test-channel", current))
+	assert.Assert(t, protectedChannels.IsAllowed("test-channel", next))
+}
+
+func TestPublicKeyFingerprint(t *testing.T) {
+	var key [32]byte
+	fingerprint := PublicKeyFingerprint(&key)
+	assert.Equal(t, fingerprint, "SHA256:Zmh6rfhivXdsj8GLjp+OIAiXFIVu4jOzkCpZHQ1fKSU")
+}
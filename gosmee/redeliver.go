@@ -0,0 +1,116 @@
+package gosmee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/mgutz/ansi"
+	"github.com/urfave/cli/v2"
+)
+
+const defaultRedeliverConcurrency = 4
+
+// redeliverOpts configures asking GitHub to resend deliveries to the hook
+// URL itself instead of forwarding them to a local target.
+type redeliverOpts struct {
+	dryRun      bool
+	concurrency int
+	out         io.Writer
+}
+
+// redeliverHooks asks GitHub to redeliver the deliveries of hookid matching
+// the time window and filters, oldest first, with at most concurrency
+// requests in flight. With dryRun it only lists them, in the --output
+// format.
+func (r *replayOpts) redeliverHooks(ctx context.Context, hookid int64, opts redeliverOpts) error {
+	deliveries, err := r.listHookDeliveriesSince(ctx, hookid, r.sinceTime)
+	if err != nil {
+		return fmt.Errorf("cannot list deliveries: %w", err)
+	}
+	selected := []*github.HookDelivery{}
+	for _, d := range r.chooseDeliveries(deliveries) {
+		if !r.untilTime.IsZero() && d.GetDeliveredAt().After(r.untilTime) {
+			break
+		}
+		if !r.filter.match(d) {
+			r.summary.skipped++
+			continue
+		}
+		selected = append(selected, d)
+	}
+
+	if opts.dryRun {
+		r.logger.InfoContext(ctx, fmt.Sprintf("%d deliveries would be redelivered, %d skipped", len(selected), r.summary.skipped))
+		if r.structuredOutput() {
+			return writeStructuredList(opts.out, r.output, selected)
+		}
+		fmt.Fprint(opts.out, ansi.Color(fmt.Sprintf("%-12s %-38s %-20s %-6s %s\n", "ID", "GUID", "Event", "Status", "Delivered At"), "cyan+b")) // nolint:staticcheck
+		for _, d := range selected {
+			fmt.Fprintf(opts.out, "%-12d %-38s %-20s %-6d %s\n", d.GetID(), d.GetGUID(), d.GetEvent(), d.GetStatusCode(), d.GetDeliveredAt().Format(userTSFormat))
+		}
+		return nil
+	}
+
+	concurrency := max(opts.concurrency, 1)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, d := range selected {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(d *github.HookDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := r.callGitHub(ctx, "redeliver hook delivery", func() (*github.Response, error) {
+				_, resp, err := r.history.RedeliverHookDelivery(ctx, r.org, r.repo, hookid, d.GetID())
+				// GitHub answers 202 Accepted, which go-github reports as an
+				// AcceptedError while the redelivery is queued
+				var accepted *github.AcceptedError
+				if errors.As(err, &accepted) {
+					err = nil
+				}
+				return resp, err
+			})
+			attrs := []slog.Attr{
+				slog.Int64("id", d.GetID()),
+				slog.String("delivery_id", d.GetGUID()),
+				slog.String("event_type", d.GetEvent()),
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				r.summary.failed++
+				r.logger.LogAttrs(ctx, slog.LevelError, "GitHub redelivery failed", append(attrs, slog.String("error", err.Error()))...)
+				return
+			}
+			r.summary.replayed++
+			r.logger.LogAttrs(ctx, slog.LevelInfo, fmt.Sprintf("delivery %s redelivered by GitHub", d.GetGUID()), attrs...)
+		}(d)
+	}
+	wg.Wait()
+
+	r.logger.LogAttrs(ctx, slog.LevelInfo,
+		fmt.Sprintf("%d deliveries redelivered, %d failed, %d skipped", r.summary.replayed, r.summary.failed, r.summary.skipped),
+		slog.Int("redelivered", r.summary.replayed), slog.Int("failed", r.summary.failed), slog.Int("skipped", r.summary.skipped))
+	if r.summary.failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d deliveries failed to be redelivered", r.summary.failed), 1)
+	}
+	return nil
+}
+
+// hasRedeliverSelection tells whether deliveries to redeliver were selected,
+// redelivering every delivery GitHub still has is never what is wanted.
+func hasRedeliverSelection(c *cli.Context) bool {
+	return slices.ContainsFunc([]string{"time-since", "until", "status", "event-type", "action", "delivery-id"}, c.IsSet)
+}
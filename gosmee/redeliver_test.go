@@ -0,0 +1,117 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+	"gotest.tools/v3/assert"
+)
+
+func redeliverTestDeliveries(now time.Time) []*github.HookDelivery {
+	deliveries := []*github.HookDelivery{}
+	for i, status := range []int{502, 200, 500, 0, 200} {
+		deliveries = append(deliveries, &github.HookDelivery{
+			ID:          github.Int64(int64(100 + i)),
+			GUID:        github.String("guid-" + string(rune('a'+i))),
+			StatusCode:  github.Int(status),
+			Event:       github.String("push"),
+			DeliveredAt: &github.Timestamp{Time: now.Add(-time.Duration(i) * time.Minute)},
+		})
+	}
+	return deliveries
+}
+
+func TestRedeliverHooks(t *testing.T) {
+	now := time.Now()
+	ghop := &mockGHOp{deliveries: redeliverTestDeliveries(now)}
+	r := &replayOpts{
+		history:   ghop,
+		logger:    slog.New(slog.DiscardHandler),
+		sinceTime: now.Add(-time.Hour),
+		filter:    deliveryFilter{statuses: []string{"failed"}},
+	}
+	assert.NilError(t, r.redeliverHooks(context.Background(), 1, redeliverOpts{concurrency: 2}))
+	slices.Sort(ghop.redelivered)
+	assert.DeepEqual(t, ghop.redelivered, []int64{100, 102, 103})
+	assert.Equal(t, r.summary, replaySummary{replayed: 3, skipped: 2})
+}
+
+func TestRedeliverHooksDryRun(t *testing.T) {
+	now := time.Now()
+	ghop := &mockGHOp{deliveries: redeliverTestDeliveries(now)}
+	var out bytes.Buffer
+	r := &replayOpts{
+		history:   ghop,
+		logger:    slog.New(slog.DiscardHandler),
+		sinceTime: now.Add(-150 * time.Second),
+		filter:    deliveryFilter{deliveryIDs: []string{"guid-a", "101", "guid-e"}},
+	}
+	assert.NilError(t, r.redeliverHooks(context.Background(), 1, redeliverOpts{dryRun: true, out: &out}))
+	assert.Equal(t, len(ghop.redelivered), 0)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// guid-e is older than the time window
+	assert.Equal(t, len(lines), 3)
+	// oldest first
+	assert.Assert(t, strings.Contains(lines[1], "guid-b"))
+	assert.Assert(t, strings.Contains(lines[2], "guid-a"))
+}
+
+func TestRedeliverHooksDryRunStructured(t *testing.T) {
+	now := time.Now()
+	ghop := &mockGHOp{deliveries: redeliverTestDeliveries(now)}
+	var out bytes.Buffer
+	r := &replayOpts{
+		history:   ghop,
+		logger:    slog.New(slog.DiscardHandler),
+		sinceTime: now.Add(-time.Hour),
+		filter:    deliveryFilter{deliveryIDs: []string{"guid-a", "guid-b"}},
+		output:    "json",
+	}
+	assert.NilError(t, r.redeliverHooks(context.Background(), 1, redeliverOpts{dryRun: true, out: &out}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+	var d github.HookDelivery
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &d))
+	assert.Equal(t, d.GetGUID(), "guid-b")
+
+	out.Reset()
+	r.output = "yaml"
+	assert.NilError(t, r.redeliverHooks(context.Background(), 1, redeliverOpts{dryRun: true, out: &out}))
+	assert.Assert(t, strings.HasPrefix(out.String(), "- "), out.String())
+	assert.Assert(t, strings.Contains(out.String(), "guid: guid-a"), out.String())
+	assert.Equal(t, len(ghop.redelivered), 0)
+}
+
+func TestRedeliverHooksFailure(t *testing.T) {
+	now := time.Now()
+	ghop := &mockGHOp{deliveries: redeliverTestDeliveries(now)}
+	r := &replayOpts{
+		history:   ghop,
+		logger:    slog.New(slog.DiscardHandler),
+		sinceTime: now.Add(-time.Hour),
+	}
+	// list the deliveries, then fail the redeliveries
+	deliveries, err := r.listHookDeliveriesSince(context.Background(), 1, r.sinceTime)
+	assert.NilError(t, err)
+	ghop.deliveries = deliveries
+	failing := &failingRedeliverGHOp{mockGHOp: ghop}
+	r.history = failing
+	err = r.redeliverHooks(context.Background(), 1, redeliverOpts{concurrency: 3})
+	assert.ErrorContains(t, err, "5 deliveries failed to be redelivered")
+}
+
+type failingRedeliverGHOp struct {
+	*mockGHOp
+}
+
+func (m *failingRedeliverGHOp) RedeliverHookDelivery(_ context.Context, _, _ string, _, _ int64) (*github.HookDelivery, *github.Response, error) {
+	return nil, &github.Response{}, errors.New("hook is disabled")
+}
@@ -0,0 +1,212 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Stream entries of protected channels can be encrypted at rest. Their
+// payload field is then the base64 of a secretbox nonce and box, and two
+// fields describe it:
+//
+//	enc: the format version, entries without it are plaintext
+//	kid: the key the box was sealed with, the ID of the server data key, or
+//	     "channel:" and the ID of the server data key for a channel data key
+//	     wrapped with it
+const (
+	redisStreamEncodingField = "enc"
+	redisStreamKeyIDField    = "kid"
+	redisAtRestVersion       = "1"
+	redisChannelKeyIDPrefix  = "channel:"
+	redisChannelDataKeys     = "gosmee:datakeys"
+)
+
+type redisDataKeyClient interface {
+	HSetNX(ctx context.Context, key, field string, value any) *redis.BoolCmd
+	HGet(ctx context.Context, key, field string) *redis.StringCmd
+}
+
+// redisAtRest encrypts the stream entries of the channels encrypt is true
+// for, with the server data key or with a data key per channel.
+type redisAtRest struct {
+	key        *[32]byte
+	keyID      string
+	perChannel bool
+	client     redisDataKeyClient
+	encrypt    func(channel string) bool
+
+	mu          sync.Mutex
+	channelKeys map[string]*[32]byte
+}
+
+// loadRedisDataKey reads a base64 encoded 32 bytes key, as made by
+// openssl rand -base64 32.
+func loadRedisDataKey(path string) (*[32]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode redis data key: %w", err)
+	}
+	key, err := bytesToKey(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("redis data key: %w", err)
+	}
+	return key, nil
+}
+
+func newRedisAtRest(key *[32]byte, perChannel bool, client redisDataKeyClient, encrypt func(channel string) bool) *redisAtRest {
+	sum := sha256.Sum256(key[:])
+	return &redisAtRest{
+		key:         key,
+		keyID:       hex.EncodeToString(sum[:8]),
+		perChannel:  perChannel,
+		client:      client,
+		encrypt:     encrypt,
+		channelKeys: make(map[string]*[32]byte),
+	}
+}
+
+func sealSecretbox(plaintext []byte, key *[32]byte) (string, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(secretbox.Seal(nonce[:], plaintext, &nonce, key)), nil
+}
+
+func openSecretbox(sealed string, key *[32]byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 24+secretbox.Overhead {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], data[:24])
+	plaintext, ok := secretbox.Open(nil, data[24:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	return plaintext, nil
+}
+
+// channelKey returns the data key of channel, made and stored wrapped with
+// the server data key the first time. HSETNX makes servers racing to create
+// it agree on one.
+func (a *redisAtRest) channelKey(ctx context.Context, channel string) (*[32]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if key, ok := a.channelKeys[channel]; ok {
+		return key, nil
+	}
+	if a.client == nil {
+		return nil, fmt.Errorf("channel data keys are not available with this redis client")
+	}
+
+	wrapped, err := a.client.HGet(ctx, redisChannelDataKeys, channel).Result()
+	if errors.Is(err, redis.Nil) {
+		if err := a.createChannelKey(ctx, channel); err != nil {
+			return nil, err
+		}
+		wrapped, err = a.client.HGet(ctx, redisChannelDataKeys, channel).Result()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read channel data key: %w", err)
+	}
+	unwrapped, err := openSecretbox(wrapped, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key of channel %s, is it the server data key it was made with? %w", channel, err)
+	}
+	key, err := bytesToKey(unwrapped)
+	if err != nil {
+		return nil, err
+	}
+	a.channelKeys[channel] = key
+	return key, nil
+}
+
+func (a *redisAtRest) createChannelKey(ctx context.Context, channel string) error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	sealed, err := sealSecretbox(key[:], a.key)
+	if err != nil {
+		return err
+	}
+	if err := a.client.HSetNX(ctx, redisChannelDataKeys, channel, sealed).Err(); err != nil {
+		return fmt.Errorf("store channel data key: %w", err)
+	}
+	return nil
+}
+
+// seal returns the stream entry fields of data.
+func (a *redisAtRest) seal(ctx context.Context, channel string, data []byte) (map[string]any, error) {
+	if a == nil || !a.encrypt(channel) {
+		return map[string]any{redisStreamPayloadField: string(data)}, nil
+	}
+	key, keyID := a.key, a.keyID
+	if a.perChannel {
+		var err error
+		if key, err = a.channelKey(ctx, channel); err != nil {
+			return nil, err
+		}
+		keyID = redisChannelKeyIDPrefix + a.keyID
+	}
+	sealed, err := sealSecretbox(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		redisStreamPayloadField:  sealed,
+		redisStreamEncodingField: redisAtRestVersion,
+		redisStreamKeyIDField:    keyID,
+	}, nil
+}
+
+// open returns the payload of a stream entry, plaintext entries written
+// before at-rest encryption was enabled are returned as they are.
+func (a *redisAtRest) open(ctx context.Context, channel string, values map[string]any, payload []byte) ([]byte, error) {
+	version, ok := values[redisStreamEncodingField]
+	if !ok {
+		return payload, nil
+	}
+	if version != redisAtRestVersion {
+		return nil, fmt.Errorf("unsupported at-rest encryption version %v", version)
+	}
+	if a == nil {
+		return nil, fmt.Errorf("entry is encrypted at rest, --redis-data-key-file is needed to read it")
+	}
+	keyID, _ := values[redisStreamKeyIDField].(string)
+	key := a.key
+	switch keyID {
+	case a.keyID:
+	case redisChannelKeyIDPrefix + a.keyID:
+		var err error
+		if key, err = a.channelKey(ctx, channel); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("entry is encrypted with key %q, not with the server data key %s", keyID, a.keyID)
+	}
+	plaintext, err := openSecretbox(string(payload), key)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt entry: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,151 @@
+package gosmee
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"gotest.tools/v3/assert"
+)
+
+type fakeRedisDataKeyClient struct {
+	hash map[string]string
+}
+
+func (f *fakeRedisDataKeyClient) HSetNX(_ context.Context, _, field string, value any) *redis.BoolCmd {
+	if _, ok := f.hash[field]; ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	f.hash[field] = value.(string)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (f *fakeRedisDataKeyClient) HGet(_ context.Context, _, field string) *redis.StringCmd {
+	value, ok := f.hash[field]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+func testRedisDataKey(t *testing.T) *[32]byte {
+	t.Helper()
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	assert.NilError(t, err)
+	return &key
+}
+
+// publishAndRead writes data to channel through relay and reads the stored
+// entry back.
+func publishAndRead(t *testing.T, relay *redisPayloadRelay, channel string, data []byte) (map[string]any, []relayEvent, error) {
+	t.Helper()
+	client := relay.client.(*fakeRedisStreamClient)
+	_, err := relay.Publish(context.Background(), channel, data)
+	assert.NilError(t, err)
+	values := client.xaddArgs.Values.(map[string]any)
+	client.xreadStreams = nil
+	client.xreadResults = [][]redis.XStream{{{
+		Stream:   relay.streamKey(channel),
+		Messages: []redis.XMessage{{ID: "1-0", Values: values}},
+	}}}
+	events, err := relay.Read(context.Background(), channel, "0", 0, 10)
+	return values, events, err
+}
+
+// readAgain reads the entry stored by publishAndRead once more.
+func readAgain(relay *redisPayloadRelay, channel string) ([]relayEvent, error) {
+	client := relay.client.(*fakeRedisStreamClient)
+	client.xreadStreams = nil
+	return relay.Read(context.Background(), channel, "0", 0, 10)
+}
+
+func TestRedisAtRest(t *testing.T) {
+	data := []byte(`{"x-github-delivery":"abc","body":"secret"}`)
+	isProtected := func(channel string) bool { return channel == "protected-channel" }
+
+	t.Run("server data key", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.atRest = newRedisAtRest(testRedisDataKey(t), false, nil, isProtected)
+
+		values, events, err := publishAndRead(t, relay, "protected-channel", data)
+		assert.NilError(t, err)
+		assert.Equal(t, values[redisStreamEncodingField], redisAtRestVersion)
+		assert.Equal(t, values[redisStreamKeyIDField], relay.atRest.keyID)
+		assert.Assert(t, !strings.Contains(values[redisStreamPayloadField].(string), "secret"))
+		assert.Equal(t, len(events), 1)
+		assert.DeepEqual(t, events[0].Data, data)
+		assert.Equal(t, events[0].DeliveryID, "abc")
+
+		// other channels stay in plaintext
+		values, events, err = publishAndRead(t, relay, "public-channel", data)
+		assert.NilError(t, err)
+		_, encrypted := values[redisStreamEncodingField]
+		assert.Assert(t, !encrypted)
+		assert.DeepEqual(t, events[0].Data, data)
+	})
+
+	t.Run("channel data keys", func(t *testing.T) {
+		dataKeys := &fakeRedisDataKeyClient{hash: map[string]string{}}
+		serverKey := testRedisDataKey(t)
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.atRest = newRedisAtRest(serverKey, true, dataKeys, isProtected)
+
+		values, events, err := publishAndRead(t, relay, "protected-channel", data)
+		assert.NilError(t, err)
+		assert.Equal(t, values[redisStreamKeyIDField], redisChannelKeyIDPrefix+relay.atRest.keyID)
+		assert.Assert(t, dataKeys.hash["protected-channel"] != "")
+		assert.DeepEqual(t, events[0].Data, data)
+
+		// another server with the same data key reads the channel key back
+		other := newRedisPayloadRelayWithClient(relay.client, 0)
+		other.atRest = newRedisAtRest(serverKey, false, dataKeys, isProtected)
+		events, err = readAgain(other, "protected-channel")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, events[0].Data, data)
+	})
+
+	t.Run("existing plaintext entries stay readable", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		_, _, err := publishAndRead(t, relay, "protected-channel", data)
+		assert.NilError(t, err)
+
+		relay.atRest = newRedisAtRest(testRedisDataKey(t), false, nil, isProtected)
+		events, err := readAgain(relay, "protected-channel")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, events[0].Data, data)
+	})
+
+	t.Run("encrypted entries need the key", func(t *testing.T) {
+		relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{xaddID: "1-0"}, 0)
+		relay.atRest = newRedisAtRest(testRedisDataKey(t), false, nil, isProtected)
+		_, _, err := publishAndRead(t, relay, "protected-channel", data)
+		assert.NilError(t, err)
+
+		relay.atRest = nil
+		_, err = readAgain(relay, "protected-channel")
+		assert.ErrorContains(t, err, "--redis-data-key-file is needed")
+
+		relay.atRest = newRedisAtRest(testRedisDataKey(t), false, nil, isProtected)
+		_, err = readAgain(relay, "protected-channel")
+		assert.ErrorContains(t, err, "not with the server data key")
+	})
+}
+
+func TestLoadRedisDataKey(t *testing.T) {
+	key := testRedisDataKey(t)
+	path := filepath.Join(t.TempDir(), "data-key")
+	assert.NilError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key[:])+"\n"), 0o600))
+	loaded, err := loadRedisDataKey(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loaded, key)
+
+	assert.NilError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key[:16])), 0o600))
+	_, err = loadRedisDataKey(path)
+	assert.ErrorContains(t, err, "redis data key")
+}
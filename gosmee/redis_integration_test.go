@@ -184,4 +184,16 @@ func TestRedisStreamsIntegration(t *testing.T) {
 		assert.Assert(t, strings.Contains(body, "second"))
 		assert.Assert(t, !strings.Contains(body, `decoded-body: {"integration":"first"}`), body)
 	})
+
+	t.Run("history pages back from the newest entry", func(t *testing.T) {
+		newest, err := relayB.History(ctx, channel, "", 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(newest), 1)
+		older, err := relayB.History(ctx, channel, newest[0].ID, 10)
+		assert.NilError(t, err)
+		assert.Assert(t, len(older) > 0)
+		for _, event := range older {
+			assert.Assert(t, event.ID != newest[0].ID)
+		}
+	})
 }
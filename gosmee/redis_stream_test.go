@@ -27,6 +27,8 @@ type fakeRedisStreamClient struct {
 
 	xrevrangeMessages []redis.XMessage
 	xrevrangeErr      error
+	xrevrangeEnd      string
+	xrevrangeCount    int64
 
 	xreadStreams [][]string
 	xreadResults [][]redis.XStream
@@ -67,9 +69,10 @@ func (f *fakeRedisStreamClient) XRangeN(_ context.Context, _, _, _ string, _ int
 	return redis.NewXMessageSliceCmdResult(f.xrangeMessages, f.xrangeErr)
 }
 
-func (f *fakeRedisStreamClient) XRevRangeN(_ context.Context, _, _, _ string, _ int64) *redis.XMessageSliceCmd {
+func (f *fakeRedisStreamClient) XRevRangeN(_ context.Context, _, end, _ string, count int64) *redis.XMessageSliceCmd {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.xrevrangeEnd, f.xrevrangeCount = end, count
 	return redis.NewXMessageSliceCmdResult(f.xrevrangeMessages, f.xrevrangeErr)
 }
 
@@ -0,0 +1,114 @@
+package gosmee
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+)
+
+// relayAuth adds the --server-header and --server-token headers to the
+// requests the client makes to the gosmee server, for servers behind an
+// authenticating proxy. Values are resolved on every request, so a rotated
+// token file or header file is picked up on the next connection.
+type relayAuth struct {
+	headers   []headerTemplate
+	token     string
+	tokenFile string
+}
+
+// relayAuthFuncs are the functions of --server-header values.
+var relayAuthFuncs = template.FuncMap{
+	"env":  os.Getenv,
+	"file": readSecretFile,
+}
+
+// readSecretFile returns the content of path without its trailing newline.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func newRelayAuth(headers []string, token, tokenFile string) (*relayAuth, error) {
+	if len(headers) == 0 && token == "" && tokenFile == "" {
+		return nil, nil
+	}
+	if token != "" && tokenFile != "" {
+		return nil, fmt.Errorf("--server-token and --server-token-file are mutually exclusive")
+	}
+
+	a := &relayAuth{token: token, tokenFile: tokenFile}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --server-header %q, expected NAME=VALUE", h)
+		}
+		if (token != "" || tokenFile != "") && http.CanonicalHeaderKey(name) == "Authorization" {
+			return nil, fmt.Errorf("--server-header Authorization cannot be used with a server token")
+		}
+		tmpl, err := template.New(name).Funcs(relayAuthFuncs).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse --server-header %q: %w", name, err)
+		}
+		a.headers = append(a.headers, headerTemplate{name: name, value: tmpl})
+	}
+	if tokenFile != "" {
+		// fail at startup rather than on the first connection
+		if _, err := a.bearerToken(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func newRelayAuthFromFlags(c *cli.Context) (*relayAuth, error) {
+	return newRelayAuth(
+		c.StringSlice("server-header"),
+		c.String("server-token"),
+		c.String("server-token-file"),
+	)
+}
+
+func (a *relayAuth) bearerToken() (string, error) {
+	if a.tokenFile == "" {
+		return a.token, nil
+	}
+	token, err := readSecretFile(a.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read server token file: %w", err)
+	}
+	if token == "" {
+		return "", fmt.Errorf("server token file %s is empty", a.tokenFile)
+	}
+	return token, nil
+}
+
+// Apply sets the configured headers on header.
+func (a *relayAuth) Apply(header http.Header) error {
+	if a == nil {
+		return nil
+	}
+	for _, h := range a.headers {
+		var value bytes.Buffer
+		if err := h.value.Execute(&value, nil); err != nil {
+			return fmt.Errorf("render --server-header %q: %w", h.name, err)
+		}
+		header.Set(h.name, value.String())
+	}
+	token, err := a.bearerToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package gosmee
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNewRelayAuth(t *testing.T) {
+	auth, err := newRelayAuth(nil, "", "")
+	assert.NilError(t, err)
+	assert.Assert(t, auth == nil)
+	header := http.Header{}
+	assert.NilError(t, auth.Apply(header))
+	assert.Equal(t, len(header), 0)
+
+	_, err = newRelayAuth([]string{"NoEquals"}, "", "")
+	assert.ErrorContains(t, err, "expected NAME=VALUE")
+	_, err = newRelayAuth([]string{"X-Foo={{ .Nope"}, "", "")
+	assert.ErrorContains(t, err, "parse --server-header")
+	_, err = newRelayAuth(nil, "token", "token-file")
+	assert.ErrorContains(t, err, "mutually exclusive")
+	_, err = newRelayAuth([]string{"authorization=Basic abc"}, "token", "")
+	assert.ErrorContains(t, err, "cannot be used with a server token")
+	_, err = newRelayAuth(nil, "", filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "read server token file")
+}
+
+func TestRelayAuthApply(t *testing.T) {
+	t.Setenv("GOSMEE_TEST_CLIENT_ID", "client-id")
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	assert.NilError(t, os.WriteFile(secretFile, []byte("client-secret\n"), 0o600))
+	tokenFile := filepath.Join(dir, "token")
+	assert.NilError(t, os.WriteFile(tokenFile, []byte("first\n"), 0o600))
+
+	auth, err := newRelayAuth([]string{
+		`CF-Access-Client-Id={{ env "GOSMEE_TEST_CLIENT_ID" }}`,
+		`CF-Access-Client-Secret={{ file "` + secretFile + `" }}`,
+	}, "", tokenFile)
+	assert.NilError(t, err)
+
+	header := http.Header{}
+	assert.NilError(t, auth.Apply(header))
+	assert.Equal(t, header.Get("CF-Access-Client-Id"), "client-id")
+	assert.Equal(t, header.Get("CF-Access-Client-Secret"), "client-secret")
+	assert.Equal(t, header.Get("Authorization"), "Bearer first")
+
+	t.Run("rotated token file", func(t *testing.T) {
+		assert.NilError(t, os.WriteFile(tokenFile, []byte("second"), 0o600))
+		header := http.Header{}
+		assert.NilError(t, auth.Apply(header))
+		assert.Equal(t, header.Get("Authorization"), "Bearer second")
+	})
+
+	t.Run("token file emptied during a rotation", func(t *testing.T) {
+		assert.NilError(t, os.WriteFile(tokenFile, nil, 0o600))
+		assert.ErrorContains(t, auth.Apply(http.Header{}), "is empty")
+	})
+
+	t.Run("token flag", func(t *testing.T) {
+		auth, err := newRelayAuth(nil, "static", "")
+		assert.NilError(t, err)
+		header := http.Header{}
+		assert.NilError(t, auth.Apply(header))
+		assert.Equal(t, header.Get("Authorization"), "Bearer static")
+	})
+}
+
+func TestRelayAuthRequests(t *testing.T) {
+	auth, err := newRelayAuth([]string{"X-Proxy-Auth=proxy"}, "s3cret", "")
+	assert.NilError(t, err)
+	authorized := func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer s3cret" && r.Header.Get("X-Proxy-Auth") == "proxy"
+	}
+
+	t.Run("version check", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("X-Gosmee-Version", "1.0.0")
+		}))
+		defer server.Close()
+		logger := slog.New(slog.DiscardHandler)
+		assert.NilError(t, checkServerVersion(server.URL, "1.0.0", auth, logger, false))
+	})
+
+	t.Run("SSE and poll connections", func(t *testing.T) {
+		for _, transport := range []string{transportSSE, transportPoll} {
+			t.Run(transport, func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				var gotAuth bool
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotAuth = authorized(r)
+					w.WriteHeader(http.StatusUnauthorized)
+					cancel()
+				}))
+				defer server.Close()
+
+				_, sseURL, _, err := prepareSubscription(server.URL+"/test-channel", "", nil)
+				assert.NilError(t, err)
+				gs := newTestGoSmeeForProcessing(&replayDataOpts{transport: transport, relayAuth: auth})
+				err = gs.runSSEClient(ctx, []sseSubscription{{url: sseURL}}, "dev")
+				assert.ErrorIs(t, err, context.Canceled)
+				assert.Assert(t, gotAuth)
+			})
+		}
+	})
+}
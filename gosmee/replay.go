@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -28,7 +29,19 @@ type replayOpts struct {
 	org            string
 	logger         *slog.Logger
 	sinceTime      time.Time
-	ghop           GHOp
+	untilTime      time.Time
+	once           bool
+	filter         deliveryFilter
+	summary        replaySummary
+	history        DeliveryHistory
+	sleep          func(context.Context, time.Duration) error
+	// output is the --output format of the listings, out where they go,
+	// stdout when nil
+	output string
+	out    io.Writer
+	// position is the last delivery gone through, saved to state when set
+	position *replayPosition
+	state    *replayState
 }
 
 // chooseDeliveries reverses the deliveries slice and only show the deliveries since the last date we parsed.
@@ -48,167 +61,397 @@ func (r *replayOpts) chooseDeliveries(dlvs []*github.HookDelivery) []*github.Hoo
 	return retdeliveries
 }
 
+// replaySummary counts what happened to the deliveries of a replay.
+type replaySummary struct {
+	replayed, failed, skipped int
+}
+
+// finite tells whether replayHooks stops after replaying the deliveries up
+// to now, or up to --until, instead of polling for new ones forever.
+func (r *replayOpts) finite() bool {
+	return r.once || !r.untilTime.IsZero()
+}
+
 func (r *replayOpts) replayHooks(ctx context.Context, hookid int64) error {
-	r.ghop.Starting()
+	r.history.Starting()
+	if err := r.retryFailed(ctx, hookid); err != nil {
+		return err
+	}
 	for {
-		opt := &github.ListCursorOptions{PerPage: 100}
-		deliveries, _, err := r.ghop.ListHookDeliveries(ctx, r.org, r.repo, hookid, opt)
+		deliveries, err := r.listHookDeliveriesSince(ctx, hookid, r.sinceTime)
 		if err != nil {
 			return fmt.Errorf("cannot list deliveries: %w", err)
 		}
 		// reverse deliveries to replay from oldest to newest
 		deliveries = r.chooseDeliveries(deliveries)
 		for _, hd := range deliveries {
-			var delivery *github.HookDelivery
-			// There can be a race between the time listhookdeliveries show the
-			// id and Gethookdelivery is created on the API, so wait for it for a bit
-			for range []int{1, 2, 3} {
-				var resp *github.Response
-				var err error
-				delivery, resp, err = r.ghop.GetHookDelivery(ctx, r.org, r.repo, hookid, hd.GetID())
-				if resp.StatusCode == http.StatusNotFound {
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				if err != nil {
-					return fmt.Errorf("cannot get delivery: %w", err)
-				}
-			}
-			pm := payloadMsg{}
-			var ok bool
-			if pm.contentType, ok = delivery.Request.Headers["Content-Type"]; !ok {
-				pm.contentType = "application/json"
-			}
-			pm.body = delivery.Request.GetRawPayload()
-			pm.headers = delivery.Request.GetHeaders()
-			pm.eventID = hd.GetGUID()
-
-			// get the event type
-			if pv, ok := pm.headers["X-GitHub-Event"]; ok {
-				// github action don't like it
-				replace := strings.NewReplacer(":", "-", " ", "_", "/", "_")
-				pv = replace.Replace(strings.ToLower(pv))
-				// remove all non-alphanumeric characters and don't let directory traversal
-				pv = pmEventRe.FindString(pv)
-				pm.eventType = pv
-			}
-
-			dt := delivery.DeliveredAt.GetTime()
-			pm.timestamp = dt.Format(tsFormat)
-
-			if err := replayData(r.replayDataOpts, r.logger, pm); err != nil {
-				attrs := []slog.Attr{slog.String("delivery_id", pm.eventID), slog.String("event_type", pm.eventType), slog.String("error", err.Error())}
-				var deliveryErr *targetDeliveryError
-				if errors.As(err, &deliveryErr) {
-					attrs = deliveryAttrs(r.replayDataOpts, pm, "", 1, 1, deliveryErr)
-					attrs = append(attrs, slog.String("error", err.Error()))
-				}
-				r.logger.LogAttrs(context.Background(), slog.LevelError, "replay target delivery failed", attrs...)
+			if r.position.done(hd) {
 				continue
 			}
-			if r.replayDataOpts.saveDir != "" {
-				err := saveData(r.replayDataOpts, r.logger, pm)
-				if err != nil {
-					s := fmt.Sprintf("%s saving payload to local directory %s - %s\n",
-						ansi.Color("ERROR", "red+b"),
-						r.replayDataOpts.saveDir,
-						err.Error())
-					r.logger.ErrorContext(context.Background(), s)
-					continue
-				}
+			if !r.untilTime.IsZero() && hd.GetDeliveredAt().After(r.untilTime) {
+				break
 			}
-			if r.replayDataOpts.execCommand != "" {
-				if err := runExecCommand(ctx, r.replayDataOpts, r.logger, pm); err != nil {
-					s := fmt.Sprintf("%s exec command failed for event '%s' - %s\n",
-						ansi.Color("ERROR", "red+b"),
-						pm.eventType,
-						err.Error())
-					r.logger.ErrorContext(context.Background(), s)
+			failed := r.summary.failed
+			if r.filter.match(hd) {
+				if err := r.replayDelivery(ctx, hookid, hd); err != nil {
+					return err
 				}
+			} else {
+				r.summary.skipped++
+			}
+			if err := r.advance(ctx, hd, r.summary.failed > failed); err != nil {
+				return err
 			}
 		}
 
+		// the deliveries of the last second are listed again, the position
+		// skips the ones already gone through
 		if len(deliveries) != 0 {
-			r.sinceTime = deliveries[len(deliveries)-1].DeliveredAt.GetTime().Add(1 * time.Second)
+			r.sinceTime = deliveries[len(deliveries)-1].GetDeliveredAt().Time
+		}
+		if r.once || (!r.untilTime.IsZero() && !time.Now().Before(r.untilTime)) {
+			return r.finish(ctx)
+		}
+		if err := r.wait(ctx, 5*time.Second); err != nil {
+			return err
 		}
-		time.Sleep(5 * time.Second)
 	}
 }
 
-func replay(c *cli.Context) error {
-	ctx := context.Background()
-	if c.String("github-token") == "" {
-		return fmt.Errorf("required flag \"github-token\" not set")
+// advance moves the position past d, and saves it when --state-file is set.
+// A failed d is recorded in the state for the next run to retry it.
+func (r *replayOpts) advance(ctx context.Context, d *github.HookDelivery, failed bool) error {
+	pos := &replayPosition{DeliveryID: d.GetID(), GUID: d.GetGUID(), DeliveredAt: d.GetDeliveredAt().Time}
+	if r.position != nil {
+		pos.Failed = r.position.Failed
+	}
+	if failed && r.state != nil {
+		pos.Failed = append(slices.Clone(pos.Failed), d.GetID())
+		r.logger.LogAttrs(ctx, slog.LevelWarn, "the failed delivery is retried by the next run",
+			slog.Int64("id", d.GetID()), slog.String("delivery_id", d.GetGUID()))
+	}
+	r.position = pos
+	if err := r.state.save(r.position); err != nil {
+		return fmt.Errorf("cannot save replay position: %w", err)
+	}
+	return nil
+}
+
+// retryFailed replays again the deliveries that failed in the previous runs,
+// the ones failing again stay in the state for the next run.
+func (r *replayOpts) retryFailed(ctx context.Context, hookid int64) error {
+	if r.position == nil || len(r.position.Failed) == 0 {
+		return nil
+	}
+	pos := *r.position
+	pos.Failed = nil
+	for _, id := range r.position.Failed {
+		delivery, err := r.getHookDelivery(ctx, hookid, id)
+		if errors.Is(err, errDeliveryNotFound) {
+			r.summary.failed++
+			r.logger.LogAttrs(ctx, slog.LevelWarn, "dropping failed delivery no longer available from the GitHub API", slog.Int64("id", id))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot get delivery: %w", err)
+		}
+		if !r.filter.match(delivery) {
+			r.summary.skipped++
+			continue
+		}
+		failed := r.summary.failed
+		r.forwardDelivery(ctx, delivery.GetGUID(), delivery)
+		if r.summary.failed > failed {
+			pos.Failed = append(pos.Failed, id)
+		}
+	}
+	r.position = &pos
+	if err := r.state.save(r.position); err != nil {
+		return fmt.Errorf("cannot save replay position: %w", err)
 	}
+	return nil
+}
 
-	client := github.NewClient(nil)
-	client = client.WithAuthToken(c.String("github-token"))
+// finish logs the summary of a finite replay and fails when a delivery
+// could not be replayed.
+func (r *replayOpts) finish(ctx context.Context) error {
+	r.logger.LogAttrs(ctx, slog.LevelInfo,
+		fmt.Sprintf("%d deliveries replayed, %d failed, %d skipped", r.summary.replayed, r.summary.failed, r.summary.skipped),
+		slog.Int("replayed", r.summary.replayed), slog.Int("failed", r.summary.failed), slog.Int("skipped", r.summary.skipped))
+	if r.summary.failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d deliveries failed to replay", r.summary.failed), 1)
+	}
+	return nil
+}
 
-	logger, nocolor, err := getLogger(c)
+// replayDelivery fetches the payload of hd and forwards it to the target,
+// only GitHub API errors are returned, the other failures are counted in
+// the summary.
+func (r *replayOpts) replayDelivery(ctx context.Context, hookid int64, hd *github.HookDelivery) error {
+	// There can be a race between the time listhookdeliveries show the
+	// id and Gethookdelivery is created on the API, so wait for it for a bit
+	delivery, err := r.getHookDelivery(ctx, hookid, hd.GetID())
+	if errors.Is(err, errDeliveryNotFound) {
+		r.summary.failed++
+		r.logger.LogAttrs(ctx, slog.LevelWarn, "skipping delivery not available from the GitHub API",
+			slog.Int64("id", hd.GetID()), slog.String("delivery_id", hd.GetGUID()))
+		return nil
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot get delivery: %w", err)
 	}
+	r.forwardDelivery(ctx, hd.GetGUID(), delivery)
+	return nil
+}
 
-	ropt := &replayOpts{
-		cliCtx: c,
-		client: client,
-		logger: logger,
+// forwardDelivery forwards the payload of delivery to the target, its
+// failures are counted in the summary.
+func (r *replayOpts) forwardDelivery(ctx context.Context, guid string, delivery *github.HookDelivery) {
+	pm := payloadMsg{}
+	var ok bool
+	if pm.contentType, ok = delivery.Request.Headers["Content-Type"]; !ok {
+		pm.contentType = "application/json"
 	}
+	pm.body = delivery.Request.GetRawPayload()
+	pm.headers = delivery.Request.GetHeaders()
+	pm.eventID = guid
 
-	orgRepo := c.Args().Get(0)
-	if orgRepo == "" {
-		orgRepo = GetConfigString("replay", "org-repo")
-	}
-	if strings.Contains(orgRepo, "/") {
-		spt := strings.Split(orgRepo, "/")
-		ropt.org = spt[0]
-		ropt.repo = spt[1]
-	} else {
-		ropt.org = orgRepo
+	// get the event type
+	if pv := deliveryEventType(pm.headers); pv != "" {
+		// github action don't like it
+		replace := strings.NewReplacer(":", "-", " ", "_", "/", "_")
+		pv = replace.Replace(strings.ToLower(pv))
+		// remove all non-alphanumeric characters and don't let directory traversal
+		pv = pmEventRe.FindString(pv)
+		pm.eventType = pv
 	}
 
-	if ropt.org == "" {
-		return fmt.Errorf("at least an org is required or an org/repo")
+	dt := delivery.DeliveredAt.GetTime()
+	pm.timestamp = dt.Format(tsFormat)
+	pm.receivedAt = dt.UTC()
+
+	forwarded, saved, err := transformPayload(r.replayDataOpts, pm)
+	if err != nil {
+		r.summary.failed++
+		r.logger.LogAttrs(context.Background(), slog.LevelError, "transforming replayed delivery failed",
+			slog.String("delivery_id", pm.eventID), slog.String("event_type", pm.eventType), slog.String("error", err.Error()))
+		return
 	}
 
-	if ropt.repo == "" {
-		ropt.ghop = NewOrgLister(client, logger, ropt.org, ropt.repo)
-	} else {
-		ropt.ghop = NewRepoLister(client, logger, ropt.org, ropt.repo)
+	// a finite replay is used to re-deliver events, the target rejecting
+	// one is a failure
+	if err := saveAndDeliver(r.replayDataOpts, r.logger, saved, forwarded, r.finite()); err != nil {
+		r.summary.failed++
+		var deliveryErr *targetDeliveryError
+		if !errors.As(err, &deliveryErr) {
+			s := fmt.Sprintf("%s saving payload to local directory %s - %s\n",
+				ansi.Color("ERROR", "red+b"),
+				r.replayDataOpts.saveDir,
+				err.Error())
+			r.logger.ErrorContext(context.Background(), s)
+			return
+		}
+		attrs := deliveryAttrs(r.replayDataOpts, pm, "", 1, 1, deliveryErr)
+		attrs = append(attrs, slog.String("error", err.Error()))
+		r.logger.LogAttrs(context.Background(), slog.LevelError, "replay target delivery failed", attrs...)
+		return
+	}
+	r.summary.replayed++
+	if r.replayDataOpts.execCommand != "" {
+		if err := runExecCommand(ctx, r.replayDataOpts, r.logger, pm); err != nil {
+			s := fmt.Sprintf("%s exec command failed for event '%s' - %s\n",
+				ansi.Color("ERROR", "red+b"),
+				pm.eventType,
+				err.Error())
+			r.logger.ErrorContext(context.Background(), s)
+		}
 	}
+}
 
-	if c.Bool("list-hooks") {
-		return ropt.listHooks(ctx)
+// newDeliveryHistory returns the backend reading the deliveries of the
+// hooks of ropt.org or ropt.org/ropt.repo on provider.
+func newDeliveryHistory(c *cli.Context, provider string, auth *githubAuth, ropt *replayOpts, logger *slog.Logger) (DeliveryHistory, error) {
+	baseURL := c.String("provider-url")
+	switch provider {
+	case providerGitLab:
+		if baseURL == "" {
+			baseURL = defaultGitLabURL
+		}
+		client, err := newForgeClient(baseURL, "/api/v4", "PRIVATE-TOKEN", c.String("provider-token"))
+		if err != nil {
+			return nil, err
+		}
+		return NewGitLabLister(client, logger, ropt.org, ropt.repo), nil
+	case providerGitea:
+		if baseURL == "" {
+			return nil, fmt.Errorf("--provider-url is required for Gitea and Forgejo")
+		}
+		token := c.String("provider-token")
+		if token != "" {
+			token = "token " + token
+		}
+		client, err := newForgeClient(baseURL, "/api/v1", "Authorization", token)
+		if err != nil {
+			return nil, err
+		}
+		return NewGiteaLister(client, logger, ropt.org, ropt.repo), nil
+	}
+	var err error
+	if ropt.client, err = auth.client(ropt.org, ropt.repo); err != nil {
+		return nil, err
 	}
+	if ropt.repo == "" {
+		return NewOrgLister(ropt.client, logger, ropt.org, ropt.repo), nil
+	}
+	return NewRepoLister(ropt.client, logger, ropt.org, ropt.repo), nil
+}
 
-	_hookID := c.Args().Get(1)
-	if _hookID == "" {
-		_hookID = GetConfigString("replay", "hook-id")
+func replay(c *cli.Context) error {
+	ctx := context.Background()
+	provider := c.String("provider")
+	if !slices.Contains(replayProviders, provider) {
+		return fmt.Errorf("unknown provider %q, must be one of %s", provider, strings.Join(replayProviders, ", "))
+	}
+	var auth *githubAuth
+	var err error
+	if provider == providerGitHub {
+		if auth, err = githubAuthFromFlags(c); err != nil {
+			return err
+		}
 	}
-	_hookID = strings.TrimSpace(_hookID)
-	if _hookID == "" {
-		return fmt.Errorf("hook-id is required, use --list-hooks to get the hook id")
+
+	// a --redeliver --dry-run lists the deliveries it would redeliver
+	listing := c.Bool("list-hooks") || c.Bool("list-deliveries") || c.String("show") != "" ||
+		(c.Bool("redeliver") && c.Bool("dry-run"))
+	logOut := os.Stdout
+	if listing {
+		if !slices.Contains(listFormats, c.String("output")) {
+			return fmt.Errorf("invalid output format %s, must be one of %s", c.String("output"), strings.Join(listFormats, ", "))
+		}
+		// keep stdout for the listing, so it can be piped to jq
+		logOut = os.Stderr
 	}
-	// parse _hookID string as int64
-	hookID, err := strconv.ParseInt(_hookID, 10, 64)
+	logger, nocolor, err := getLoggerTo(c, logOut, listing)
 	if err != nil {
-		return fmt.Errorf("hook-id is required, use --list-hooks to get the hook id")
+		return err
 	}
 
-	if hookID == 0 {
-		return fmt.Errorf("hook-id is required, use --list-hooks to get the hook id")
+	ropt := &replayOpts{
+		cliCtx: c,
+		logger: logger,
+		output: c.String("output"),
 	}
 
+	// the target URL is the third argument, after org/repo and the hook ID,
+	// or the only one for the webhook of a GitHub App
+	targetArg := 2
+	var hookID int64
+	if c.Bool("github-app-hook") {
+		if auth == nil || auth.key == nil {
+			return fmt.Errorf("--github-app-hook needs --github-app-id and --github-app-private-key")
+		}
+		targetArg = 0
+		if ropt.client, err = auth.appClient(); err != nil {
+			return err
+		}
+		ropt.history = NewAppLister(ropt.client, logger)
+		if c.Bool("list-hooks") {
+			return ropt.listHooks(ctx)
+		}
+	} else {
+		orgRepo := c.Args().Get(0)
+		if orgRepo == "" {
+			orgRepo = GetConfigString("replay", "org-repo")
+		}
+		// GitLab projects can be in nested groups: group/subgroup/project
+		if i := strings.LastIndex(orgRepo, "/"); i >= 0 {
+			ropt.org = orgRepo[:i]
+			ropt.repo = orgRepo[i+1:]
+		} else {
+			ropt.org = orgRepo
+		}
+
+		if ropt.org == "" {
+			return fmt.Errorf("at least an org is required or an org/repo")
+		}
+
+		if ropt.history, err = newDeliveryHistory(c, provider, auth, ropt, logger); err != nil {
+			return err
+		}
+
+		if c.Bool("list-hooks") {
+			return ropt.listHooks(ctx)
+		}
+
+		_hookID := c.Args().Get(1)
+		if _hookID == "" {
+			_hookID = GetConfigString("replay", "hook-id")
+		}
+		_hookID = strings.TrimSpace(_hookID)
+		if _hookID == "" {
+			return fmt.Errorf("hook-id is required, use --list-hooks to get the hook id")
+		}
+		// parse _hookID string as int64
+		if hookID, err = strconv.ParseInt(_hookID, 10, 64); err != nil {
+			return fmt.Errorf("hook-id is required, use --list-hooks to get the hook id")
+		}
+
+		if hookID == 0 {
+			return fmt.Errorf("hook-id is required, use --list-hooks to get the hook id")
+		}
+	}
+
+	if sinceTime := c.String("time-since"); sinceTime != "" {
+		since, err := time.Parse(userTSFormat, sinceTime)
+		if err != nil {
+			return fmt.Errorf("cannot parse time-since: %w", err)
+		}
+		ropt.sinceTime = since
+	}
+	if until := c.String("until"); until != "" {
+		if ropt.untilTime, err = time.Parse(userTSFormat, until); err != nil {
+			return fmt.Errorf("cannot parse until: %w", err)
+		}
+	}
+	ropt.filter = deliveryFilter{
+		statuses:    c.StringSlice("status"),
+		events:      c.StringSlice("event-type"),
+		actions:     c.StringSlice("action"),
+		deliveryIDs: c.StringSlice("delivery-id"),
+		ignore:      c.StringSlice("ignore-event"),
+	}
+	if err := validateDeliveryStatuses(ropt.filter.statuses); err != nil {
+		return err
+	}
+	if show := c.String("show"); show != "" {
+		return ropt.showDelivery(ctx, hookID, show)
+	}
 	if c.Bool("list-deliveries") {
 		return ropt.listDeliveries(ctx, hookID)
 	}
+	if c.Bool("redeliver") {
+		if provider == providerGitea {
+			return errGiteaRedeliver
+		}
+		if !hasRedeliverSelection(c) {
+			return fmt.Errorf("--redeliver needs --time-since, --until, --status, --event-type, --action or --delivery-id to select the deliveries")
+		}
+		if !isatty.IsTerminal(os.Stdout.Fd()) || nocolor {
+			ansi.DisableColors(true)
+		}
+		return ropt.redeliverHooks(ctx, hookID, redeliverOpts{
+			dryRun:      c.Bool("dry-run"),
+			concurrency: c.Int("redeliver-concurrency"),
+			out:         os.Stdout,
+		})
+	}
 	// TODO: remove duplication from client and here
 	var targetURL string
 	switch {
 	case os.Getenv("GOSMEE_TARGET_URL") != "":
 		targetURL = os.Getenv("GOSMEE_TARGET_URL")
-	case c.NArg() == 3:
-		targetURL = c.Args().Get(2)
+	case c.NArg() == targetArg+1:
+		targetURL = c.Args().Get(targetArg)
 	case GetConfigString("replay", "target-url") != "":
 		targetURL = GetConfigString("replay", "target-url")
 	default:
@@ -227,18 +470,41 @@ func replay(c *cli.Context) error {
 		decorate = false
 	}
 
-	sinceTime := c.String("time-since")
-	if sinceTime == "" {
+	if path := c.String("state-file"); path != "" {
+		org := ropt.org
+		if c.Bool("github-app-hook") {
+			org = "app"
+		}
+		ropt.state = &replayState{path: path, key: replayStateKey(provider, org, ropt.repo, hookID)}
+		pos, err := ropt.state.position()
+		if err != nil {
+			return err
+		}
+		// --time-since replays again from a given time
+		if pos != nil && ropt.sinceTime.IsZero() {
+			logger.LogAttrs(ctx, slog.LevelInfo, "resuming replay after the last replayed delivery",
+				slog.Int64("id", pos.DeliveryID), slog.String("delivery_id", pos.GUID), slog.Time("delivered_at", pos.DeliveredAt))
+			ropt.sinceTime = pos.DeliveredAt
+			ropt.position = pos
+		}
+	}
+	if ropt.sinceTime.IsZero() {
 		// start from now
 		ropt.sinceTime = time.Now()
 	}
-	if sinceTime != "" {
-		since, err := time.Parse(userTSFormat, sinceTime)
-		if err != nil {
-			return fmt.Errorf("cannot parse time-since: %w", err)
-		}
-		ropt.sinceTime = since
+	if !ropt.untilTime.IsZero() && ropt.untilTime.Before(ropt.sinceTime) {
+		return fmt.Errorf("--until %s is before the time to replay events from, set --time-since", c.String("until"))
+	}
+	ropt.once = c.Bool("once")
+	transforms, err := newRequestTransformsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	archive, err := newEventArchiveFromFlags(c)
+	if err != nil {
+		return err
 	}
+	defer archive.Close()
 	ropt.replayDataOpts = &replayDataOpts{
 		targetURL:         targetURL,
 		saveDir:           c.String("saveDir"),
@@ -251,6 +517,11 @@ func replay(c *cli.Context) error {
 		execCommand:       c.String("exec"),
 		execOnEvents:      c.StringSlice("exec-on-events"),
 		execEnvVars:       c.StringSlice("exec-env-vars"),
+		transforms:        transforms,
+		saveOriginal:      c.Bool("save-original"),
+		resignSecret:      c.String("resign-secret"),
+		archive:           archive,
+		exportFormats:     c.StringSlice("export-format"),
 	}
 	return ropt.replayHooks(ctx, hookID)
 }
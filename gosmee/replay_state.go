@@ -0,0 +1,131 @@
+package gosmee
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+const replayStateVersion = 1
+
+// replayPosition is the last delivery of a hook replay went through.
+type replayPosition struct {
+	DeliveryID  int64     `json:"delivery_id"`
+	GUID        string    `json:"guid,omitempty"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	// Failed are the IDs of the deliveries before the position that could
+	// not be replayed, the next run retries them
+	Failed []int64 `json:"failed,omitempty"`
+}
+
+// done tells whether d was already gone through, it is not newer than the
+// position. Delivery IDs grow with time and break the ties of deliveries
+// made in the same second.
+func (p *replayPosition) done(d *github.HookDelivery) bool {
+	if p == nil {
+		return false
+	}
+	at := d.GetDeliveredAt().Time
+	return at.Before(p.DeliveredAt) || (at.Equal(p.DeliveredAt) && d.GetID() <= p.DeliveryID)
+}
+
+// replayState persists the replay position of each hook in a JSON file,
+// several replay processes can share it for different hooks: saves lock
+// the FILE.lock file next to it while they read and replace it.
+type replayState struct {
+	path string
+	key  string
+}
+
+type replayStateFile struct {
+	Version int                        `json:"version"`
+	Hooks   map[string]*replayPosition `json:"hooks"`
+}
+
+// replayStateKey identifies a hook in the state file.
+func replayStateKey(provider, org, repo string, hookID int64) string {
+	if repo != "" {
+		org += "/" + repo
+	}
+	return fmt.Sprintf("%s:%s#%d", provider, org, hookID)
+}
+
+func readReplayStateFile(path string) (*replayStateFile, error) {
+	state := &replayStateFile{Version: replayStateVersion, Hooks: map[string]*replayPosition{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("read replay state file: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parse replay state file %s: %w", path, err)
+	}
+	if state.Version > replayStateVersion {
+		return nil, fmt.Errorf("replay state file %s has version %d, this gosmee only reads up to %d", path, state.Version, replayStateVersion)
+	}
+	if state.Hooks == nil {
+		state.Hooks = map[string]*replayPosition{}
+	}
+	return state, nil
+}
+
+// position returns the saved position of the hook, nil when there is none.
+func (s *replayState) position() (*replayPosition, error) {
+	if s == nil {
+		return nil, nil
+	}
+	state, err := readReplayStateFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return state.Hooks[s.key], nil
+}
+
+// save records pos as the position of the hook. The file is read again
+// before being replaced to keep the positions of the other hooks.
+func (s *replayState) save(pos *replayPosition) error {
+	if s == nil {
+		return nil
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	state, err := readReplayStateFile(s.path)
+	if err != nil {
+		return err
+	}
+	state.Version = replayStateVersion
+	state.Hooks[s.key] = pos
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFileAtomic(s.path, "replay state", append(data, '\n'))
+}
+
+// lock takes the lock of the state file, the returned function releases it.
+func (s *replayState) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return nil, fmt.Errorf("create replay state directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open replay state lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock replay state file: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,216 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+	"gotest.tools/v3/assert"
+)
+
+func TestReplayPositionDone(t *testing.T) {
+	at := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	pos := &replayPosition{DeliveryID: 10, DeliveredAt: at}
+	delivery := func(id int64, at time.Time) *github.HookDelivery {
+		return &github.HookDelivery{ID: github.Int64(id), DeliveredAt: &github.Timestamp{Time: at}}
+	}
+	assert.Assert(t, pos.done(delivery(9, at.Add(-time.Second))))
+	assert.Assert(t, pos.done(delivery(10, at)))
+	assert.Assert(t, !pos.done(delivery(11, at)))
+	assert.Assert(t, !pos.done(delivery(12, at.Add(time.Second))))
+	assert.Assert(t, !(*replayPosition)(nil).done(delivery(1, at)))
+}
+
+func TestReplayHooksResumesFromStateFile(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-GitHub-Delivery"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	statePath := filepath.Join(t.TempDir(), "state", "replay.json")
+	// the position of another hook is kept
+	other := &replayState{path: statePath, key: replayStateKey(providerGitHub, "org", "other", 2)}
+	assert.NilError(t, other.save(&replayPosition{DeliveryID: 99, DeliveredAt: time.Now()}))
+
+	now := time.Now().Truncate(time.Second)
+	payload := json.RawMessage(`{}`)
+	delivery := func(id int64, at time.Time) *github.HookDelivery {
+		guid := fmt.Sprintf("guid-%d", id)
+		return &github.HookDelivery{
+			ID:          github.Int64(id),
+			GUID:        github.String(guid),
+			DeliveredAt: &github.Timestamp{Time: at},
+			Request: &github.HookRequest{
+				Headers:    map[string]string{"X-GitHub-Event": "push", "X-GitHub-Delivery": guid},
+				RawPayload: &payload,
+			},
+		}
+	}
+	// newest first, 2 and 3 are delivered in the same second
+	history := &mockGHOpForReplay{deliveries: []*github.HookDelivery{
+		delivery(3, now), delivery(2, now), delivery(1, now.Add(-time.Minute)),
+	}}
+	newReplay := func() *replayOpts {
+		state := &replayState{path: statePath, key: replayStateKey(providerGitHub, "org", "repo", 1)}
+		r := &replayOpts{
+			history:   history,
+			logger:    slog.New(slog.DiscardHandler),
+			sinceTime: now.Add(-time.Hour),
+			once:      true,
+			state:     state,
+			replayDataOpts: &replayDataOpts{
+				targetURL:        server.URL,
+				targetCnxTimeout: 1,
+			},
+		}
+		pos, err := state.position()
+		assert.NilError(t, err)
+		if pos != nil {
+			r.sinceTime = pos.DeliveredAt
+			r.position = pos
+		}
+		return r
+	}
+
+	assert.NilError(t, newReplay().replayHooks(context.Background(), 1))
+	assert.DeepEqual(t, received, []string{"guid-1", "guid-2", "guid-3"})
+
+	// a delivery made in the same second as the last replayed one, after
+	// a restart
+	history.deliveries = append([]*github.HookDelivery{delivery(4, now)}, history.deliveries...)
+	received = nil
+	assert.NilError(t, newReplay().replayHooks(context.Background(), 1))
+	assert.DeepEqual(t, received, []string{"guid-4"})
+
+	data, err := os.ReadFile(statePath)
+	assert.NilError(t, err)
+	var state replayStateFile
+	assert.NilError(t, json.Unmarshal(data, &state))
+	assert.Equal(t, state.Version, replayStateVersion)
+	assert.Equal(t, state.Hooks["github:org/repo#1"].GUID, "guid-4")
+	assert.Equal(t, state.Hooks["github:org/other#2"].DeliveryID, int64(99))
+}
+
+func TestReplayHooksRetriesFailedDeliveries(t *testing.T) {
+	var received []string
+	failing := "guid-2"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-GitHub-Delivery"))
+		if r.Header.Get("X-GitHub-Delivery") == failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Now().Truncate(time.Second)
+	payload := json.RawMessage(`{}`)
+	delivery := func(id int64, at time.Time) *github.HookDelivery {
+		guid := fmt.Sprintf("guid-%d", id)
+		return &github.HookDelivery{
+			ID:          github.Int64(id),
+			GUID:        github.String(guid),
+			DeliveredAt: &github.Timestamp{Time: at},
+			Request: &github.HookRequest{
+				Headers:    map[string]string{"X-GitHub-Event": "push", "X-GitHub-Delivery": guid},
+				RawPayload: &payload,
+			},
+		}
+	}
+	history := &mockGHOpForReplay{deliveries: []*github.HookDelivery{
+		delivery(4, now), delivery(3, now.Add(-time.Second)), delivery(2, now.Add(-2*time.Second)), delivery(1, now.Add(-time.Minute)),
+	}}
+	state := &replayState{path: filepath.Join(t.TempDir(), "replay.json"), key: replayStateKey(providerGitHub, "org", "repo", 1)}
+	newReplay := func() *replayOpts {
+		r := &replayOpts{
+			history:   history,
+			logger:    slog.New(slog.DiscardHandler),
+			sinceTime: now.Add(-time.Hour),
+			once:      true,
+			state:     state,
+			replayDataOpts: &replayDataOpts{
+				targetURL:        server.URL,
+				targetCnxTimeout: 1,
+			},
+		}
+		pos, err := state.position()
+		assert.NilError(t, err)
+		if pos != nil {
+			r.sinceTime = pos.DeliveredAt
+			r.position = pos
+		}
+		return r
+	}
+
+	assert.ErrorContains(t, newReplay().replayHooks(context.Background(), 1), "1 deliveries failed")
+	assert.DeepEqual(t, received, []string{"guid-1", "guid-2", "guid-3", "guid-4"})
+	pos, err := state.position()
+	assert.NilError(t, err)
+	assert.Equal(t, pos.GUID, "guid-4")
+	assert.DeepEqual(t, pos.Failed, []int64{2})
+
+	// still failing after a restart, it is kept for the next one
+	received = nil
+	assert.ErrorContains(t, newReplay().replayHooks(context.Background(), 1), "1 deliveries failed")
+	assert.DeepEqual(t, received, []string{"guid-2"})
+	pos, err = state.position()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pos.Failed, []int64{2})
+
+	failing = ""
+	received = nil
+	assert.NilError(t, newReplay().replayHooks(context.Background(), 1))
+	assert.DeepEqual(t, received, []string{"guid-2"})
+	pos, err = state.position()
+	assert.NilError(t, err)
+	assert.Equal(t, pos.GUID, "guid-4")
+	assert.Equal(t, len(pos.Failed), 0)
+}
+
+func TestReplayStateConcurrentSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state := &replayState{path: path, key: replayStateKey(providerGitHub, "org", "repo", int64(i))}
+			assert.Check(t, state.save(&replayPosition{DeliveryID: int64(i)}))
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	var state replayStateFile
+	assert.NilError(t, json.Unmarshal(data, &state))
+	assert.Equal(t, len(state.Hooks), 50)
+}
+
+func TestReplayStateFileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	state := &replayState{path: path, key: "github:org/repo#1"}
+	pos, err := state.position()
+	assert.NilError(t, err)
+	assert.Assert(t, pos == nil)
+
+	assert.NilError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	_, err = state.position()
+	assert.ErrorContains(t, err, "parse replay state file")
+
+	assert.NilError(t, os.WriteFile(path, []byte(`{"version": 2, "hooks": {}}`), 0o600))
+	_, err = state.position()
+	assert.ErrorContains(t, err, "has version 2")
+}
@@ -112,6 +112,10 @@ func (m *mockGHOpForReplay) GetHookDelivery(_ context.Context, _, _ string, _, d
 	return nil, &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}, errors.New("delivery not found")
 }
 
+func (m *mockGHOpForReplay) RedeliverHookDelivery(_ context.Context, _, _ string, _, _ int64) (*github.HookDelivery, *github.Response, error) {
+	return nil, &github.Response{Response: &http.Response{StatusCode: http.StatusAccepted}}, m.err
+}
+
 // mockGHOpForReplayWithNotFound is a variant that always returns not found for GetHookDelivery.
 type mockGHOpForReplayWithNotFound struct {
 	mockGHOpForReplay
@@ -124,10 +128,10 @@ func (m *mockGHOpForReplayWithNotFound) GetHookDelivery(_ context.Context, _, _
 
 // replayHooksForTest is a modified version of replayHooks that doesn't have an infinite loop for testing.
 func (r *replayOpts) replayHooksForTest(ctx context.Context, hookid int64) error {
-	r.ghop.Starting()
+	r.history.Starting()
 	// Just run one cycle for testing purposes
 	opt := &github.ListCursorOptions{PerPage: 100}
-	deliveries, _, err := r.ghop.ListHookDeliveries(ctx, r.org, r.repo, hookid, opt)
+	deliveries, _, err := r.history.ListHookDeliveries(ctx, r.org, r.repo, hookid, opt)
 	if err != nil {
 		return err
 	}
@@ -137,7 +141,7 @@ func (r *replayOpts) replayHooksForTest(ctx context.Context, hookid int64) error
 	for _, hd := range deliveries {
 		var delivery *github.HookDelivery
 		// Try only once in tests to avoid timeouts
-		delivery, resp, err := r.ghop.GetHookDelivery(ctx, r.org, r.repo, hookid, hd.GetID())
+		delivery, resp, err := r.history.GetHookDelivery(ctx, r.org, r.repo, hookid, hd.GetID())
 		if err != nil {
 			if resp != nil && resp.StatusCode == http.StatusNotFound {
 				// In tests, just continue rather than waiting and retrying
@@ -206,7 +210,7 @@ func TestReplayHooks(t *testing.T) {
 	defer server.Close()
 
 	t.Run("Successful Replay", func(t *testing.T) {
-		// Create mock GHOp implementation that will return our mock delivery
+		// Create mock DeliveryHistory implementation that will return our mock delivery
 		mockGh := &mockGHOpForReplay{
 			deliveries: []*github.HookDelivery{mockDelivery},
 		}
@@ -220,7 +224,7 @@ func TestReplayHooks(t *testing.T) {
 			logger:    logger,
 			org:       "test-org",
 			repo:      "test-repo",
-			ghop:      mockGh,
+			history:   mockGh,
 			sinceTime: time.Now().Add(-1 * time.Hour), // Set time in the past
 			replayDataOpts: &replayDataOpts{
 				targetURL:        server.URL,
@@ -237,7 +241,7 @@ func TestReplayHooks(t *testing.T) {
 	})
 
 	t.Run("ListHookDeliveries Error", func(t *testing.T) {
-		// Create mock GHOp implementation that will return an error for ListHookDeliveries
+		// Create mock DeliveryHistory implementation that will return an error for ListHookDeliveries
 		mockGh := &mockGHOpForReplay{
 			err: errors.New("list deliveries error"),
 		}
@@ -247,10 +251,10 @@ func TestReplayHooks(t *testing.T) {
 
 		// Set up the replayOpts
 		opts := &replayOpts{
-			logger: logger,
-			org:    "test-org",
-			repo:   "test-repo",
-			ghop:   mockGh,
+			logger:  logger,
+			org:     "test-org",
+			repo:    "test-repo",
+			history: mockGh,
 			replayDataOpts: &replayDataOpts{
 				targetURL: server.URL,
 			},
@@ -287,7 +291,7 @@ func TestReplayHooks(t *testing.T) {
 			logger:    logger,
 			org:       "test-org",
 			repo:      "test-repo",
-			ghop:      mockGhNotFound,
+			history:   mockGhNotFound,
 			sinceTime: time.Now().Add(-1 * time.Hour), // Set time in the past
 			replayDataOpts: &replayDataOpts{
 				targetURL: server.URL,
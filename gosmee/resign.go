@@ -0,0 +1,80 @@
+package gosmee
+
+import (
+	"maps"
+	"strings"
+)
+
+// resignPayload recomputes the provider signature headers of pm with secret,
+// so a local service can verify forwarded requests with a development
+// secret instead of the production one. Existing signature headers keep their
+// format, when there are none the provider is guessed from the event header.
+func resignPayload(secret string, pm payloadMsg) payloadMsg {
+	if secret == "" {
+		return pm
+	}
+
+	out := pm
+	out.headers = maps.Clone(pm.headers)
+	if out.headers == nil {
+		out.headers = make(map[string]string)
+	}
+
+	signed := false
+	for _, name := range []string{"X-Hub-Signature-256", "X-Hub-Signature", "X-Gitea-Signature", "X-Gogs-Signature", "X-Forgejo-Signature"} {
+		value, ok := headerValue(out.headers, name)
+		if !ok {
+			continue
+		}
+		setHeader(out.headers, name, signatureLike(value, secret, pm.body))
+		signed = true
+	}
+	if _, ok := headerValue(out.headers, "X-Gitlab-Token"); ok {
+		setHeader(out.headers, "X-Gitlab-Token", secret)
+		signed = true
+	}
+	if signed {
+		return out
+	}
+
+	switch {
+	case hasHeader(out.headers, "X-Github-Event"):
+		setHeader(out.headers, "X-Hub-Signature-256", "sha256="+hmacSHA256Hex(secret, pm.body))
+		setHeader(out.headers, "X-Hub-Signature", "sha1="+hmacSHA1Hex(secret, pm.body))
+	case hasHeader(out.headers, "X-Forgejo-Event"):
+		setHeader(out.headers, "X-Forgejo-Signature", hmacSHA256Hex(secret, pm.body))
+	case hasHeader(out.headers, "X-Gitea-Event"):
+		setHeader(out.headers, "X-Gitea-Signature", hmacSHA256Hex(secret, pm.body))
+	case hasHeader(out.headers, "X-Gogs-Event"):
+		setHeader(out.headers, "X-Gogs-Signature", hmacSHA256Hex(secret, pm.body))
+	case hasHeader(out.headers, "X-Gitlab-Event"):
+		setHeader(out.headers, "X-Gitlab-Token", secret)
+	case hasHeader(out.headers, "X-Event-Key"):
+		setHeader(out.headers, "X-Hub-Signature", "sha256="+hmacSHA256Hex(secret, pm.body))
+	}
+	return out
+}
+
+// signatureLike computes a signature of body using the same algorithm and
+// prefix as the original header value.
+func signatureLike(original, secret string, body []byte) string {
+	switch {
+	case strings.HasPrefix(original, "sha1="):
+		return "sha1=" + hmacSHA1Hex(secret, body)
+	case strings.HasPrefix(original, "sha256="):
+		return "sha256=" + hmacSHA256Hex(secret, body)
+	default:
+		return hmacSHA256Hex(secret, body)
+	}
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	_, ok := headerValue(headers, name)
+	return ok
+}
+
+// setHeader replaces any case variant of name in headers with value.
+func setHeader(headers map[string]string, name, value string) {
+	deleteHeader(headers, name)
+	headers[name] = value
+}
@@ -0,0 +1,116 @@
+package gosmee
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestResignPayloadExistingHeaders(t *testing.T) {
+	body := []byte(`{"action":"opened"}`)
+	pm := payloadMsg{
+		headers: map[string]string{
+			"X-Github-Event":      "pull_request",
+			"X-Hub-Signature-256": "sha256=production",
+			"X-Hub-Signature":     "sha1=production",
+		},
+		body: body,
+	}
+
+	out := resignPayload("dev-secret", pm)
+	assert.Assert(t, validateGitHubWebhookSignature("dev-secret", body, out.headers["X-Hub-Signature-256"]))
+	assert.Equal(t, out.headers["X-Hub-Signature"], "sha1="+hmacSHA1Hex("dev-secret", body))
+	assert.Equal(t, pm.headers["X-Hub-Signature-256"], "sha256=production")
+}
+
+func TestResignPayloadByProvider(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "github",
+			headers: map[string]string{"X-Github-Event": "push"},
+			want: map[string]string{
+				"X-Hub-Signature-256": "sha256=" + hmacSHA256Hex("dev", body),
+				"X-Hub-Signature":     "sha1=" + hmacSHA1Hex("dev", body),
+			},
+		},
+		{
+			name:    "gitea",
+			headers: map[string]string{"X-Gitea-Event": "push"},
+			want:    map[string]string{"X-Gitea-Signature": hmacSHA256Hex("dev", body)},
+		},
+		{
+			name:    "forgejo",
+			headers: map[string]string{"X-Forgejo-Event": "push", "X-Gitea-Event": "push"},
+			want:    map[string]string{"X-Forgejo-Signature": hmacSHA256Hex("dev", body)},
+		},
+		{
+			name:    "gitlab",
+			headers: map[string]string{"X-Gitlab-Event": "Push Hook"},
+			want:    map[string]string{"X-Gitlab-Token": "dev"},
+		},
+		{
+			name:    "gitlab existing token",
+			headers: map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "prod"},
+			want:    map[string]string{"X-Gitlab-Token": "dev"},
+		},
+		{
+			name:    "bitbucket",
+			headers: map[string]string{"X-Event-Key": "repo:push"},
+			want:    map[string]string{"X-Hub-Signature": "sha256=" + hmacSHA256Hex("dev", body)},
+		},
+		{
+			name:    "gitea existing raw signature",
+			headers: map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": "abcd"},
+			want:    map[string]string{"X-Gitea-Signature": hmacSHA256Hex("dev", body)},
+		},
+		{
+			name:    "gitea existing gogs signature",
+			headers: map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": "abcd", "X-Gogs-Signature": "abcd"},
+			want: map[string]string{
+				"X-Gitea-Signature": hmacSHA256Hex("dev", body),
+				"X-Gogs-Signature":  hmacSHA256Hex("dev", body),
+			},
+		},
+		{
+			name:    "gogs",
+			headers: map[string]string{"X-Gogs-Event": "push"},
+			want:    map[string]string{"X-Gogs-Signature": hmacSHA256Hex("dev", body)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := resignPayload("dev", payloadMsg{headers: tt.headers, body: body})
+			for k, v := range tt.want {
+				assert.Equal(t, out.headers[k], v, k)
+			}
+		})
+	}
+}
+
+func TestResignPayloadDisabled(t *testing.T) {
+	pm := payloadMsg{headers: map[string]string{"X-Github-Event": "push"}}
+	out := resignPayload("", pm)
+	_, ok := out.headers["X-Hub-Signature-256"]
+	assert.Assert(t, !ok)
+}
+
+func TestTransformPayloadResignsAfterTransforms(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "body.tmpl")
+	assert.NilError(t, os.WriteFile(tmpl, []byte(`{"new":true}`), 0o600))
+	transforms, err := newRequestTransforms(nil, nil, nil, tmpl, "")
+	assert.NilError(t, err)
+
+	ropts := &replayDataOpts{transforms: transforms, resignSecret: "dev"}
+	pm := payloadMsg{headers: map[string]string{"X-Github-Event": "push", "X-Hub-Signature-256": "sha256=old"}, body: []byte(`{"old":true}`)}
+	forwarded, saved, err := transformPayload(ropts, pm)
+	assert.NilError(t, err)
+	assert.Assert(t, validateGitHubWebhookSignature("dev", []byte(`{"new":true}`), forwarded.headers["X-Hub-Signature-256"]))
+	assert.Equal(t, saved.headers["X-Hub-Signature-256"], forwarded.headers["X-Hub-Signature-256"])
+}
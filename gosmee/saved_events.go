@@ -0,0 +1,293 @@
+package gosmee
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	savedEventMetaSuffix  = ".meta.json"
+	savedEventMetaVersion = 2
+)
+
+// savedEventMeta is written next to each payload saved in --saveDir so the
+// event can be listed and replayed without parsing the generated scripts.
+type savedEventMeta struct {
+	Version     int               `json:"version"`
+	EventType   string            `json:"event_type,omitempty"`
+	DeliveryID  string            `json:"delivery_id,omitempty"`
+	StreamID    string            `json:"stream_id,omitempty"`
+	Channel     string            `json:"channel,omitempty"`
+	Timestamp   string            `json:"timestamp"`
+	ReceivedAt  time.Time         `json:"received_at,omitzero"`
+	SavedAt     time.Time         `json:"saved_at"`
+	ContentType string            `json:"content_type,omitempty"`
+	TargetURL   string            `json:"target_url,omitempty"`
+	BodySHA256  string            `json:"body_sha256,omitempty"`
+	Headers     map[string]string `json:"headers"`
+	Result      *deliveryResult   `json:"result,omitempty"`
+}
+
+// deliveryResult records how forwarding a saved event to the target went.
+type deliveryResult struct {
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	At         time.Time `json:"at"`
+}
+
+// savedEvent is a payload found in a saveDir, either as a file or as a
+// record of a JSONL archive.
+type savedEvent struct {
+	Name     string
+	BodyFile string
+	Meta     savedEventMeta
+	body     []byte
+	// update is set for an archive record without a body, see
+	// savedEventRecord.Update
+	update bool
+}
+
+type savedEventFilter struct {
+	eventTypes  []string
+	deliveryIDs []string
+	since       time.Time
+	until       time.Time
+}
+
+func newSavedEventMeta(rd *replayDataOpts, pm payloadMsg) savedEventMeta {
+	sum := sha256.Sum256(pm.body)
+	return savedEventMeta{
+		Version:     savedEventMetaVersion,
+		EventType:   pm.eventType,
+		DeliveryID:  pm.eventID,
+		StreamID:    pm.streamID,
+		Channel:     rd.channel,
+		Timestamp:   pm.timestamp,
+		ReceivedAt:  pm.receivedAt,
+		SavedAt:     time.Now().UTC(),
+		ContentType: pm.contentType,
+		TargetURL:   deliveryTargetURL(rd, pm),
+		BodySHA256:  hex.EncodeToString(sum[:]),
+		Headers:     pm.headers,
+	}
+}
+
+// eventTime returns when the event was received, or saved for the events
+// saved without their receive time.
+func (m savedEventMeta) eventTime() time.Time {
+	if !m.ReceivedAt.IsZero() {
+		return m.ReceivedAt
+	}
+	return m.SavedAt
+}
+
+// sameEvent reports whether two metadata describe the same event, which is
+// the case when the same delivery is saved again while being retried.
+func (m savedEventMeta) sameEvent(other savedEventMeta) bool {
+	return m.DeliveryID == other.DeliveryID && m.StreamID == other.StreamID && m.BodySHA256 == other.BodySHA256
+}
+
+func writeSavedEventMeta(dir, fbasepath string, meta savedEventMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fbasepath+savedEventMetaSuffix), data, 0o600)
+}
+
+func readSavedEventMeta(dir, fbasepath string) (savedEventMeta, error) {
+	var meta savedEventMeta
+	data, err := os.ReadFile(filepath.Join(dir, fbasepath+savedEventMetaSuffix))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("parse metadata for %s: %w", fbasepath, err)
+	}
+	return meta, nil
+}
+
+// savedEventBaseName returns the file name, without extension, to save meta
+// under in dir. Events saved in the same millisecond get a numbered suffix
+// instead of overwriting each other, while saving the same event again
+// reuses its name.
+func savedEventBaseName(dir, base string, meta savedEventMeta) (string, error) {
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+		existing, err := readSavedEventMeta(dir, name)
+		switch {
+		case err == nil:
+			if existing.sameEvent(meta) {
+				return name, nil
+			}
+		case os.IsNotExist(err):
+			body, err := os.ReadFile(filepath.Join(dir, name+".json"))
+			if os.IsNotExist(err) {
+				return name, nil
+			}
+			if err != nil {
+				return "", err
+			}
+			// payload saved by an older gosmee without a metadata file
+			sum := sha256.Sum256(body)
+			if hex.EncodeToString(sum[:]) == meta.BodySHA256 {
+				return name, nil
+			}
+		default:
+			return "", err
+		}
+	}
+}
+
+// loadSavedEvents returns the events saved in dir, oldest first, including
+// the ones recorded in JSONL archives. Payloads saved by older versions
+// without a metadata file are still returned, with the event type and time
+// guessed from the file name.
+func loadSavedEvents(dir string) ([]savedEvent, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]savedEvent, 0, len(entries))
+	archived := []savedEvent{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && isEventArchiveFile(name) {
+			// entries are sorted, rotated archives come before the active one
+			evs, err := readEventArchive(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			archived = append(archived, evs...)
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, savedEventMetaSuffix) || isExportFile(name) {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".json")
+		ev := savedEvent{Name: base, BodyFile: filepath.Join(dir, name)}
+
+		meta, err := readSavedEventMeta(dir, base)
+		switch {
+		case err == nil:
+			ev.Meta = meta
+		case os.IsNotExist(err):
+			ev.Meta = legacySavedEventMeta(base, entry)
+		default:
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	// an event recorded several times, once per delivery attempt, keeps its
+	// last record
+	index := map[string]int{}
+	for _, ev := range archived {
+		events = mergeArchivedEvent(events, index, ev)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Meta.SavedAt.Before(events[j].Meta.SavedAt)
+	})
+	return events, nil
+}
+
+func legacySavedEventMeta(base string, entry os.DirEntry) savedEventMeta {
+	meta := savedEventMeta{Timestamp: base, ContentType: "application/json"}
+	// file names are either TIMESTAMP or EVENTTYPE-TIMESTAMP
+	if len(base) > len(tsFormat) && base[len(base)-len(tsFormat)-1] == '-' {
+		meta.EventType = base[:len(base)-len(tsFormat)-1]
+		meta.Timestamp = base[len(base)-len(tsFormat):]
+	}
+	// the name has the UTC time the event was received, to the minute as
+	// tsFormat repeats the month instead of the seconds. The file time is
+	// only a fallback, it changes when the directory is copied.
+	if ts, err := time.Parse(tsFormat, meta.Timestamp); err == nil {
+		meta.ReceivedAt = ts
+		meta.SavedAt = ts
+	} else if info, err := entry.Info(); err == nil {
+		meta.SavedAt = info.ModTime().UTC()
+	}
+	return meta
+}
+
+func (f savedEventFilter) match(ev savedEvent) bool {
+	if len(f.eventTypes) > 0 && !slices.Contains(f.eventTypes, ev.Meta.EventType) {
+		return false
+	}
+	if len(f.deliveryIDs) > 0 && !slices.Contains(f.deliveryIDs, ev.Meta.DeliveryID) && !slices.Contains(f.deliveryIDs, ev.Name) {
+		return false
+	}
+	if !f.since.IsZero() && ev.Meta.eventTime().Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && ev.Meta.eventTime().After(f.until) {
+		return false
+	}
+	return true
+}
+
+func filterSavedEvents(events []savedEvent, f savedEventFilter) []savedEvent {
+	ret := make([]savedEvent, 0, len(events))
+	for _, ev := range events {
+		if f.match(ev) {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
+
+// findSavedEvent looks up an event by file name or delivery ID.
+func findSavedEvent(events []savedEvent, id string) (savedEvent, bool) {
+	id = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(id), ".sh"), ".json")
+	for _, ev := range events {
+		if ev.Name == id || (ev.Meta.DeliveryID != "" && ev.Meta.DeliveryID == id) {
+			return ev, true
+		}
+	}
+	return savedEvent{}, false
+}
+
+// payload rebuilds the payloadMsg the event was saved from.
+func (ev savedEvent) payload() (payloadMsg, error) {
+	body := ev.body
+	if ev.BodyFile != "" {
+		var err error
+		if body, err = os.ReadFile(ev.BodyFile); err != nil {
+			return payloadMsg{}, err
+		}
+	}
+	headers := maps.Clone(ev.Meta.Headers)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	contentType := ev.Meta.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return payloadMsg{
+		headers:     headers,
+		body:        body,
+		timestamp:   ev.Meta.Timestamp,
+		receivedAt:  ev.Meta.ReceivedAt,
+		contentType: contentType,
+		eventType:   ev.Meta.EventType,
+		eventID:     ev.Meta.DeliveryID,
+		streamID:    ev.Meta.StreamID,
+	}, nil
+}
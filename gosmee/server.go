@@ -2,13 +2,16 @@ package gosmee
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // required by the legacy X-Hub-Signature header
 	"crypto/sha256"
 	"crypto/subtle"
 	_ "embed"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -26,6 +29,10 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -67,6 +74,10 @@ type EventBroker struct {
 	sync.RWMutex
 	subscribers map[string][]*Subscriber
 	logger      *slog.Logger
+	signingKey  ed25519.PrivateKey // signs encrypted events when set
+	// compressMinSize is the size from which events are compressed for the
+	// subscribers asking for it, 0 disables compression.
+	compressMinSize int
 }
 
 // NewEventBroker creates a new event broker.
@@ -132,14 +143,10 @@ func (eb *EventBroker) PublishEvent(channel string, event relayEvent) {
 
 	// Send to each subscriber
 	for _, s := range subscribers {
-		payload := event
-		if s.PublicKey != nil {
-			encrypted, err := Encrypt(event.Data, s.PublicKey)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "WARNING: encryption failed for subscriber on channel %s: %v\n", s.Channel, err) //nolint:gosec // stderr, not web output
-				continue
-			}
-			payload.Data = encrypted
+		payload, err := encryptRelayEvent(event, s.Channel, s.PublicKey, eb.signingKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: encryption failed for subscriber on channel %s: %v\n", s.Channel, err) //nolint:gosec // stderr, not web output
+			continue
 		}
 
 		// Non-blocking send - if buffer is full, we'll skip this subscriber
@@ -211,6 +218,7 @@ func serveIndex(publicURL, footer string, protectedChannels *ProtectedChannels)
 
 		url := fmt.Sprintf("%s/%s", publicURL, channel)
 		eventsURL := fmt.Sprintf("/events/%s", channel)
+		historyURL := fmt.Sprintf("/history/%s", channel)
 
 		t, err := template.New("index").Parse(string(indexTmpl))
 		if err != nil {
@@ -221,11 +229,12 @@ func serveIndex(publicURL, footer string, protectedChannels *ProtectedChannels)
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusOK)
 		varmap := map[string]any{
-			"URL":       url,
-			"EventsURL": eventsURL,
-			"Channel":   channel,
-			"Version":   string(Version),
-			"Footer":    template.HTML(footer), //nolint:gosec // operator-trusted input; intentionally rendered as raw HTML
+			"URL":        url,
+			"EventsURL":  eventsURL,
+			"HistoryURL": historyURL,
+			"Channel":    channel,
+			"Version":    string(Version),
+			"Footer":     template.HTML(footer), //nolint:gosec // operator-trusted input; intentionally rendered as raw HTML
 		}
 		if err := t.ExecuteTemplate(w, "index", varmap); err != nil {
 			errorIt(w, r, http.StatusInternalServerError, err)
@@ -238,6 +247,21 @@ func errorIt(w http.ResponseWriter, _ *http.Request, status int, err error) {
 	_, _ = w.Write([]byte(err.Error()))
 }
 
+// hmacSHA256Hex returns the hex encoded HMAC-SHA256 of payload.
+func hmacSHA256Hex(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hmacSHA1Hex returns the hex encoded HMAC-SHA1 of payload, as used by the
+// legacy GitHub X-Hub-Signature header.
+func hmacSHA1Hex(secret string, payload []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // validateGitHubWebhookSignature validates the GitHub webhook signature.
 func validateGitHubWebhookSignature(secret string, payload []byte, signatureHeader string) bool {
 	if !strings.HasPrefix(signatureHeader, "sha256=") {
@@ -245,20 +269,12 @@ func validateGitHubWebhookSignature(secret string, payload []byte, signatureHead
 	}
 
 	signature := strings.TrimPrefix(signatureHeader, "sha256=")
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(payload)
-	expectedMAC := hex.EncodeToString(mac.Sum(nil))
-
-	return hmac.Equal([]byte(signature), []byte(expectedMAC))
+	return hmac.Equal([]byte(signature), []byte(hmacSHA256Hex(secret, payload)))
 }
 
 // validateBitbucketHMAC validates Bitbucket Cloud/Server webhook HMAC signature.
 func validateBitbucketHMAC(secret string, payload []byte, signatureHeader string) bool {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(payload)
-	expectedMAC := hex.EncodeToString(mac.Sum(nil))
-
-	return hmac.Equal([]byte(signatureHeader), []byte(expectedMAC))
+	return hmac.Equal([]byte(signatureHeader), []byte(hmacSHA256Hex(secret, payload)))
 }
 
 // validateGiteaSignature validates Gitea/Forge webhook signature.
@@ -268,11 +284,7 @@ func validateGiteaSignature(secret string, payload []byte, signatureHeader strin
 	}
 
 	signature := strings.TrimPrefix(signatureHeader, "sha256=")
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(payload)
-	expectedMAC := hex.EncodeToString(mac.Sum(nil))
-
-	return hmac.Equal([]byte(signature), []byte(expectedMAC))
+	return hmac.Equal([]byte(signature), []byte(hmacSHA256Hex(secret, payload)))
 }
 
 // validateWebhookSignature validates webhook signatures for different providers by trying multiple secrets.
@@ -333,11 +345,25 @@ func handleWebhookPost(c *cli.Context, relay payloadRelay, webhookSecrets []stri
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		now := time.Now().UTC()
+		channel := chi.URLParam(r, "channel")
+		// a provider sending a trace context is the parent of the receive
+		ctx, span := tracer().Start(tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header)), "gosmee.webhook.receive",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("gosmee.channel", channel)))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		w = ww
+		defer func() {
+			span.SetAttributes(attribute.Int("http.response.status_code", ww.Status()))
+			if ww.Status() >= http.StatusBadRequest {
+				span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+			}
+			span.End()
+		}()
+
 		if !strings.Contains(r.Header.Get("Content-Type"), contentType) {
 			http.Error(w, "content-type must be application/json", http.StatusBadRequest)
 			return
 		}
-		channel := chi.URLParam(r, "channel")
 		defer r.Body.Close()
 
 		// Limit request body size to prevent memory exhaustion attacks
@@ -371,13 +397,14 @@ func handleWebhookPost(c *cli.Context, relay payloadRelay, webhookSecrets []stri
 		}
 		payload["timestamp"] = fmt.Sprintf("%d", now.UnixMilli())
 		payload["bodyB"] = base64.StdEncoding.EncodeToString(body)
+		injectEnvelopeTrace(ctx, payload)
 		reencoded, err := json.Marshal(payload)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		streamID, err := relay.Publish(r.Context(), channel, reencoded)
+		streamID, err := relay.Publish(ctx, channel, reencoded)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("publish event: %v", err), http.StatusInternalServerError)
 			return
@@ -398,6 +425,8 @@ func handleWebhookPost(c *cli.Context, relay payloadRelay, webhookSecrets []stri
 		}
 		_ = json.NewEncoder(w).Encode(resp)
 		deliveryID, eventType := relayEventMetadata(reencoded)
+		span.SetAttributes(attribute.String("gosmee.delivery_id", deliveryID),
+			attribute.String("gosmee.event_type", eventType), attribute.String("gosmee.stream_id", streamID))
 		logger.LogAttrs(r.Context(), slog.LevelInfo, "webhook published",
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 			slog.String("channel", channel), slog.String("delivery_id", deliveryID),
@@ -406,9 +435,61 @@ func handleWebhookPost(c *cli.Context, relay payloadRelay, webhookSecrets []stri
 	}
 }
 
+// replayRequestContentType is the content type of a replay sending its full
+// header set in a replayRequest, the other replays send the payload as their
+// body and its headers as their own.
+const replayRequestContentType = "application/vnd.gosmee.replay+json"
+
+// replayRequest is a payload to replay with its headers. Body is the payload
+// as a JSON value, or as a JSON string holding it verbatim.
+type replayRequest struct {
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	// Resign recomputes the signature headers of the payload with the first
+	// --webhook-signature secret, only for replays authenticated with the
+	// --replay-token.
+	Resign bool `json:"resign"`
+}
+
+// errUnauthenticatedResign refuses to sign payloads for anyone able to reach
+// the replay endpoint, clients targets would take them for provider webhooks.
+var errUnauthenticatedResign = errors.New("cannot recompute signatures without a --replay-token on the server, re-sign on the client with --resign-secret instead")
+
+// parseReplayRequest returns the headers and the payload of a replayRequest,
+// authenticated tells whether the replay token of the server was checked.
+func parseReplayRequest(data []byte, webhookSecrets []string, authenticated bool) (map[string]string, []byte, error) {
+	var req replayRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, nil, fmt.Errorf("invalid replay request: %w", err)
+	}
+	if len(req.Body) == 0 {
+		return nil, nil, fmt.Errorf("invalid replay request: missing body")
+	}
+	body := []byte(req.Body)
+	var verbatim string
+	if json.Unmarshal(req.Body, &verbatim) == nil {
+		body = []byte(verbatim)
+	}
+	headers := req.Headers
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	if req.Resign {
+		if !authenticated {
+			return nil, nil, errUnauthenticatedResign
+		}
+		if len(webhookSecrets) == 0 {
+			return nil, nil, fmt.Errorf("cannot recompute signatures: the server has no --webhook-signature secret")
+		}
+		headers = resignPayload(webhookSecrets[0], payloadMsg{headers: headers, body: body}).headers
+	}
+	return headers, body, nil
+}
+
 // handleReplayPost handles POST requests to the replay endpoint.
 func handleReplayPost(c *cli.Context, relay payloadRelay) http.HandlerFunc {
 	replayToken := c.String("replay-token")
+	webhookSecrets := c.StringSlice("webhook-signature")
 	// Hash the expected token once so the comparison operates on fixed-length
 	// digests, avoiding leaking the token length via ConstantTimeCompare's
 	// early return on length mismatch.
@@ -457,17 +538,37 @@ func handleReplayPost(c *cli.Context, relay payloadRelay) http.HandlerFunc {
 
 		// Create a payload with the same format as the original webhook handler
 		payload := make(map[string]any)
-		// Add basic headers from the replay request
-		for k, v := range r.Header {
-			if strings.EqualFold(k, "Authorization") {
-				continue
+		if strings.HasPrefix(r.Header.Get("Content-Type"), replayRequestContentType) {
+			headers, replayBody, err := parseReplayRequest(body, webhookSecrets, replayToken != "")
+			if errors.Is(err, errUnauthenticatedResign) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
 			}
-			payload[strings.ToLower(k)] = v[0]
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			payload["content-type"] = contentType
+			for k, v := range headers {
+				if strings.EqualFold(k, "Authorization") {
+					continue
+				}
+				payload[strings.ToLower(k)] = v
+			}
+			body = replayBody
+		} else {
+			// Add basic headers from the replay request
+			for k, v := range r.Header {
+				if strings.EqualFold(k, "Authorization") {
+					continue
+				}
+				payload[strings.ToLower(k)] = v[0]
+			}
+			payload["content-type"] = contentType // Ensure content-type is set for replay
 		}
 		// Add timestamp and encode the body
 		payload["timestamp"] = fmt.Sprintf("%d", now.UnixMilli())
 		payload["bodyB"] = base64.StdEncoding.EncodeToString(body)
-		payload["content-type"] = contentType // Ensure content-type is set for replay
 
 		// Re-encode the payload to match the expected format
 		reencoded, err := json.Marshal(payload)
@@ -655,6 +756,16 @@ func authorizeEventSubscriber(w http.ResponseWriter, r *http.Request, protectedC
 	return channel, pubKey, true
 }
 
+// logRevokedSubscriber logs the closing of a stream whose key was removed
+// from the encrypted channels file or expired.
+func logRevokedSubscriber(ctx context.Context, logger *slog.Logger, reqID, channel string, pubKey *[32]byte) {
+	attrs := []slog.Attr{slog.String("request_id", reqID), slog.String("channel", channel)}
+	if pubKey != nil {
+		attrs = append(attrs, slog.String("key_fingerprint", PublicKeyFingerprint(pubKey)))
+	}
+	logger.LogAttrs(ctx, slog.LevelInfo, "closing SSE stream: client key is no longer allowed on the channel", attrs...)
+}
+
 func setupSSEHeaders(w http.ResponseWriter, corsOrigin string) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -694,11 +805,19 @@ func writeSSEComment(w http.ResponseWriter, comment string) error {
 	return http.NewResponseController(w).Flush()
 }
 
-func encryptRelayEvent(event relayEvent, pubKey *[32]byte) (relayEvent, error) {
+// encryptRelayEvent encrypts event for the subscriber with pubKey, and signs
+// it for channel when the server has a signing key.
+func encryptRelayEvent(event relayEvent, channel string, pubKey *[32]byte, signingKey ed25519.PrivateKey) (relayEvent, error) {
 	if pubKey == nil {
 		return event, nil
 	}
-	encrypted, err := Encrypt(event.Data, pubKey)
+	var encrypted []byte
+	var err error
+	if signingKey != nil {
+		encrypted, err = EncryptSigned(event.Data, pubKey, signingKey, envelopeBinding{Channel: channel, StreamID: event.ID}, time.Now())
+	} else {
+		encrypted, err = Encrypt(event.Data, pubKey)
+	}
 	if err != nil {
 		return relayEvent{}, err
 	}
@@ -767,7 +886,11 @@ func handleEventsGet(eventBroker *EventBroker, protectedChannels *ProtectedChann
 
 		reqID := middleware.GetReqID(r.Context())
 
+		encoding := negotiateEncoding(r, eventBroker.compressMinSize)
 		setupSSEHeaders(w, corsOrigin)
+		if encoding != "" {
+			w.Header().Set(encodingHeader, encoding)
+		}
 
 		if err := writeSSEEvent(w, "", "", []byte(`{"message":"connected"}`)); err != nil {
 			eventBroker.logger.LogAttrs(r.Context(), slog.LevelWarn, "SSE initial write failed",
@@ -798,7 +921,14 @@ func handleEventsGet(eventBroker *EventBroker, protectedChannels *ProtectedChann
 				if !ok {
 					return
 				}
-				if err := writeSSEEvent(w, event.ID, "", event.Data); err != nil {
+				if !protectedChannels.StillAuthorized(channel, pubKey) {
+					logRevokedSubscriber(r.Context(), eventBroker.logger, reqID, channel, pubKey)
+					return
+				}
+				_, span := startRelayEventSpan(r.Context(), "gosmee.sse.send", channel, event, trace.WithSpanKind(trace.SpanKindProducer))
+				err := writeSSEEvent(w, event.ID, "", compressSSEData(encoding, eventBroker.compressMinSize, event.Data))
+				endSpan(span, err)
+				if err != nil {
 					eventBroker.logger.LogAttrs(r.Context(), slog.LevelWarn, "SSE event delivery failed",
 						slog.String("request_id", reqID),
 						slog.String("channel", channel), slog.String("delivery_id", event.DeliveryID),
@@ -808,6 +938,10 @@ func handleEventsGet(eventBroker *EventBroker, protectedChannels *ProtectedChann
 				}
 
 			case <-ticker.C:
+				if !protectedChannels.StillAuthorized(channel, pubKey) {
+					logRevokedSubscriber(r.Context(), eventBroker.logger, reqID, channel, pubKey)
+					return
+				}
 				if err := writeSSEComment(w, "keepalive"); err != nil {
 					eventBroker.logger.LogAttrs(r.Context(), slog.LevelWarn, "SSE keepalive write failed",
 						slog.String("request_id", reqID), slog.String("channel", channel),
@@ -819,6 +953,42 @@ func handleEventsGet(eventBroker *EventBroker, protectedChannels *ProtectedChann
 	}
 }
 
+// redisResumePosition returns the stream ID to read after for a subscriber
+// resuming from lastEventID, or starting live without it, and the gap event
+// to send first when that position was already trimmed from the stream. On
+// error, the status is the one to answer with.
+func redisResumePosition(ctx context.Context, redisRelay *redisPayloadRelay, channel, lastEventID string) (string, []byte, int, error) {
+	if lastEventID == "" {
+		newestID, exists, err := redisRelay.NewestID(ctx, channel)
+		if err != nil {
+			return "", nil, http.StatusInternalServerError, fmt.Errorf("read redis stream tail: %w", err)
+		}
+		if exists {
+			return newestID, nil, 0, nil
+		}
+		return "0-0", nil, 0, nil
+	}
+	if !isValidRedisStreamID(lastEventID) {
+		return "", nil, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID")
+	}
+
+	oldestID, exists, err := redisRelay.OldestID(ctx, channel)
+	if err != nil {
+		return "", nil, http.StatusInternalServerError, fmt.Errorf("read redis stream history: %w", err)
+	}
+	if exists {
+		cmp, err := compareRedisStreamIDs(lastEventID, oldestID)
+		if err != nil {
+			return "", nil, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID")
+		}
+		if cmp < 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: Redis stream history missed for channel %s: requested %s oldest retained %s\n", channel, lastEventID, oldestID) //nolint:gosec // stderr, not web output
+			return "0-0", []byte(fmt.Sprintf(`{"error":"missed_history","requested_id":%q,"oldest_id":%q}`, lastEventID, oldestID)), 0, nil
+		}
+	}
+	return lastEventID, nil, 0, nil
+}
+
 func handleRedisEventsGet(redisRelay *redisPayloadRelay, protectedChannels *ProtectedChannels, corsOrigin string, loggers ...*slog.Logger) http.HandlerFunc {
 	logger := slog.Default()
 	if len(loggers) > 0 && loggers[0] != nil {
@@ -834,51 +1004,19 @@ func handleRedisEventsGet(redisRelay *redisPayloadRelay, protectedChannels *Prot
 			return
 		}
 
-		lastEventID := r.Header.Get("Last-Event-ID")
-		readAfterID := ""
-		if lastEventID != "" {
-			if !isValidRedisStreamID(lastEventID) {
-				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
-				return
-			}
-			readAfterID = lastEventID
-		} else {
-			newestID, exists, err := redisRelay.NewestID(r.Context(), channel)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("read redis stream tail: %v", err), http.StatusInternalServerError)
-				return
-			}
-			if exists {
-				readAfterID = newestID
-			} else {
-				readAfterID = "0-0"
-			}
-		}
-
-		var gapEvent []byte
-		if lastEventID != "" {
-			oldestID, exists, err := redisRelay.OldestID(r.Context(), channel)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("read redis stream history: %v", err), http.StatusInternalServerError)
-				return
-			}
-			if exists {
-				cmp, err := compareRedisStreamIDs(lastEventID, oldestID)
-				if err != nil {
-					http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
-					return
-				}
-				if cmp < 0 {
-					fmt.Fprintf(os.Stderr, "WARNING: Redis stream history missed for channel %s: requested %s oldest retained %s\n", channel, lastEventID, oldestID) //nolint:gosec // stderr, not web output
-					readAfterID = "0-0"
-					gapEvent = []byte(fmt.Sprintf(`{"error":"missed_history","requested_id":%q,"oldest_id":%q}`, lastEventID, oldestID))
-				}
-			}
+		readAfterID, gapEvent, status, err := redisResumePosition(r.Context(), redisRelay, channel, r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
 		}
 
 		reqID := middleware.GetReqID(r.Context())
 
+		encoding := negotiateEncoding(r, redisRelay.compressMinSize)
 		setupSSEHeaders(w, corsOrigin)
+		if encoding != "" {
+			w.Header().Set(encodingHeader, encoding)
+		}
 		if err := writeSSEEvent(w, "", "", []byte(`{"message":"connected"}`)); err != nil {
 			logger.LogAttrs(r.Context(), slog.LevelWarn, "Redis SSE initial write failed",
 				slog.String("request_id", reqID), slog.String("channel", channel),
@@ -901,6 +1039,10 @@ func handleRedisEventsGet(redisRelay *redisPayloadRelay, protectedChannels *Prot
 		}
 
 		for {
+			if !protectedChannels.StillAuthorized(channel, pubKey) {
+				logRevokedSubscriber(r.Context(), logger, reqID, channel, pubKey)
+				return
+			}
 			events, err := redisRelay.Read(r.Context(), channel, readAfterID, 30*time.Second, 100)
 			if err != nil {
 				if r.Context().Err() != nil {
@@ -928,14 +1070,18 @@ func handleRedisEventsGet(redisRelay *redisPayloadRelay, protectedChannels *Prot
 				continue
 			}
 			for _, event := range events {
-				event, err = encryptRelayEvent(event, pubKey)
+				_, span := startRelayEventSpan(r.Context(), "gosmee.sse.send", channel, event, trace.WithSpanKind(trace.SpanKindProducer))
+				event, err = encryptRelayEvent(event, channel, pubKey, redisRelay.signingKey)
 				if err != nil {
+					endSpan(span, err)
 					logger.LogAttrs(r.Context(), slog.LevelWarn, "Redis SSE encryption failed",
 						slog.String("request_id", reqID), slog.String("channel", channel),
 						slog.String("stream_id", event.ID), slog.String("error", err.Error()))
 					continue
 				}
-				if err := writeSSEEvent(w, event.ID, "", event.Data); err != nil {
+				err = writeSSEEvent(w, event.ID, "", compressSSEData(encoding, redisRelay.compressMinSize, event.Data))
+				endSpan(span, err)
+				if err != nil {
 					logger.LogAttrs(r.Context(), slog.LevelWarn, "Redis SSE event delivery failed",
 						slog.String("request_id", reqID),
 						slog.String("channel", channel), slog.String("delivery_id", event.DeliveryID),
@@ -990,8 +1136,29 @@ func serve(c *cli.Context) error {
 	}
 	slog.SetDefault(logger)
 
+	shutdownTracing, err := setupTracingFromFlags(c, "gosmee-server")
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+	if endpoint := c.String("otlp-endpoint"); endpoint != "" {
+		logger.LogAttrs(ctx, slog.LevelInfo, "exporting traces", slog.String("otlp_endpoint", endpoint))
+	}
+
+	var signingKey ed25519.PrivateKey
+	if signingKeyFile := c.String("signing-key-file"); signingKeyFile != "" {
+		if signingKey, err = LoadSigningKey(signingKeyFile); err != nil {
+			return fmt.Errorf("load signing key: %w", err)
+		}
+		logger.LogAttrs(ctx, slog.LevelInfo, "signing encrypted events",
+			slog.String("public_key", EncodeSigningPublicKey(signingKey.Public().(ed25519.PublicKey))))
+	}
+
 	// Initialize the in-process event broker used by non-Redis mode.
 	eventBroker := NewEventBroker(logger)
+	eventBroker.signingKey = signingKey
+	compressMinSize := c.Int("compress-min-size")
+	eventBroker.compressMinSize = compressMinSize
 	localRelay := newLocalPayloadRelay(eventBroker)
 	var relay payloadRelay = localRelay
 	var redisRelay *redisPayloadRelay
@@ -1001,9 +1168,37 @@ func serve(c *cli.Context) error {
 			return fmt.Errorf("configure redis relay: %w", err)
 		}
 		defer redisRelay.Close()
+		redisRelay.signingKey = signingKey
+		redisRelay.compressMinSize = compressMinSize
+		redisRelay.compressEntries = c.Bool("redis-compress")
+		if dataKeyFile := c.String("redis-data-key-file"); dataKeyFile != "" {
+			dataKey, err := loadRedisDataKey(dataKeyFile)
+			if err != nil {
+				return fmt.Errorf("load redis data key: %w", err)
+			}
+			dataKeys, _ := redisRelay.client.(redisDataKeyClient)
+			perChannel := c.Bool("redis-channel-data-keys")
+			if perChannel && dataKeys == nil {
+				return fmt.Errorf("--redis-channel-data-keys is not supported by this redis client")
+			}
+			redisRelay.atRest = newRedisAtRest(dataKey, perChannel, dataKeys, protectedChannels.Has)
+			logger.LogAttrs(ctx, slog.LevelInfo, "encrypting protected channels at rest in redis",
+				slog.String("key_id", redisRelay.atRest.keyID), slog.Bool("channel_data_keys", perChannel))
+		} else if c.Bool("redis-channel-data-keys") {
+			return fmt.Errorf("--redis-channel-data-keys needs --redis-data-key-file")
+		}
 		relay = redisRelay
+		if hashes, ok := redisRelay.client.(redisHashClient); ok {
+			if err := protectedChannels.UseRedisRegistry(ctx, hashes); err != nil {
+				return fmt.Errorf("load enrolled keys: %w", err)
+			}
+		}
 		fmt.Fprintln(os.Stdout, "Using Redis Streams relay")
 	}
+	if redisRelay == nil {
+		localRelay.history = newLocalHistory(c.Int("history-size"), c.Int64("history-max-memory")*1024*1024)
+	}
+	go protectedChannels.Watch(ctx, logger, protectedChannelsReloadInterval)
 	autoCert := c.Bool("auto-cert")
 	certFile := c.String("tls-cert")
 	certKey := c.String("tls-key")
@@ -1048,6 +1243,34 @@ func serve(c *cli.Context) error {
 		mainRouter.Get(eventsPath, handleEventsGet(eventBroker, protectedChannels, corsOrigin))
 	}
 
+	// WebSocket endpoint, an alternative to SSE through proxies buffering it
+	if redisRelay != nil {
+		mainRouter.Get(wsPath, handleRedisEventsWebSocket(redisRelay, protectedChannels, corsOrigin, logger))
+	} else {
+		mainRouter.Get(wsPath, handleEventsWebSocket(eventBroker, protectedChannels, corsOrigin))
+	}
+
+	// Long-poll endpoint for clients that can only make plain requests
+	if redisRelay != nil {
+		mainRouter.Get(pollPath, handleRedisPollGet(redisRelay, protectedChannels, corsOrigin, logger))
+	} else {
+		pollSessions := newPollSessions(eventBroker)
+		go pollSessions.Run(ctx)
+		mainRouter.Get(pollPath, handlePollGet(pollSessions, protectedChannels, corsOrigin))
+	}
+
+	// Recent events of a channel, shown by its web page on open
+	if redisRelay != nil {
+		mainRouter.Get(historyPath, handleHistoryGet(redisRelay, signingKey, protectedChannels, corsOrigin, logger))
+	} else if localRelay.history != nil {
+		mainRouter.Get(historyPath, handleHistoryGet(localRelay.history, signingKey, protectedChannels, corsOrigin, logger))
+	}
+
+	// Key enrollment is authenticated with the channel admin token, not
+	// restricted by IP
+	mainRouter.Post(keysEnrollPath, handleKeyEnroll(protectedChannels, logger))
+	mainRouter.Post(keysRevokePath, handleKeyRevoke(protectedChannels, logger))
+
 	// Register POST routes on the restricted router
 	restrictedRouter.Post(channelPath, handleWebhookPost(c, relay, c.StringSlice("webhook-signature"), logger))
 	restrictedRouter.Post(replayPath, handleReplayPost(c, relay))
@@ -1057,7 +1280,7 @@ func serve(c *cli.Context) error {
 
 	// First mount the restrictedRouter to handle POST requests
 	finalRouter.Mount("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
+		if r.Method == http.MethodPost && !strings.HasPrefix(r.URL.Path, keysPathPrefix) {
 			restrictedRouter.ServeHTTP(w, r)
 		} else {
 			mainRouter.ServeHTTP(w, r)
@@ -2,6 +2,7 @@ package gosmee
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,11 +10,16 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	redisStreamKeyPrefix    = "gosmee:stream:"
 	redisStreamPayloadField = "payload"
+	// redisStreamCompressionField names the encoding of compressed payloads,
+	// entries without it are stored as is.
+	redisStreamCompressionField = "cmp"
 )
 
 type relayEvent struct {
@@ -21,6 +27,8 @@ type relayEvent struct {
 	Data       []byte
 	DeliveryID string
 	EventType  string
+	// SpanContext is the trace context of the webhook, when tracing is on.
+	SpanContext trace.SpanContext
 }
 
 func relayEventMetadata(data []byte) (deliveryID, eventType string) {
@@ -53,6 +61,7 @@ type payloadRelay interface {
 
 type localPayloadRelay struct {
 	eventBroker *EventBroker
+	history     *localHistory // keeps the last events of each channel when set
 }
 
 func newLocalPayloadRelay(eventBroker *EventBroker) *localPayloadRelay {
@@ -61,8 +70,13 @@ func newLocalPayloadRelay(eventBroker *EventBroker) *localPayloadRelay {
 	}
 }
 
-func (r *localPayloadRelay) Publish(_ context.Context, channel string, data []byte) (string, error) {
-	r.eventBroker.PublishEvent(channel, relayEvent{Data: data})
+func (r *localPayloadRelay) Publish(ctx context.Context, channel string, data []byte) (string, error) {
+	ctx, span := tracer().Start(ctx, "gosmee.relay.publish", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("gosmee.channel", channel)))
+	defer span.End()
+	event := relayEvent{Data: data, SpanContext: trace.SpanContextFromContext(ctx)}
+	event = r.history.add(channel, event)
+	r.eventBroker.PublishEvent(channel, event)
 	return "", nil
 }
 
@@ -75,9 +89,19 @@ type redisStreamClient interface {
 }
 
 type redisPayloadRelay struct {
-	client    redisStreamClient
-	keyPrefix string
-	maxLen    int64
+	client     redisStreamClient
+	keyPrefix  string
+	maxLen     int64
+	signingKey ed25519.PrivateKey // signs encrypted events when set
+	atRest     *redisAtRest       // encrypts the entries of protected channels when set
+	// compressMinSize is the size from which entries are stored compressed,
+	// with compressEntries, and events sent compressed to subscribers asking
+	// for it. 0 disables compression.
+	compressMinSize int
+	// compressEntries stores large entries compressed. It is opt-in since
+	// replicas older than the cmp field cannot read them, during a rolling
+	// upgrade for instance.
+	compressEntries bool
 }
 
 func newRedisPayloadRelay(ctx context.Context, redisURL string, maxLen int64) (*redisPayloadRelay, error) {
@@ -109,19 +133,37 @@ func newRedisPayloadRelayWithClient(client redisStreamClient, maxLen int64) *red
 	}
 }
 
-func (r *redisPayloadRelay) Publish(ctx context.Context, channel string, data []byte) (string, error) {
+func (r *redisPayloadRelay) Publish(ctx context.Context, channel string, data []byte) (id string, err error) {
+	ctx, span := tracer().Start(ctx, "gosmee.relay.publish", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("gosmee.channel", channel)))
+	defer func() {
+		span.SetAttributes(attribute.String("gosmee.stream_id", id))
+		endSpan(span, err)
+	}()
+
+	encoding := ""
+	if r.compressEntries && r.compressMinSize > 0 && len(data) >= r.compressMinSize {
+		if compressed, err := compressPayload(encodingZstd, data); err == nil && len(compressed) < len(data) {
+			data, encoding = compressed, encodingZstd
+		}
+	}
+	values, err := r.atRest.seal(ctx, channel, data)
+	if err != nil {
+		return "", fmt.Errorf("encrypt redis stream entry: %w", err)
+	}
+	if encoding != "" {
+		values[redisStreamCompressionField] = encoding
+	}
 	args := &redis.XAddArgs{
 		Stream: r.streamKey(channel),
-		Values: map[string]any{
-			redisStreamPayloadField: string(data),
-		},
+		Values: values,
 	}
 	if r.maxLen > 0 {
 		args.MaxLen = r.maxLen
 		args.Approx = true
 	}
 
-	id, err := r.client.XAdd(ctx, args).Result()
+	id, err = r.client.XAdd(ctx, args).Result()
 	if err != nil {
 		return "", fmt.Errorf("write to redis stream: %w", err)
 	}
@@ -144,22 +186,72 @@ func (r *redisPayloadRelay) Read(ctx context.Context, channel, afterID string, b
 	events := make([]relayEvent, 0)
 	for _, stream := range streams {
 		for _, message := range stream.Messages {
-			payload, ok := message.Values[redisStreamPayloadField]
-			if !ok {
-				return nil, fmt.Errorf("redis stream entry %s missing %q field", message.ID, redisStreamPayloadField)
-			}
-			data, err := redisPayloadBytes(payload)
+			started := time.Now()
+			event, err := r.decodeEntry(ctx, channel, message)
 			if err != nil {
-				return nil, fmt.Errorf("redis stream entry %s payload: %w", message.ID, err)
+				return nil, err
 			}
-			deliveryID, eventType := relayEventMetadata(data)
-			events = append(events, relayEvent{ID: message.ID, Data: data, DeliveryID: deliveryID, EventType: eventType})
+			_, span := startRelayEventSpan(ctx, "gosmee.relay.read", channel, event,
+				trace.WithSpanKind(trace.SpanKindConsumer), trace.WithTimestamp(started))
+			span.End()
+			events = append(events, event)
 		}
 	}
 
 	return events, nil
 }
 
+// History returns up to count events of the channel older than beforeID, or
+// the newest ones when beforeID is empty, newest first.
+func (r *redisPayloadRelay) History(ctx context.Context, channel, beforeID string, count int64) ([]relayEvent, error) {
+	end := "+"
+	if beforeID != "" {
+		if !isValidRedisStreamID(beforeID) {
+			return nil, errInvalidHistoryID
+		}
+		end = "(" + beforeID
+	}
+	messages, err := r.client.XRevRangeN(ctx, r.streamKey(channel), end, "-", count).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read redis stream history: %w", err)
+	}
+
+	events := make([]relayEvent, 0, len(messages))
+	for _, message := range messages {
+		event, err := r.decodeEntry(ctx, channel, message)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// decodeEntry decrypts and decompresses a stream entry of the channel.
+func (r *redisPayloadRelay) decodeEntry(ctx context.Context, channel string, message redis.XMessage) (relayEvent, error) {
+	payload, ok := message.Values[redisStreamPayloadField]
+	if !ok {
+		return relayEvent{}, fmt.Errorf("redis stream entry %s missing %q field", message.ID, redisStreamPayloadField)
+	}
+	data, err := redisPayloadBytes(payload)
+	if err != nil {
+		return relayEvent{}, fmt.Errorf("redis stream entry %s payload: %w", message.ID, err)
+	}
+	if data, err = r.atRest.open(ctx, channel, message.Values, data); err != nil {
+		return relayEvent{}, fmt.Errorf("redis stream entry %s: %w", message.ID, err)
+	}
+	if encoding, ok := message.Values[redisStreamCompressionField].(string); ok {
+		if data, err = decompressPayload(encoding, data); err != nil {
+			return relayEvent{}, fmt.Errorf("redis stream entry %s: %w", message.ID, err)
+		}
+	}
+	deliveryID, eventType := relayEventMetadata(data)
+	return relayEvent{ID: message.ID, Data: data, DeliveryID: deliveryID, EventType: eventType, SpanContext: relayEventSpanContext(data)}, nil
+}
+
 func redisPayloadBytes(value any) ([]byte, error) {
 	switch v := value.(type) {
 	case string:
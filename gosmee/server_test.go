@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -226,6 +227,7 @@ func newTestContext() *cli.Context {
 	flagSet := flag.NewFlagSet("test", 0)
 	flagSet.Int("max-body-size", 26214400, "doc")
 	flagSet.String("replay-token", "", "doc")
+	flagSet.Var(cli.NewStringSlice(), "webhook-signature", "doc")
 	return cli.NewContext(app, flagSet, nil)
 }
 
@@ -536,6 +538,80 @@ func TestHandleReplayPost(t *testing.T) {
 		assert.NilError(t, err)
 		assert.Assert(t, strings.Contains(string(body), "publish replay event: redis unavailable"))
 	})
+
+	t.Run("Full header set replaces the request headers", func(t *testing.T) {
+		replayFull := func(t *testing.T, webhookSecret, replayToken, body string) (*httptest.ResponseRecorder, map[string]any) {
+			t.Helper()
+			eventBroker := NewEventBroker()
+			subscriber := eventBroker.Subscribe("test-channel", nil)
+			defer eventBroker.Unsubscribe("test-channel", subscriber)
+			ctx := newTestContext()
+			if webhookSecret != "" {
+				assert.NilError(t, ctx.Set("webhook-signature", webhookSecret))
+			}
+			if replayToken != "" {
+				assert.NilError(t, ctx.Set("replay-token", replayToken))
+			}
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/replay/test-channel", strings.NewReader(body))
+			req.Header.Set("Content-Type", replayRequestContentType)
+			req.Header.Set("Origin", "https://browser.example.com")
+			if replayToken != "" {
+				req.Header.Set("Authorization", "Bearer "+replayToken)
+			}
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("channel", "test-channel")
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+			handleReplayPost(ctx, newLocalPayloadRelay(eventBroker))(w, req)
+			if w.Code != http.StatusAccepted {
+				return w, nil
+			}
+			var envelope map[string]any
+			event := <-subscriber.Events
+			assert.NilError(t, json.Unmarshal(event.Data, &envelope))
+			return w, envelope
+		}
+
+		w, envelope := replayFull(t, "", "", `{"headers":{"X-GitHub-Event":"pull_request","X-Hub-Signature-256":"sha256=old","Authorization":"Bearer x"},"body":"{\"action\": \"closed\"}"}`)
+		assert.Equal(t, w.Code, http.StatusAccepted)
+		assert.Equal(t, envelope["x-github-event"], "pull_request")
+		assert.Equal(t, envelope["x-hub-signature-256"], "sha256=old")
+		assert.Equal(t, envelope["content-type"], contentType)
+		assert.Assert(t, envelope["origin"] == nil)
+		assert.Assert(t, envelope["authorization"] == nil)
+		body, err := base64.StdEncoding.DecodeString(envelope["bodyB"].(string))
+		assert.NilError(t, err)
+		assert.Equal(t, string(body), `{"action": "closed"}`)
+
+		w, envelope = replayFull(t, "secret", "token", `{"headers":{"X-GitHub-Event":"pull_request","X-Hub-Signature-256":"sha256=old"},"body":{"action":"closed"},"resign":true}`)
+		assert.Equal(t, w.Code, http.StatusAccepted)
+		assert.Equal(t, envelope["x-hub-signature-256"], "sha256="+hmacSHA256Hex("secret", []byte(`{"action":"closed"}`)))
+
+		w, _ = replayFull(t, "", "token", `{"headers":{"X-GitHub-Event":"push"},"body":{},"resign":true}`)
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+		assert.Assert(t, strings.Contains(w.Body.String(), "no --webhook-signature secret"))
+		w, _ = replayFull(t, "", "", `{"headers":{"X-GitHub-Event":"push"}}`)
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Resign rejected without a replay token", func(t *testing.T) {
+		ctx := newTestContext()
+		assert.NilError(t, ctx.Set("webhook-signature", "secret"))
+		eventBroker := NewEventBroker()
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/replay/test-channel",
+			strings.NewReader(`{"headers":{"X-GitHub-Event":"push"},"body":{"forged":true},"resign":true}`))
+		req.Header.Set("Content-Type", replayRequestContentType)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("channel", "test-channel")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handleReplayPost(ctx, newLocalPayloadRelay(eventBroker))(w, req)
+
+		assert.Equal(t, w.Code, http.StatusForbidden)
+		assert.Assert(t, strings.Contains(w.Body.String(), "--replay-token"))
+	})
 }
 
 func TestHandleEventsGet(t *testing.T) {
@@ -677,6 +753,44 @@ func TestHandleEventsGet(t *testing.T) {
 		cancel()
 		<-done
 	})
+
+	t.Run("Closes Subscriber Of Revoked Key", func(t *testing.T) {
+		allowed := mustGeneratePublicKey(t)
+		protectedChannels = mustProtectedChannels(t, map[string][]string{
+			"test-channel": {allowed},
+		})
+		router = chi.NewRouter()
+		router.Get("/events/{channel:[a-zA-Z0-9_-]{12,64}}", handleEventsGet(eventBroker, protectedChannels, "*"))
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/events/test-channel?pubkey="+url.QueryEscape(allowed), nil)
+		response := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			router.ServeHTTP(response, req)
+			close(done)
+		}()
+
+		assert.Assert(t, eventually(t, func() bool {
+			eventBroker.RLock()
+			defer eventBroker.RUnlock()
+			return len(eventBroker.subscribers["test-channel"]) == 1
+		}))
+
+		data, err := json.Marshal(protectedChannelsFile{Channels: map[string]protectedChannelConfig{
+			"test-channel": {AllowedPublicKeys: []string{mustGeneratePublicKey(t)}},
+		}})
+		assert.NilError(t, err)
+		assert.NilError(t, os.WriteFile(protectedChannels.path, data, 0o600))
+		assert.NilError(t, protectedChannels.Reload())
+
+		eventBroker.Publish("test-channel", []byte(`{"secret":true}`))
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("subscriber of a revoked key was not closed")
+		}
+		assert.Assert(t, !strings.Contains(response.Body.String(), `"ciphertext"`))
+	})
 }
 
 func TestHandleEventsGetCORSOrigin(t *testing.T) {
@@ -780,6 +894,10 @@ func TestServeIndexAndNewURL(t *testing.T) {
 		assert.Assert(t, strings.Contains(bodyStr, "plainchannel1"))
 		// html/template contextually escapes JS strings, so "/" is rendered as `\/` in script blocks.
 		assert.Assert(t, strings.Contains(bodyStr, "/events/plainchannel1") || strings.Contains(bodyStr, "\\/events\\/plainchannel1"))
+		assert.Assert(t, strings.Contains(bodyStr, "/history/plainchannel1") || strings.Contains(bodyStr, "\\/history\\/plainchannel1"))
+		for _, id := range []string{`id="filter-text"`, `id="filter-type"`, `id="pause-events"`, `id="load-more"`, `id="replay-dialog"`} {
+			assert.Assert(t, strings.Contains(bodyStr, id), "missing %s", id)
+		}
 	})
 
 	t.Run("protected channel page is hidden", func(t *testing.T) {
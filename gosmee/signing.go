@@ -0,0 +1,152 @@
+package gosmee
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storedSigningKey is the server signing identity, an Ed25519 key. Only its
+// seed is kept, the rest of the private key is derived from it.
+type storedSigningKey struct {
+	Type      string `json:"type"`
+	PublicKey string `json:"public_key"`
+	Seed      string `json:"seed"`
+}
+
+const (
+	signingKeyType = "ed25519"
+	// signedEnvelopeMaxSkew is how far the time an envelope was sealed can be
+	// from the time the client received it. The server seals events when it
+	// sends them, history and resumed events included, so only clock skew
+	// and queueing in poll sessions separate them.
+	signedEnvelopeMaxSkew = 5 * time.Minute
+)
+
+// envelopeBinding is what a signed envelope is tied to besides its
+// ciphertext, an event can't be moved to another channel or stream ID
+// without breaking the signature.
+type envelopeBinding struct {
+	Channel  string
+	StreamID string
+}
+
+func GenerateSigningKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+func SaveSigningKey(path string, privateKey ed25519.PrivateKey) error {
+	return writeKeyFile(path, storedSigningKey{
+		Type:      signingKeyType,
+		PublicKey: EncodeSigningPublicKey(privateKey.Public().(ed25519.PublicKey)),
+		Seed:      base64.StdEncoding.EncodeToString(privateKey.Seed()),
+	})
+}
+
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stored storedSigningKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("unmarshal signing key file: %w", err)
+	}
+	if stored.Type != signingKeyType {
+		return nil, fmt.Errorf("signing key file %s is not an %s key, generate one with gosmee keygen --signing", path, signingKeyType)
+	}
+	seed, err := base64.StdEncoding.DecodeString(stored.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode signing key seed: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key seed length %d", len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func EncodeSigningPublicKey(key ed25519.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func ParseSigningPublicKey(encoded string) (ed25519.PublicKey, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode signing public key: %w", err)
+	}
+	if len(decoded) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signing public key length %d", len(decoded))
+	}
+	return ed25519.PublicKey(decoded), nil
+}
+
+// signedMessage is what the signature of a v2 envelope covers. None of the
+// fields can hold a newline.
+func signedMessage(envelope encryptedEnvelope) []byte {
+	return []byte(strings.Join([]string{
+		"gosmee-envelope-v2",
+		envelope.Channel,
+		envelope.StreamID,
+		strconv.FormatInt(envelope.Timestamp, 10),
+		envelope.Ephemeral,
+		envelope.Nonce,
+		envelope.Ciphertext,
+	}, "\n"))
+}
+
+// EncryptSigned encrypts plaintext like Encrypt and signs the result with
+// the server signing key, so clients pinning the server public key know the
+// event comes from the server, for this channel and stream ID.
+func EncryptSigned(plaintext []byte, recipientPubKey *[32]byte, signingKey ed25519.PrivateKey, binding envelopeBinding, now time.Time) ([]byte, error) {
+	envelope, err := sealEnvelope(plaintext, recipientPubKey)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Version = signedEnvelopeVersion
+	envelope.Channel = binding.Channel
+	envelope.StreamID = binding.StreamID
+	envelope.Timestamp = now.Unix()
+	envelope.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, signedMessage(envelope)))
+
+	return json.Marshal(envelope)
+}
+
+// DecryptVerified decrypts a signed envelope after checking its signature
+// against the pinned server key, that it was made for binding and sealed
+// within signedEnvelopeMaxSkew of receivedAt. The time check stops an old
+// event from being replayed where the stream ID does not tell it apart, like
+// the IDs of the in-process broker starting again when the server restarts.
+func DecryptVerified(data []byte, privateKey *[32]byte, serverKey ed25519.PublicKey, binding envelopeBinding, receivedAt time.Time) ([]byte, error) {
+	envelope, _, _, _, err := parseEncryptedEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Version != signedEnvelopeVersion {
+		return nil, fmt.Errorf("envelope is not signed by the server")
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	if !ed25519.Verify(serverKey, signedMessage(envelope), signature) {
+		return nil, fmt.Errorf("envelope signature does not match the server key")
+	}
+	if envelope.Channel != binding.Channel {
+		return nil, fmt.Errorf("envelope was signed for channel %q, not %q", envelope.Channel, binding.Channel)
+	}
+	if envelope.StreamID != binding.StreamID {
+		return nil, fmt.Errorf("envelope was signed for stream ID %q, not %q", envelope.StreamID, binding.StreamID)
+	}
+	sealedAt := time.Unix(envelope.Timestamp, 0)
+	if skew := receivedAt.Sub(sealedAt).Abs(); skew > signedEnvelopeMaxSkew {
+		return nil, fmt.Errorf("envelope was signed at %s, %s away from its receive time", sealedAt.UTC().Format(time.RFC3339), skew.Round(time.Second))
+	}
+
+	return Decrypt(data, privateKey)
+}
@@ -0,0 +1,108 @@
+package gosmee
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSaveAndLoadSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.json")
+	publicKey, privateKey, err := GenerateSigningKey()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveSigningKey(path, privateKey))
+
+	loaded, err := LoadSigningKey(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loaded, privateKey)
+
+	parsed, err := ParseSigningPublicKey(EncodeSigningPublicKey(publicKey))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, parsed, publicKey)
+
+	// a client keypair is not a signing key
+	clientPath := filepath.Join(t.TempDir(), "client.json")
+	clientPublicKey, clientPrivateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	assert.NilError(t, SaveKeyPair(clientPath, clientPublicKey, clientPrivateKey))
+	_, err = LoadSigningKey(clientPath)
+	assert.ErrorContains(t, err, "is not an ed25519 key")
+}
+
+func TestSignedEnvelope(t *testing.T) {
+	recipientPublicKey, recipientPrivateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	serverPublicKey, serverPrivateKey, err := GenerateSigningKey()
+	assert.NilError(t, err)
+	otherPublicKey, _, err := GenerateSigningKey()
+	assert.NilError(t, err)
+
+	plaintext := []byte(`{"body":"hello"}`)
+	binding := envelopeBinding{Channel: "test-channel", StreamID: "1700000000000-0"}
+	signed, err := EncryptSigned(plaintext, recipientPublicKey, serverPrivateKey, binding, time.Unix(1700000000, 0))
+	assert.NilError(t, err)
+	assert.Assert(t, IsEncrypted(signed))
+
+	receivedAt := time.Unix(1700000001, 0)
+	decrypted, err := DecryptVerified(signed, recipientPrivateKey, serverPublicKey, binding, receivedAt)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, decrypted, plaintext)
+	// clients that do not pin the server key still decrypt it
+	decrypted, err = Decrypt(signed, recipientPrivateKey)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, decrypted, plaintext)
+
+	_, err = DecryptVerified(signed, recipientPrivateKey, otherPublicKey, binding, receivedAt)
+	assert.ErrorContains(t, err, "does not match the server key")
+	_, err = DecryptVerified(signed, recipientPrivateKey, serverPublicKey, envelopeBinding{Channel: "other-channel", StreamID: binding.StreamID}, receivedAt)
+	assert.ErrorContains(t, err, `signed for channel "test-channel"`)
+	_, err = DecryptVerified(signed, recipientPrivateKey, serverPublicKey, envelopeBinding{Channel: binding.Channel, StreamID: "1700000000001-0"}, receivedAt)
+	assert.ErrorContains(t, err, `signed for stream ID "1700000000000-0"`)
+	// a replayed old event, or a clock too far off
+	_, err = DecryptVerified(signed, recipientPrivateKey, serverPublicKey, binding, receivedAt.Add(time.Hour))
+	assert.ErrorContains(t, err, "away from its receive time")
+	_, err = DecryptVerified(signed, recipientPrivateKey, serverPublicKey, binding, receivedAt.Add(-time.Hour))
+	assert.ErrorContains(t, err, "away from its receive time")
+
+	// moving the event to another channel breaks the signature
+	var envelope encryptedEnvelope
+	assert.NilError(t, json.Unmarshal(signed, &envelope))
+	envelope.Channel = "other-channel"
+	moved, err := json.Marshal(envelope)
+	assert.NilError(t, err)
+	_, err = DecryptVerified(moved, recipientPrivateKey, serverPublicKey, envelopeBinding{Channel: "other-channel", StreamID: binding.StreamID}, receivedAt)
+	assert.ErrorContains(t, err, "does not match the server key")
+
+	unsigned, err := Encrypt(plaintext, recipientPublicKey)
+	assert.NilError(t, err)
+	_, err = DecryptVerified(unsigned, recipientPrivateKey, serverPublicKey, binding, receivedAt)
+	assert.ErrorContains(t, err, "not signed by the server")
+}
+
+func TestClientRejectsUnverifiedEvents(t *testing.T) {
+	recipientPublicKey, recipientPrivateKey, err := GenerateKeyPair()
+	assert.NilError(t, err)
+	serverPublicKey, serverPrivateKey, err := GenerateSigningKey()
+	assert.NilError(t, err)
+
+	gs := goSmee{
+		replayDataOpts: &replayDataOpts{channel: "test-channel", serverSigningKey: serverPublicKey},
+		logger:         slog.New(slog.DiscardHandler),
+	}
+	event := relayEvent{ID: "1700000000000-0", Data: []byte(`{"x-github-event":"push","body":{}}`)}
+
+	// plaintext injected on a protected channel
+	_, err = gs.processClientEvent(time.Now(), clientSSEEvent{ID: event.ID, Data: event.Data}, recipientPrivateKey)
+	assert.Assert(t, isPermanentClientProcessingError(err))
+	assert.ErrorContains(t, err, "verifying message")
+
+	// an event signed for another channel
+	signed, err := encryptRelayEvent(event, "other-channel", recipientPublicKey, serverPrivateKey)
+	assert.NilError(t, err)
+	_, err = gs.processClientEvent(time.Now(), clientSSEEvent{ID: signed.ID, Data: signed.Data}, recipientPrivateKey)
+	assert.ErrorContains(t, err, `signed for channel "other-channel"`)
+}
@@ -0,0 +1,254 @@
+package gosmee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing is off unless --otlp-endpoint or --otlp-traces-endpoint is set.
+// As in the OpenTelemetry specification, /v1/traces is appended to the path
+// of --otlp-endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) while
+// --otlp-traces-endpoint (OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is the full
+// URL of the traces. The server traces the webhook
+// receive, the relay publish and read and the send to each subscriber, the
+// client the parse, each delivery attempt and the exec of each event.
+//
+// The trace context travels inside the relayed envelope as the traceparent
+// and tracestate W3C headers, so it survives Redis and protected channel
+// encryption, and the client forwards its own traceparent to the target.
+const (
+	tracerName        = "github.com/chmouel/gosmee"
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+	otlpTracesPath    = "/v1/traces"
+	// tracingShutdownTimeout bounds the flush of the spans left on exit.
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+var (
+	tracePropagator = propagation.TraceContext{}
+	// tracingEnabled skips looking for a trace context in every relayed
+	// event when tracing is off.
+	tracingEnabled atomic.Bool
+)
+
+var tracingFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "otlp-endpoint",
+		Usage:   "OTLP/HTTP collector base URL to export OpenTelemetry traces to, /v1/traces is appended to it, ie: http://localhost:4318. Tracing is off when neither this nor --otlp-traces-endpoint is set",
+		EnvVars: []string{"GOSMEE_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"},
+	},
+	&cli.StringFlag{
+		Name:    "otlp-traces-endpoint",
+		Usage:   "Full OTLP/HTTP URL to export OpenTelemetry traces to, used as is and preferred over --otlp-endpoint, ie: http://localhost:4318/v1/traces",
+		EnvVars: []string{"GOSMEE_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"},
+	},
+	&cli.Float64Flag{
+		Name:    "trace-sample-ratio",
+		Usage:   "Ratio of the traces started by gosmee to sample, between 0 and 1",
+		Value:   1,
+		EnvVars: []string{"GOSMEE_TRACE_SAMPLE_RATIO"},
+	},
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// otlpTracesURL returns the URL to export traces to, tracesEndpoint as is or
+// endpoint with the traces path appended, empty when both are.
+func otlpTracesURL(endpoint, tracesEndpoint string) (string, error) {
+	if tracesEndpoint != "" {
+		tracesURL, err := url.Parse(tracesEndpoint)
+		if err != nil || tracesURL.Host == "" {
+			return "", fmt.Errorf("invalid --otlp-traces-endpoint %q, expected a URL like http://localhost:4318/v1/traces", tracesEndpoint)
+		}
+		return tracesURL.String(), nil
+	}
+	if endpoint == "" {
+		return "", nil
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return "", fmt.Errorf("invalid --otlp-endpoint %q, expected a URL like http://localhost:4318", endpoint)
+	}
+	endpointURL.Path = strings.TrimSuffix(endpointURL.Path, "/") + otlpTracesPath
+	return endpointURL.String(), nil
+}
+
+// setupTracing exports traces to the URL given by endpoint or
+// tracesEndpoint, see otlpTracesURL, as serviceName and returns the function
+// flushing them on exit. Without either, tracing stays off.
+func setupTracing(ctx context.Context, endpoint, tracesEndpoint, serviceName string, sampleRatio float64) (func(), error) {
+	tracesURL, err := otlpTracesURL(endpoint, tracesEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if tracesURL == "" {
+		return func() {}, nil
+	}
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("invalid --trace-sample-ratio %v, expected a ratio between 0 and 1", sampleRatio)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(tracesURL))
+	if err != nil {
+		return nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+		attribute.String("service.version", strings.TrimSpace(string(Version))),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create trace resource: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES still win
+	if fromEnv, err := resource.New(ctx, resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, fromEnv); err == nil {
+			res = merged
+		}
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	enableTracing(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		_ = provider.Shutdown(ctx)
+	}, nil
+}
+
+func setupTracingFromFlags(c *cli.Context, serviceName string) (func(), error) {
+	return setupTracing(c.Context, c.String("otlp-endpoint"), c.String("otlp-traces-endpoint"), serviceName, c.Float64("trace-sample-ratio"))
+}
+
+func enableTracing(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracePropagator)
+	tracingEnabled.Store(true)
+}
+
+// endSpan records err on span, when set, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// headerMapCarrier carries a trace context in the header maps of payloadMsg
+// and of the relayed envelope, whose keys are not canonical.
+type headerMapCarrier map[string]string
+
+func (h headerMapCarrier) Get(key string) string {
+	for k, v := range h {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (h headerMapCarrier) Set(key, value string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+	h[http.CanonicalHeaderKey(key)] = value
+}
+
+func (h headerMapCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// injectEnvelopeTrace stores the trace context of ctx in an envelope, with
+// the lower case keys of the other headers.
+func injectEnvelopeTrace(ctx context.Context, envelope map[string]any) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	for key, value := range carrier {
+		envelope[key] = value
+	}
+}
+
+// relayEventSpanContext returns the trace context stored in the envelope
+// of a relayed event, invalid when there is none or tracing is off.
+func relayEventSpanContext(data []byte) trace.SpanContext {
+	if !tracingEnabled.Load() {
+		return trace.SpanContext{}
+	}
+	var envelope struct {
+		Traceparent string `json:"traceparent"`
+		Tracestate  string `json:"tracestate"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Traceparent == "" {
+		return trace.SpanContext{}
+	}
+	ctx := tracePropagator.Extract(context.Background(), propagation.MapCarrier{
+		traceparentHeader: envelope.Traceparent,
+		tracestateHeader:  envelope.Tracestate,
+	})
+	return trace.SpanContextFromContext(ctx)
+}
+
+// startRelayEventSpan starts a span for a relayed event, in the trace of the
+// webhook that published it.
+func startRelayEventSpan(ctx context.Context, name, channel string, event relayEvent, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if event.SpanContext.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, event.SpanContext)
+	}
+	opts = append(opts, trace.WithAttributes(
+		attribute.String("gosmee.channel", channel),
+		attribute.String("gosmee.stream_id", event.ID),
+		attribute.String("gosmee.delivery_id", event.DeliveryID),
+		attribute.String("gosmee.event_type", event.EventType),
+	))
+	return tracer().Start(ctx, name, opts...)
+}
+
+// startClientEventSpan starts the span of the processing of an event by the
+// client, in the trace of the webhook, and records its parse from started.
+// The headers of pm carry the new span to the delivery attempts.
+func startClientEventSpan(pm payloadMsg, started time.Time) (context.Context, trace.Span) {
+	ctx := tracePropagator.Extract(context.Background(), headerMapCarrier(pm.headers))
+	ctx, span := tracer().Start(ctx, "gosmee.client.event",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(started),
+		trace.WithAttributes(
+			attribute.String("gosmee.stream_id", pm.streamID),
+			attribute.String("gosmee.delivery_id", pm.eventID),
+			attribute.String("gosmee.event_type", pm.eventType),
+		))
+	_, parse := tracer().Start(ctx, "gosmee.client.parse", trace.WithTimestamp(started))
+	parse.End()
+	if tracingEnabled.Load() {
+		tracePropagator.Inject(ctx, headerMapCarrier(pm.headers))
+	}
+	return ctx, span
+}
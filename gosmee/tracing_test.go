@@ -0,0 +1,177 @@
+package gosmee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gotest.tools/v3/assert"
+)
+
+// enableTestTracing records the spans in memory until the end of the test.
+func enableTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	enableTracing(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		tracingEnabled.Store(false)
+	})
+	return exporter
+}
+
+func spanNames(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	names := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		names[span.Name] = span
+	}
+	return names
+}
+
+func TestSetupTracing(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), "", "", "gosmee-test", 1)
+	assert.NilError(t, err)
+	shutdown()
+	assert.Assert(t, !tracingEnabled.Load())
+
+	_, err = setupTracing(context.Background(), "localhost:4318", "", "gosmee-test", 1)
+	assert.ErrorContains(t, err, "invalid --otlp-endpoint")
+	_, err = setupTracing(context.Background(), "", "localhost:4318/v1/traces", "gosmee-test", 1)
+	assert.ErrorContains(t, err, "invalid --otlp-traces-endpoint")
+	_, err = setupTracing(context.Background(), "http://localhost:4318", "", "gosmee-test", 2)
+	assert.ErrorContains(t, err, "invalid --trace-sample-ratio")
+}
+
+func TestOTLPTracesURL(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint, tracesEndpoint, want string
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "http://localhost:4318/", want: "http://localhost:4318/v1/traces"},
+		// a base path is kept, the traces path is appended to it
+		{endpoint: "https://collector.example.com/otlp", want: "https://collector.example.com/otlp/v1/traces"},
+		{endpoint: "http://localhost:4318", tracesEndpoint: "http://localhost:4318/custom/traces", want: "http://localhost:4318/custom/traces"},
+		{tracesEndpoint: "http://localhost:4318/v1/traces", want: "http://localhost:4318/v1/traces"},
+		{},
+	} {
+		got, err := otlpTracesURL(tt.endpoint, tt.tracesEndpoint)
+		assert.NilError(t, err)
+		assert.Equal(t, got, tt.want)
+	}
+}
+
+func TestHeaderMapCarrier(t *testing.T) {
+	headers := headerMapCarrier{"Traceparent": "old", "X-Github-Event": "push"}
+	assert.Equal(t, headers.Get("traceparent"), "old")
+	headers.Set("traceparent", "new")
+	assert.DeepEqual(t, map[string]string(headers), map[string]string{"Traceparent": "new", "X-Github-Event": "push"})
+}
+
+func TestTracingWebhookToTarget(t *testing.T) {
+	exporter := enableTestTracing(t)
+
+	// server: a provider trace context is the parent of the receive
+	providerCtx, providerSpan := tracer().Start(context.Background(), "provider")
+	providerSpan.End()
+	eventBroker := NewEventBroker()
+	subscriber := eventBroker.Subscribe("test-channel", nil)
+	router := chi.NewRouter()
+	router.Post("/{channel}", handleWebhookPost(newTestContext(), newLocalPayloadRelay(eventBroker), nil))
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/test-channel", bytes.NewReader([]byte(`{"action":"opened"}`)))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	req.Header.Set("X-GitHub-Delivery", "abc")
+	tracePropagator.Inject(providerCtx, propagation.HeaderCarrier(req.Header))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusAccepted)
+
+	event := <-subscriber.Events
+	traceID := providerSpan.SpanContext().TraceID()
+	assert.Equal(t, event.SpanContext.TraceID(), traceID)
+	var envelope map[string]any
+	assert.NilError(t, json.Unmarshal(event.Data, &envelope))
+	assert.Assert(t, envelope["traceparent"] != traceparentOf(providerCtx), "the envelope carries the receive span")
+
+	// client: the target joins the trace as a child of the delivery attempt
+	var forwarded string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	gs := newTestGoSmeeForProcessing(&replayDataOpts{targetURL: target.URL, execCommand: "true"})
+	processed, err := gs.processClientEvent(time.Now().UTC(), clientSSEEvent{Data: event.Data}, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, processed)
+
+	spans := spanNames(exporter.GetSpans())
+	for _, name := range []string{"gosmee.webhook.receive", "gosmee.relay.publish", "gosmee.client.event", "gosmee.client.parse", "gosmee.client.deliver", "gosmee.client.exec"} {
+		span, ok := spans[name]
+		assert.Assert(t, ok, "missing span %s", name)
+		assert.Equal(t, span.SpanContext.TraceID(), traceID, name)
+	}
+	assert.Equal(t, spans["gosmee.webhook.receive"].Parent.SpanID(), providerSpan.SpanContext().SpanID())
+	assert.Equal(t, spans["gosmee.client.event"].Parent.SpanID(), spans["gosmee.webhook.receive"].SpanContext.SpanID())
+	assert.Equal(t, spans["gosmee.client.deliver"].Parent.SpanID(), spans["gosmee.client.event"].SpanContext.SpanID())
+	assert.Equal(t, forwarded, traceparentOf(trace.ContextWithSpanContext(context.Background(), spans["gosmee.client.deliver"].SpanContext)))
+}
+
+// traceparentOf returns the traceparent header of the span of ctx.
+func traceparentOf(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	return carrier[traceparentHeader]
+}
+
+func TestTracingRedisRead(t *testing.T) {
+	exporter := enableTestTracing(t)
+	ctx, span := tracer().Start(context.Background(), "gosmee.webhook.receive")
+	span.End()
+	envelope := map[string]any{"x-github-event": "push"}
+	injectEnvelopeTrace(ctx, envelope)
+	data, err := json.Marshal(envelope)
+	assert.NilError(t, err)
+
+	relay := newRedisPayloadRelayWithClient(&fakeRedisStreamClient{
+		xreadResults: [][]redis.XStream{{{
+			Stream:   "gosmee:stream:test-channel",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]any{"payload": string(data)}}},
+		}}},
+	}, 0)
+	events, err := relay.Read(context.Background(), "test-channel", "0-0", 0, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].SpanContext.SpanID(), span.SpanContext().SpanID())
+
+	read, ok := spanNames(exporter.GetSpans())["gosmee.relay.read"]
+	assert.Assert(t, ok)
+	assert.Equal(t, read.Parent.SpanID(), span.SpanContext().SpanID())
+}
+
+func TestTracingExecTraceparent(t *testing.T) {
+	enableTestTracing(t)
+	ctx, span := tracer().Start(context.Background(), "gosmee.client.event")
+	defer span.End()
+	out := filepath.Join(t.TempDir(), "traceparent")
+	opts := &replayDataOpts{execCommand: `printf %s "$TRACEPARENT" > ` + out}
+	assert.NilError(t, runExecCommand(ctx, opts, newTestGoSmeeForProcessing(opts).logger, payloadMsg{body: []byte(`{}`)}))
+	data, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Assert(t, len(data) == 55, "unexpected TRACEPARENT %q", data)
+	assert.Equal(t, string(data[3:35]), span.SpanContext().TraceID().String())
+}
@@ -0,0 +1,245 @@
+package gosmee
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+)
+
+// requestTransforms rewrites a payload before it is forwarded to the target.
+// Header values, the body and the target URL are Go text/templates rendered
+// against transformData.
+type requestTransforms struct {
+	setHeaders    []headerTemplate
+	removeHeaders []string
+	renameHeaders map[string]string
+	bodyTemplate  *template.Template
+	urlTemplate   *template.Template
+}
+
+type headerTemplate struct {
+	name  string
+	value *template.Template
+}
+
+// transformData is the data made available to the transform templates.
+type transformData struct {
+	Headers     map[string]string
+	Body        any
+	RawBody     string
+	ContentType string
+	EventType   string
+	EventID     string
+	Timestamp   string
+	TargetURL   string
+}
+
+var transformFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"toJSON": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+	"get": lookupJSONPath,
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+func newRequestTransforms(setHeaders, removeHeaders, renameHeaders []string, bodyTemplateFile, urlTemplate string) (*requestTransforms, error) {
+	if len(setHeaders) == 0 && len(removeHeaders) == 0 && len(renameHeaders) == 0 && bodyTemplateFile == "" && urlTemplate == "" {
+		return nil, nil
+	}
+
+	t := &requestTransforms{
+		removeHeaders: removeHeaders,
+		renameHeaders: make(map[string]string, len(renameHeaders)),
+	}
+	for _, h := range setHeaders {
+		name, value, ok := strings.Cut(h, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --set-header %q, expected NAME=VALUE", h)
+		}
+		tmpl, err := template.New(name).Funcs(transformFuncs).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse --set-header %q: %w", name, err)
+		}
+		t.setHeaders = append(t.setHeaders, headerTemplate{name: name, value: tmpl})
+	}
+	for _, h := range renameHeaders {
+		from, to, ok := strings.Cut(h, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid --rename-header %q, expected OLD=NEW", h)
+		}
+		t.renameHeaders[http.CanonicalHeaderKey(from)] = to
+	}
+	if bodyTemplateFile != "" {
+		data, err := os.ReadFile(bodyTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("read body template: %w", err)
+		}
+		t.bodyTemplate, err = template.New("body").Funcs(transformFuncs).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("parse body template %s: %w", bodyTemplateFile, err)
+		}
+	}
+	if urlTemplate != "" {
+		var err error
+		t.urlTemplate, err = template.New("url").Funcs(transformFuncs).Parse(urlTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parse target url template: %w", err)
+		}
+	}
+	return t, nil
+}
+
+func newRequestTransformsFromFlags(c *cli.Context) (*requestTransforms, error) {
+	return newRequestTransforms(
+		c.StringSlice("set-header"),
+		c.StringSlice("remove-header"),
+		c.StringSlice("rename-header"),
+		c.String("body-template"),
+		c.String("target-url-template"),
+	)
+}
+
+// Apply returns a transformed copy of pm. The original message is left
+// untouched so callers can still save it when --save-original is set.
+func (t *requestTransforms) Apply(pm payloadMsg, targetURL string) (payloadMsg, error) {
+	if t == nil {
+		return pm, nil
+	}
+
+	out := pm
+	out.headers = maps.Clone(pm.headers)
+	if out.headers == nil {
+		out.headers = make(map[string]string)
+	}
+
+	data := transformData{
+		Headers:     pm.headers,
+		RawBody:     string(pm.body),
+		ContentType: pm.contentType,
+		EventType:   pm.eventType,
+		EventID:     pm.eventID,
+		Timestamp:   pm.timestamp,
+		TargetURL:   targetURL,
+	}
+	if len(pm.body) > 0 {
+		var body any
+		if err := json.Unmarshal(pm.body, &body); err == nil {
+			data.Body = body
+		}
+	}
+
+	for _, name := range t.removeHeaders {
+		deleteHeader(out.headers, name)
+	}
+	// a removed header is not renamed, and a renamed one is not renamed
+	// again when its new name is renamed too
+	renamed := map[string]string{}
+	for k, v := range out.headers {
+		to, ok := t.renameHeaders[http.CanonicalHeaderKey(k)]
+		if !ok {
+			continue
+		}
+		delete(out.headers, k)
+		renamed[to] = v
+	}
+	for to, v := range renamed {
+		deleteHeader(out.headers, to)
+		out.headers[to] = v
+	}
+	for _, h := range t.setHeaders {
+		value, err := renderTemplate(h.value, data)
+		if err != nil {
+			return pm, fmt.Errorf("render header %s: %w", h.name, err)
+		}
+		deleteHeader(out.headers, h.name)
+		out.headers[h.name] = value
+	}
+	if ct, ok := headerValue(out.headers, "Content-Type"); ok {
+		out.contentType = ct
+	}
+
+	if t.bodyTemplate != nil {
+		body, err := renderTemplate(t.bodyTemplate, data)
+		if err != nil {
+			return pm, fmt.Errorf("render body template: %w", err)
+		}
+		out.body = []byte(body)
+	}
+	if t.urlTemplate != nil {
+		u, err := renderTemplate(t.urlTemplate, data)
+		if err != nil {
+			return pm, fmt.Errorf("render target url template: %w", err)
+		}
+		out.targetURL = strings.TrimSpace(u)
+	}
+	return out, nil
+}
+
+func renderTemplate(tmpl *template.Template, data transformData) (string, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// deleteHeader removes name from headers regardless of its case.
+func deleteHeader(headers map[string]string, name string) {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			delete(headers, k)
+		}
+	}
+}
+
+func headerValue(headers map[string]string, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// lookupJSONPath walks a decoded JSON document following a dotted path such
+// as "repository.owner.login" or "commits.0.id".
+func lookupJSONPath(v any, path string) any {
+	if path == "" || path == "." {
+		return v
+	}
+	for part := range strings.SplitSeq(strings.TrimPrefix(path, "."), ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[part]
+		case []any:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil
+			}
+			v = node[idx]
+		default:
+			return nil
+		}
+	}
+	return v
+}
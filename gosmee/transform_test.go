@@ -0,0 +1,174 @@
+package gosmee
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNewRequestTransformsDisabled(t *testing.T) {
+	transforms, err := newRequestTransforms(nil, nil, nil, "", "")
+	assert.NilError(t, err)
+	assert.Assert(t, transforms == nil)
+
+	pm := payloadMsg{headers: map[string]string{"X-Foo": "bar"}, body: []byte(`{}`)}
+	out, err := transforms.Apply(pm, "http://localhost")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, out.headers, pm.headers)
+}
+
+func TestNewRequestTransformsInvalid(t *testing.T) {
+	_, err := newRequestTransforms([]string{"NoEquals"}, nil, nil, "", "")
+	assert.ErrorContains(t, err, "expected NAME=VALUE")
+
+	_, err = newRequestTransforms(nil, nil, []string{"Old="}, "", "")
+	assert.ErrorContains(t, err, "expected OLD=NEW")
+
+	_, err = newRequestTransforms([]string{"X-Foo={{ .Nope"}, nil, nil, "", "")
+	assert.ErrorContains(t, err, "parse --set-header")
+
+	_, err = newRequestTransforms(nil, nil, nil, filepath.Join(t.TempDir(), "missing.tmpl"), "")
+	assert.ErrorContains(t, err, "read body template")
+}
+
+func TestRequestTransformsApply(t *testing.T) {
+	t.Setenv("GOSMEE_TEST_TOKEN", "s3cret")
+	bodyTemplate := filepath.Join(t.TempDir(), "body.tmpl")
+	assert.NilError(t, os.WriteFile(bodyTemplate,
+		[]byte(`{"repo":{{ get .Body "repository.full_name" | toJSON }},"sha":{{ get .Body "commits.0.id" | toJSON }},"event":"{{ .EventType }}"}`), 0o600))
+
+	transforms, err := newRequestTransforms(
+		[]string{`Authorization=Bearer {{ env "GOSMEE_TEST_TOKEN" }}`, "Host=internal.example.com"},
+		[]string{"x-hub-signature-256"},
+		[]string{"X-GitHub-Delivery=X-Delivery-Id"},
+		bodyTemplate,
+		"http://localhost:8080/hooks/{{ .EventType }}",
+	)
+	assert.NilError(t, err)
+
+	pm := payloadMsg{
+		headers: map[string]string{
+			"X-Github-Event":      "push",
+			"X-Github-Delivery":   "abc",
+			"X-Hub-Signature-256": "sha256=deadbeef",
+		},
+		body:        []byte(`{"repository":{"full_name":"org/repo"},"commits":[{"id":"1234"}]}`),
+		contentType: "application/json",
+		eventType:   "push",
+		eventID:     "abc",
+	}
+	out, err := transforms.Apply(pm, "http://localhost:8080")
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, out.headers, map[string]string{
+		"X-Github-Event": "push",
+		"X-Delivery-Id":  "abc",
+		"Authorization":  "Bearer s3cret",
+		"Host":           "internal.example.com",
+	})
+	assert.Equal(t, string(out.body), `{"repo":"org/repo","sha":"1234","event":"push"}`)
+	assert.Equal(t, out.targetURL, "http://localhost:8080/hooks/push")
+
+	// the original payload must not be modified
+	assert.Equal(t, pm.headers["X-Hub-Signature-256"], "sha256=deadbeef")
+	assert.Equal(t, pm.targetURL, "")
+}
+
+func TestRequestTransformsRemoveAndRename(t *testing.T) {
+	transforms, err := newRequestTransforms(nil,
+		[]string{"X-Hub-Signature"},
+		[]string{"X-Hub-Signature=X-Signature", "X-GitHub-Delivery=X-Delivery-Id", "X-Delivery-Id=X-Id"},
+		"", "")
+	assert.NilError(t, err)
+
+	out, err := transforms.Apply(payloadMsg{headers: map[string]string{
+		"X-Hub-Signature":   "sha1=deadbeef",
+		"X-Github-Delivery": "abc",
+		"X-Delivery-Id":     "old",
+	}}, "")
+	assert.NilError(t, err)
+	// removed before being renamed, and renamed only once
+	assert.DeepEqual(t, out.headers, map[string]string{
+		"X-Delivery-Id": "abc",
+		"X-Id":          "old",
+	})
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	doc := map[string]any{
+		"a": map[string]any{"b": []any{"zero", map[string]any{"c": "deep"}}},
+	}
+	assert.Equal(t, lookupJSONPath(doc, "a.b.0"), "zero")
+	assert.Equal(t, lookupJSONPath(doc, ".a.b.1.c"), "deep")
+	assert.Assert(t, lookupJSONPath(doc, "a.b.5") == nil)
+	assert.Assert(t, lookupJSONPath(doc, "a.x.y") == nil)
+	assert.DeepEqual(t, lookupJSONPath(doc, "."), doc)
+}
+
+func TestTransformPayloadSaveOriginal(t *testing.T) {
+	transforms, err := newRequestTransforms([]string{"X-Added=yes"}, nil, nil, "", "")
+	assert.NilError(t, err)
+	pm := payloadMsg{headers: map[string]string{"X-Github-Event": "push"}, body: []byte(`{}`)}
+
+	forwarded, saved, err := transformPayload(&replayDataOpts{transforms: transforms}, pm)
+	assert.NilError(t, err)
+	assert.Equal(t, forwarded.headers["X-Added"], "yes")
+	assert.Equal(t, saved.headers["X-Added"], "yes")
+
+	forwarded, saved, err = transformPayload(&replayDataOpts{transforms: transforms, saveOriginal: true}, pm)
+	assert.NilError(t, err)
+	assert.Equal(t, forwarded.headers["X-Added"], "yes")
+	_, ok := saved.headers["X-Added"]
+	assert.Assert(t, !ok)
+}
+
+func TestReplayDataTransformedRequest(t *testing.T) {
+	var gotHost, gotPath, gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transforms, err := newRequestTransforms(
+		[]string{"Authorization=token", "Host=rewritten.example.com"}, nil, nil, "",
+		server.URL+"/{{ .EventType }}")
+	assert.NilError(t, err)
+	ropts := &replayDataOpts{targetURL: server.URL, targetCnxTimeout: 1, transforms: transforms}
+	pm := payloadMsg{headers: map[string]string{"X-Github-Event": "push"}, body: []byte(`{"a":1}`), eventType: "push", contentType: "application/json"}
+
+	forwarded, _, err := transformPayload(ropts, pm)
+	assert.NilError(t, err)
+	assert.NilError(t, replayData(ropts, slog.New(slog.DiscardHandler), forwarded))
+	assert.Equal(t, gotHost, "rewritten.example.com")
+	assert.Equal(t, gotPath, "/push")
+	assert.Equal(t, gotAuth, "token")
+	assert.Equal(t, gotBody, `{"a":1}`)
+}
+
+func TestSaveDataUsesTransformedTargetURL(t *testing.T) {
+	dir := t.TempDir()
+	ropts := &replayDataOpts{saveDir: dir, targetURL: "http://localhost:8080"}
+	pm := payloadMsg{
+		headers:   map[string]string{"X-Github-Event": "push"},
+		body:      []byte(`{}`),
+		eventType: "push",
+		timestamp: "2024-01-01T00.00.00.000",
+		targetURL: "http://localhost:9090/push",
+	}
+	assert.NilError(t, saveData(ropts, slog.New(slog.DiscardHandler), pm))
+	script, err := os.ReadFile(filepath.Join(dir, "push-2024-01-01T00.00.00.000.sh"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(script), `targetURL="http://localhost:9090/push"`))
+}
@@ -0,0 +1,496 @@
+package gosmee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The WebSocket transport carries the same events as the SSE one, as JSON
+// text messages:
+//
+//	server: {"type":"ready"}
+//	server: {"type":"event","id":"<stream id>","data":"<SSE data>"}
+//	server: {"type":"gap","data":"<gosmee-gap SSE data>"}
+//	client: {"type":"ack","id":"<stream id>"}
+//	both:   {"type":"heartbeat"}
+//
+// Clients resume with the Last-Event-ID header, or the last_event_id query
+// parameter, and ask for compression as for SSE. A side not hearing from the
+// other for wsIdleTimeout closes the connection. In Redis mode, the server
+// sends at most wsAckWindow events not acked yet.
+const (
+	wsPath              = "/ws/{channel:" + channelIDPattern + "}"
+	wsLastEventIDParam  = "last_event_id"
+	wsMessageReady      = "ready"
+	wsMessageEvent      = "event"
+	wsMessageGap        = "gap"
+	wsMessageAck        = "ack"
+	wsMessageHeartbeat  = "heartbeat"
+	wsHeartbeatInterval = 30 * time.Second
+	wsIdleTimeout       = 3 * wsHeartbeatInterval
+	wsWriteTimeout      = 10 * time.Second
+	wsAckWindow         = 100
+	wsReadLimit         = 1 << 20
+
+	transportSSE       = "sse"
+	transportWebSocket = "websocket"
+	transportPoll      = "poll"
+)
+
+type wsMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Data string `json:"data,omitempty"`
+}
+
+// wsSubscriber is the server side of a WebSocket subscription.
+type wsSubscriber struct {
+	conn            *websocket.Conn
+	channel         string
+	encoding        string
+	compressMinSize int
+
+	sent     atomic.Int64
+	acked    atomic.Int64
+	ackedCh  chan struct{}
+	lastAck  atomic.Value // string
+	lastSeen atomic.Int64 // unix nanoseconds
+}
+
+// acceptWebSocket upgrades the connection of an authorized subscriber. The
+// allowed origins follow --cors-origin.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, corsOrigin string, compressMinSize int) (*wsSubscriber, bool) {
+	options := &websocket.AcceptOptions{}
+	switch corsOrigin {
+	case "":
+	case "*":
+		options.InsecureSkipVerify = true
+	default:
+		if origin, err := url.Parse(corsOrigin); err == nil && origin.Host != "" {
+			options.OriginPatterns = []string{origin.Host}
+		}
+	}
+	encoding := negotiateEncoding(r, compressMinSize)
+	if encoding != "" {
+		w.Header().Set(encodingHeader, encoding)
+	}
+	conn, err := websocket.Accept(w, r, options)
+	if err != nil {
+		// Accept has answered the request already
+		return nil, false
+	}
+	conn.SetReadLimit(wsReadLimit)
+
+	subscriber := &wsSubscriber{
+		conn:            conn,
+		channel:         chi.URLParam(r, "channel"),
+		encoding:        encoding,
+		compressMinSize: compressMinSize,
+		ackedCh:         make(chan struct{}, 1),
+	}
+	subscriber.lastAck.Store("")
+	subscriber.lastSeen.Store(time.Now().UnixNano())
+	return subscriber, true
+}
+
+func wsLastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get(wsLastEventIDParam)
+}
+
+func (s *wsSubscriber) send(ctx context.Context, msg wsMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	return wsjson.Write(ctx, s.conn, msg)
+}
+
+func (s *wsSubscriber) sendEvent(ctx context.Context, event relayEvent) error {
+	_, span := startRelayEventSpan(ctx, "gosmee.websocket.send", s.channel, event, trace.WithSpanKind(trace.SpanKindProducer))
+	err := s.send(ctx, wsMessage{Type: wsMessageEvent, ID: event.ID, Data: string(compressSSEData(s.encoding, s.compressMinSize, event.Data))})
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	s.sent.Add(1)
+	return nil
+}
+
+// readLoop reads the acks and heartbeats of the client until the connection
+// fails, and cancels the subscription then.
+func (s *wsSubscriber) readLoop(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		var msg wsMessage
+		if err := wsjson.Read(ctx, s.conn, &msg); err != nil {
+			return
+		}
+		s.lastSeen.Store(time.Now().UnixNano())
+		if msg.Type == wsMessageAck {
+			s.acked.Add(1)
+			s.lastAck.Store(msg.ID)
+			select {
+			case s.ackedCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// idle tells whether the client has been silent for too long.
+func (s *wsSubscriber) idle(now time.Time) bool {
+	return now.Sub(time.Unix(0, s.lastSeen.Load())) > wsIdleTimeout
+}
+
+// waitForAcks blocks while the client has wsAckWindow events or more not
+// acked yet.
+func (s *wsSubscriber) waitForAcks(ctx context.Context) error {
+	for s.sent.Load()-s.acked.Load() >= wsAckWindow {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ackedCh:
+		}
+	}
+	return nil
+}
+
+func (s *wsSubscriber) close(ctx context.Context, logger *slog.Logger, reqID, channel string) {
+	logger.LogAttrs(ctx, slog.LevelDebug, "WebSocket subscriber disconnected",
+		slog.String("request_id", reqID), slog.String("channel", channel),
+		slog.Int64("sent", s.sent.Load()), slog.Int64("acked", s.acked.Load()),
+		slog.String("last_ack", s.lastAck.Load().(string)))
+	_ = s.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func closeRevokedWebSocket(ctx context.Context, logger *slog.Logger, s *wsSubscriber, reqID, channel string, pubKey *[32]byte) {
+	logRevokedSubscriber(ctx, logger, reqID, channel, pubKey)
+	_ = s.conn.Close(websocket.StatusPolicyViolation, "client key is no longer allowed on the channel")
+}
+
+// handleEventsWebSocket streams the events of the in-process broker over a
+// WebSocket.
+func handleEventsWebSocket(eventBroker *EventBroker, protectedChannels *ProtectedChannels, corsOrigin string) http.HandlerFunc {
+	logger := eventBroker.logger
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, pubKey, ok := authorizeEventSubscriber(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		ws, ok := acceptWebSocket(w, r, corsOrigin, eventBroker.compressMinSize)
+		if !ok {
+			return
+		}
+		defer ws.conn.CloseNow() //nolint:errcheck // closed already on most paths
+		subscriber := eventBroker.Subscribe(channel, pubKey)
+		defer eventBroker.Unsubscribe(channel, subscriber)
+
+		reqID := middleware.GetReqID(r.Context())
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go ws.readLoop(ctx, cancel)
+
+		if err := ws.send(ctx, wsMessage{Type: wsMessageReady}); err != nil {
+			logger.LogAttrs(ctx, slog.LevelWarn, "WebSocket initial write failed",
+				slog.String("request_id", reqID), slog.String("channel", channel),
+				slog.String("error", err.Error()))
+			return
+		}
+
+		ticker := time.NewTicker(wsHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				ws.close(r.Context(), logger, reqID, channel)
+				return
+
+			case event, ok := <-subscriber.Events:
+				if !ok {
+					ws.close(r.Context(), logger, reqID, channel)
+					return
+				}
+				if !protectedChannels.StillAuthorized(channel, pubKey) {
+					closeRevokedWebSocket(ctx, logger, ws, reqID, channel, pubKey)
+					return
+				}
+				if err := ws.sendEvent(ctx, event); err != nil {
+					logger.LogAttrs(ctx, slog.LevelWarn, "WebSocket event delivery failed",
+						slog.String("request_id", reqID),
+						slog.String("channel", channel), slog.String("delivery_id", event.DeliveryID),
+						slog.String("stream_id", event.ID), slog.String("event_type", event.EventType),
+						slog.String("error", err.Error()))
+					return
+				}
+
+			case now := <-ticker.C:
+				if !protectedChannels.StillAuthorized(channel, pubKey) {
+					closeRevokedWebSocket(ctx, logger, ws, reqID, channel, pubKey)
+					return
+				}
+				if ws.idle(now) {
+					_ = ws.conn.Close(websocket.StatusGoingAway, "no heartbeat")
+					return
+				}
+				if err := ws.send(ctx, wsMessage{Type: wsMessageHeartbeat}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleRedisEventsWebSocket streams a Redis stream over a WebSocket,
+// resuming like handleRedisEventsGet.
+func handleRedisEventsWebSocket(redisRelay *redisPayloadRelay, protectedChannels *ProtectedChannels, corsOrigin string, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, pubKey, ok := authorizeEventSubscriber(w, r, protectedChannels)
+		if !ok {
+			return
+		}
+		readAfterID, gapEvent, status, err := redisResumePosition(r.Context(), redisRelay, channel, wsLastEventID(r))
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		ws, ok := acceptWebSocket(w, r, corsOrigin, redisRelay.compressMinSize)
+		if !ok {
+			return
+		}
+		defer ws.conn.CloseNow() //nolint:errcheck // closed already on most paths
+
+		reqID := middleware.GetReqID(r.Context())
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go ws.readLoop(ctx, cancel)
+
+		if err := ws.send(ctx, wsMessage{Type: wsMessageReady}); err != nil {
+			logger.LogAttrs(ctx, slog.LevelWarn, "WebSocket initial write failed",
+				slog.String("request_id", reqID), slog.String("channel", channel),
+				slog.String("error", err.Error()))
+			return
+		}
+		if len(gapEvent) > 0 {
+			if err := ws.send(ctx, wsMessage{Type: wsMessageGap, Data: string(gapEvent)}); err != nil {
+				return
+			}
+		}
+
+		for {
+			if !protectedChannels.StillAuthorized(channel, pubKey) {
+				closeRevokedWebSocket(ctx, logger, ws, reqID, channel, pubKey)
+				return
+			}
+			if ws.idle(time.Now()) {
+				_ = ws.conn.Close(websocket.StatusGoingAway, "no heartbeat")
+				return
+			}
+			if err := ws.waitForAcks(ctx); err != nil {
+				ws.close(r.Context(), logger, reqID, channel)
+				return
+			}
+			events, err := redisRelay.Read(ctx, channel, readAfterID, wsHeartbeatInterval, wsAckWindow-(ws.sent.Load()-ws.acked.Load()))
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.LogAttrs(ctx, slog.LevelWarn, "Redis stream read failed",
+						slog.String("request_id", reqID), slog.String("channel", channel),
+						slog.String("error", err.Error()))
+				}
+				ws.close(r.Context(), logger, reqID, channel)
+				return
+			}
+			if len(events) == 0 {
+				if ctx.Err() != nil {
+					ws.close(r.Context(), logger, reqID, channel)
+					return
+				}
+				if err := ws.send(ctx, wsMessage{Type: wsMessageHeartbeat}); err != nil {
+					return
+				}
+				continue
+			}
+			for _, event := range events {
+				event, err = encryptRelayEvent(event, channel, pubKey, redisRelay.signingKey)
+				if err != nil {
+					logger.LogAttrs(ctx, slog.LevelWarn, "Redis WebSocket encryption failed",
+						slog.String("request_id", reqID), slog.String("channel", channel),
+						slog.String("stream_id", event.ID), slog.String("error", err.Error()))
+					continue
+				}
+				if err := ws.sendEvent(ctx, event); err != nil {
+					logger.LogAttrs(ctx, slog.LevelWarn, "Redis WebSocket event delivery failed",
+						slog.String("request_id", reqID),
+						slog.String("channel", channel), slog.String("delivery_id", event.DeliveryID),
+						slog.String("stream_id", event.ID), slog.String("event_type", event.EventType),
+						slog.String("error", err.Error()))
+					return
+				}
+				readAfterID = event.ID
+			}
+		}
+	}
+}
+
+// errWebSocketUnavailable is returned when the WebSocket handshake fails, the
+// client falls back to SSE then.
+var errWebSocketUnavailable = errors.New("websocket transport unavailable")
+
+// subscriptionEndpoint returns the URL of another subscription endpoint of
+// the gosmee server an SSE URL points to, such as ws or poll.
+func subscriptionEndpoint(sseURL, endpoint string) (*url.URL, error) {
+	parsed, err := url.Parse(sseURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix, channel, ok := strings.Cut(parsed.Path, "/events/")
+	if !ok {
+		return nil, fmt.Errorf("%s is not a gosmee server events URL", sseURL)
+	}
+	parsed.Path = prefix + "/" + endpoint + "/" + channel
+	return parsed, nil
+}
+
+// webSocketURL returns the WebSocket endpoint matching an SSE one.
+func webSocketURL(sseURL string) (string, error) {
+	parsed, err := subscriptionEndpoint(sseURL, "ws")
+	if err != nil {
+		return "", err
+	}
+	switch parsed.Scheme {
+	case "https":
+		parsed.Scheme = "wss"
+	case "http":
+		parsed.Scheme = "ws"
+	}
+	return parsed.String(), nil
+}
+
+// consumeWebSocket subscribes over a WebSocket and processes its events like
+// consumeSSEStream, acking each event once processed.
+func (c goSmee) consumeWebSocket(ctx context.Context, httpClient *http.Client, sseURL, version string, privateKey *[32]byte, state *resumeState, reconnectBackoff *retryBackoff) error {
+	wsURL, err := webSocketURL(sseURL)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errWebSocketUnavailable, err)
+	}
+	header := http.Header{}
+	header.Set("User-Agent", fmt.Sprintf("gosmee/%s", version))
+	if len(c.replayDataOpts.sseEncodings) > 0 {
+		header.Set(acceptEncodingHeader, strings.Join(c.replayDataOpts.sseEncodings, ", "))
+	}
+	if state.ID() != "" {
+		header.Set("Last-Event-ID", state.ID())
+	}
+	if err := c.replayDataOpts.relayAuth.Apply(header); err != nil {
+		return err
+	}
+
+	conn, resp, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{HTTPClient: httpClient, HTTPHeader: header})
+	if err != nil {
+		// a refused key and an older server without the endpoint both
+		// answer 404, the SSE fallback tells them apart
+		if resp != nil {
+			return fmt.Errorf("%w: handshake returned %s", errWebSocketUnavailable, resp.Status)
+		}
+		return fmt.Errorf("%w: %w", errWebSocketUnavailable, err)
+	}
+	defer conn.CloseNow()
+	// events are read whole, unlike SSE lines through --sse-buffer-size,
+	// with the bound of decompressed payloads
+	conn.SetReadLimit(maxDecompressedSize)
+	reconnectBackoff.Reset()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var writeMu sync.Mutex
+	send := func(msg wsMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		writeCtx, writeCancel := context.WithTimeout(ctx, wsWriteTimeout)
+		defer writeCancel()
+		return wsjson.Write(writeCtx, conn, msg)
+	}
+	go func() {
+		ticker := time.NewTicker(wsHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := send(wsMessage{Type: wsMessageHeartbeat}); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		readCtx, readCancel := context.WithTimeout(ctx, wsIdleTimeout)
+		var msg wsMessage
+		err := wsjson.Read(readCtx, conn, &msg)
+		readCancel()
+		if err != nil {
+			if status := websocket.CloseStatus(err); status != -1 {
+				if status == websocket.StatusPolicyViolation && privateKey != nil {
+					return fmt.Errorf("%w: %w", errSubscriptionRejected, err)
+				}
+				return fmt.Errorf("WebSocket closed: %w", err)
+			}
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("WebSocket idle for %s", wsIdleTimeout)
+			}
+			return fmt.Errorf("read WebSocket: %w", err)
+		}
+
+		var event clientSSEEvent
+		switch msg.Type {
+		case wsMessageReady:
+			event = clientSSEEvent{Event: "ready"}
+		case wsMessageGap:
+			event = clientSSEEvent{Event: "gosmee-gap", Data: []byte(msg.Data)}
+		case wsMessageEvent:
+			event = clientSSEEvent{ID: msg.ID, Data: []byte(msg.Data)}
+		default:
+			continue
+		}
+		if err := c.processClientEventWithRetry(ctx, event, privateKey, state); err != nil {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+			return err
+		}
+		if msg.Type == wsMessageEvent {
+			if err := send(wsMessage{Type: wsMessageAck, ID: msg.ID}); err != nil {
+				return fmt.Errorf("ack WebSocket event: %w", err)
+			}
+		}
+	}
+}
+
+// parseTransport reads the --transport flag.
+func parseTransport(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "", transportSSE:
+		return transportSSE, nil
+	case transportWebSocket, "ws":
+		return transportWebSocket, nil
+	case transportPoll:
+		return transportPoll, nil
+	default:
+		return "", fmt.Errorf("invalid --transport %q, supported: %s, %s or %s", value, transportSSE, transportWebSocket, transportPoll)
+	}
+}
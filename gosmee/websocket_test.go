@@ -0,0 +1,243 @@
+package gosmee
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"gotest.tools/v3/assert"
+)
+
+func dialTestWebSocket(t *testing.T, ctx context.Context, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header})
+	assert.NilError(t, err)
+	t.Cleanup(func() { _ = conn.CloseNow() })
+	return conn
+}
+
+func readTestWebSocket(t *testing.T, ctx context.Context, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	var msg wsMessage
+	assert.NilError(t, wsjson.Read(ctx, conn, &msg))
+	return msg
+}
+
+func TestWebSocketURL(t *testing.T) {
+	wsURL, err := webSocketURL("https://example.com/gosmee/events/test-channel?pubkey=abc")
+	assert.NilError(t, err)
+	assert.Equal(t, wsURL, "wss://example.com/gosmee/ws/test-channel?pubkey=abc")
+
+	wsURL, err = webSocketURL("http://localhost:3333/events/test-channel")
+	assert.NilError(t, err)
+	assert.Equal(t, wsURL, "ws://localhost:3333/ws/test-channel")
+
+	_, err = webSocketURL("https://smee.io/aBcDeF")
+	assert.ErrorContains(t, err, "not a gosmee server events URL")
+}
+
+func TestParseTransport(t *testing.T) {
+	transport, err := parseTransport("")
+	assert.NilError(t, err)
+	assert.Equal(t, transport, transportSSE)
+	transport, err = parseTransport("WebSocket")
+	assert.NilError(t, err)
+	assert.Equal(t, transport, transportWebSocket)
+	_, err = parseTransport("grpc")
+	assert.ErrorContains(t, err, `invalid --transport "grpc"`)
+}
+
+func TestHandleEventsWebSocket(t *testing.T) {
+	eventBroker := NewEventBroker()
+	allowedKey := mustGeneratePublicKey(t)
+	protectedChannels := mustProtectedChannels(t, map[string][]string{
+		"test-channel": {allowedKey},
+	})
+	router := chi.NewRouter()
+	router.Get(wsPath, handleEventsWebSocket(eventBroker, protectedChannels, "*"))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	t.Run("protected channel without an allowed key", func(t *testing.T) {
+		_, resp, err := websocket.Dial(context.Background(), wsURL+"/ws/test-channel", nil)
+		assert.Assert(t, err != nil)
+		assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+	})
+
+	t.Run("events and heartbeats", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn := dialTestWebSocket(t, ctx, wsURL+"/ws/plain-channel", nil)
+		assert.Equal(t, readTestWebSocket(t, ctx, conn).Type, wsMessageReady)
+
+		assert.Assert(t, eventually(t, func() bool {
+			eventBroker.RLock()
+			defer eventBroker.RUnlock()
+			return len(eventBroker.subscribers["plain-channel"]) == 1
+		}))
+		eventBroker.Publish("plain-channel", []byte(`{"plain":true}`))
+		msg := readTestWebSocket(t, ctx, conn)
+		assert.Equal(t, msg.Type, wsMessageEvent)
+		assert.Equal(t, msg.Data, `{"plain":true}`)
+
+		assert.NilError(t, wsjson.Write(ctx, conn, wsMessage{Type: wsMessageHeartbeat}))
+		assert.NilError(t, wsjson.Write(ctx, conn, wsMessage{Type: wsMessageAck}))
+		assert.NilError(t, conn.Close(websocket.StatusNormalClosure, ""))
+		assert.Assert(t, eventually(t, func() bool {
+			eventBroker.RLock()
+			defer eventBroker.RUnlock()
+			return len(eventBroker.subscribers["plain-channel"]) == 0
+		}))
+	})
+}
+
+func TestHandleRedisEventsWebSocket(t *testing.T) {
+	protectedChannels, err := LoadProtectedChannels("")
+	assert.NilError(t, err)
+
+	newServer := func(client *fakeRedisStreamClient) string {
+		relay := newRedisPayloadRelayWithClient(client, 10000)
+		router := chi.NewRouter()
+		router.Get(wsPath, handleRedisEventsWebSocket(relay, protectedChannels, "*", slog.New(slog.DiscardHandler)))
+		server := httptest.NewServer(router)
+		t.Cleanup(server.Close)
+		return "ws" + strings.TrimPrefix(server.URL, "http")
+	}
+
+	t.Run("resumes after Last-Event-ID and reports gaps", func(t *testing.T) {
+		client := &fakeRedisStreamClient{
+			xrangeMessages: []redis.XMessage{{ID: "1700000000005-0"}},
+			xreadResults: [][]redis.XStream{{{
+				Stream: "gosmee:stream:test-channel",
+				Messages: []redis.XMessage{{
+					ID:     "1700000000005-0",
+					Values: map[string]any{"payload": `{"newer":true}`},
+				}},
+			}}},
+		}
+		wsURL := newServer(client)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn := dialTestWebSocket(t, ctx, wsURL+"/ws/test-channel", http.Header{"Last-Event-ID": {"1700000000001-0"}})
+
+		assert.Equal(t, readTestWebSocket(t, ctx, conn).Type, wsMessageReady)
+		gap := readTestWebSocket(t, ctx, conn)
+		assert.Equal(t, gap.Type, wsMessageGap)
+		assert.Assert(t, strings.Contains(gap.Data, "missed_history"))
+		msg := readTestWebSocket(t, ctx, conn)
+		assert.Equal(t, msg.Type, wsMessageEvent)
+		assert.Equal(t, msg.ID, "1700000000005-0")
+		assert.Equal(t, msg.Data, `{"newer":true}`)
+
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		assert.DeepEqual(t, client.xreadStreams[0], []string{"gosmee:stream:test-channel", "0-0"})
+	})
+
+	t.Run("malformed Last-Event-ID is rejected before the upgrade", func(t *testing.T) {
+		wsURL := newServer(&fakeRedisStreamClient{})
+		_, resp, err := websocket.Dial(context.Background(), wsURL+"/ws/test-channel?last_event_id=nope", nil)
+		assert.Assert(t, err != nil)
+		assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	})
+}
+
+func TestWebSocketSubscriberAckWindow(t *testing.T) {
+	s := &wsSubscriber{ackedCh: make(chan struct{}, 1)}
+	s.sent.Store(wsAckWindow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.waitForAcks(ctx), context.DeadlineExceeded)
+
+	s.acked.Store(1)
+	s.ackedCh <- struct{}{}
+	assert.NilError(t, s.waitForAcks(context.Background()))
+}
+
+func TestRunSSEClientWebSocket(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	t.Run("events are processed and checkpointed", func(t *testing.T) {
+		client := &fakeRedisStreamClient{
+			xreadResults: [][]redis.XStream{{{
+				Stream: "gosmee:stream:test-channel",
+				Messages: []redis.XMessage{{
+					ID:     "1700000000001-0",
+					Values: map[string]any{"payload": simpleJSON},
+				}},
+			}}},
+		}
+		relay := newRedisPayloadRelayWithClient(client, 10000)
+		protectedChannels, err := LoadProtectedChannels("")
+		assert.NilError(t, err)
+		router := chi.NewRouter()
+		router.Get(wsPath, handleRedisEventsWebSocket(relay, protectedChannels, "*", slog.New(slog.DiscardHandler)))
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		statePath := filepath.Join(t.TempDir(), "resume.state")
+		checkpointed := func() bool {
+			data, err := os.ReadFile(statePath)
+			return err == nil && string(data) == "1700000000001-0\n"
+		}
+		go func() {
+			if eventually(t, checkpointed) {
+				cancel()
+			}
+		}()
+
+		_, sseURL, _, err := prepareSubscription(server.URL+"/test-channel", "", nil)
+		assert.NilError(t, err)
+		gs := newTestGoSmeeForProcessing(&replayDataOpts{targetURL: target.URL, transport: transportWebSocket, resumeStateFile: statePath})
+		err = gs.runSSEClient(ctx, []sseSubscription{{url: sseURL}}, "dev")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Assert(t, checkpointed())
+	})
+
+	t.Run("falls back to SSE", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var mu sync.Mutex
+		var paths []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			paths = append(paths, r.URL.Path)
+			mu.Unlock()
+			if strings.HasPrefix(r.URL.Path, "/ws/") {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			cancel()
+		}))
+		defer server.Close()
+
+		_, sseURL, _, err := prepareSubscription(server.URL+"/test-channel", "", nil)
+		assert.NilError(t, err)
+		gs := newTestGoSmeeForProcessing(&replayDataOpts{transport: transportWebSocket})
+		err = gs.runSSEClient(ctx, []sseSubscription{{url: sseURL}}, "dev")
+		assert.ErrorIs(t, err, context.Canceled)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.DeepEqual(t, paths, []string{"/ws/test-channel", "/events/test-channel"})
+	})
+}